@@ -1,20 +1,34 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/gob"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/byt3er/bookings/internals/config"
 	"github.com/byt3er/bookings/internals/driver"
+	"github.com/byt3er/bookings/internals/email"
 	"github.com/byt3er/bookings/internals/handlers"
 	"github.com/byt3er/bookings/internals/helpers"
+	"github.com/byt3er/bookings/internals/mailer"
 	"github.com/byt3er/bookings/internals/models"
+	"github.com/byt3er/bookings/internals/outbox"
+	"github.com/byt3er/bookings/internals/payments"
+	"github.com/byt3er/bookings/internals/pricing"
 	"github.com/byt3er/bookings/internals/render"
+	"github.com/byt3er/bookings/internals/repository/dbrepo"
+	"github.com/byt3er/bookings/internals/sessionstore"
+	"github.com/byt3er/bookings/internals/signer"
+	"github.com/byt3er/bookings/internals/throttle"
 
 	"github.com/alexedwards/scs/v2"
 )
@@ -26,6 +40,19 @@ var session *scs.SessionManager
 var infoLog *log.Logger
 var errorLog *log.Logger
 
+// mailEnv names the environment variables the mail flags default to,
+// so the mail server password doesn't have to be on the command line
+var mailEnv = map[string]string{
+	"mailhost":           "MAIL_HOST",
+	"mailport":           "MAIL_PORT",
+	"mailuser":           "MAIL_USERNAME",
+	"mailpass":           "MAIL_PASSWORD",
+	"mailencryption":     "MAIL_ENCRYPTION",
+	"mailfrom":           "MAIL_FROM",
+	"mailconnecttimeout": "MAIL_CONNECT_TIMEOUT",
+	"mailsendtimeout":    "MAIL_SEND_TIMEOUT",
+}
+
 // main is the main function
 func main() {
 
@@ -35,17 +62,18 @@ func main() {
 	}
 	defer db.SQL.Close()
 
-	// close the mailChan channel
-	defer close(app.MailChan)
-	listenForMail()
+	// stop on Ctrl+C, and on SIGTERM from whatever runs the application
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// try sending an email message
-	// msg := models.MailData{}
-	// msg.To = "jhon@do.ca"
-	// msg.From = "me@here.com"
-	// msg.Subject = "Some subject"
-	// msg.Content = "<h1>Hello, World!</h1>"
-	// app.MailChan <- msg
+	repo := dbrepo.NewPostgresRepo(db.SQL, &app)
+	stopMail := listenForMail(repo)
+	// the housekeeping stops with the first signal, it is done again after the next start
+	go purgeTrash(ctx, repo)
+	go cleanupUserSessions(ctx, repo)
+	if store, ok := session.Store.(*sessionstore.PostgresStore); ok {
+		go cleanupSessions(ctx, store)
+	}
 
 	fmt.Println(fmt.Sprintf("Staring application on port %s", portNumber))
 
@@ -54,10 +82,32 @@ func main() {
 		Handler: routes(&app),
 	}
 
-	err = srv.ListenAndServe()
-	if err != nil {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serveErr:
 		log.Fatal(err)
+	case <-ctx.Done():
 	}
+	// a second signal stops the application straight away
+	stop()
+
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	// let the requests in progress finish, so the mail they queue is sent below
+	if err = srv.Shutdown(shutdownCtx); err != nil {
+		errorLog.Println("Stopping the web server:", err)
+	}
+	// messages that aren't sent in time stay in the outbox for the next start
+	if err = stopMail(shutdownCtx); err != nil {
+		errorLog.Println("Sending the queued mail:", err)
+	}
+	log.Println("Stopped")
 }
 
 func run() (*driver.DB, error) {
@@ -76,8 +126,40 @@ func run() (*driver.DB, error) {
 	dbPass := flag.String("dbpass", "", "Database password")
 	dbPort := flag.String("dbport", "5432", "Database port")
 	dbSSL := flag.String("dbssl", "disable", "Database ssl settings (disable, prefer, require")
+	dbTimeout := flag.Duration("dbtimeout", 3*time.Second, "Timeout for a single database query")
+	sessionStore := flag.String("sessionstore", "postgres", "Where sessions are kept (postgres, memory)")
+	secret := flag.String("secret", "", "Key for signing links in emails, required in production")
+	baseURL := flag.String("baseurl", "http://localhost"+portNumber, "Public address of the site, for links in emails")
+	cancelCutoff := flag.Int("cancelcutoff", 7, "Days before arrival a guest can cancel for free")
+	cancelFee := flag.Int("cancelfee", 50, "Percent of the total kept when a guest cancels after the cutoff")
+	cancelFeeMin := flag.Int("cancelfeemin", 0, "Least amount in cents kept when a guest cancels after the cutoff")
+	trashRetention := flag.Duration("trashretention", 30*24*time.Hour, "How long deleted reservations can be restored")
+	loginFailures := flag.Int("loginfailures", 5, "Failed logins for an email address before it is locked out")
+	loginIPFailures := flag.Int("loginipfailures", 20, "Failed logins from an IP address before it is locked out")
+	twoFactorLevel := flag.Int("twofactorlevel", int(models.RoleFrontDesk), "Users above this access level must use two-factor login")
+	loginLockout := flag.Duration("loginlockout", time.Minute, "How long the first lockout after too many failed logins lasts")
+	mailHost := flag.String("mailhost", "localhost", "Mail server host (MAIL_HOST)")
+	mailPort := flag.Int("mailport", 1025, "Mail server port, usually 587 or 465 for a real one (MAIL_PORT)")
+	mailUser := flag.String("mailuser", "", "Mail server user name, empty for none (MAIL_USERNAME)")
+	mailPass := flag.String("mailpass", "", "Mail server password (MAIL_PASSWORD)")
+	mailEncryption := flag.String("mailencryption", mailer.EncryptionNone, "Mail server encryption (none, ssltls, starttls) (MAIL_ENCRYPTION)")
+	mailFrom := flag.String("mailfrom", "me@here.com", "Sender address of emails (MAIL_FROM)")
+	mailConnectTimeout := flag.Duration("mailconnecttimeout", 10*time.Second, "How long connecting to the mail server may take (MAIL_CONNECT_TIMEOUT)")
+	mailSendTimeout := flag.Duration("mailsendtimeout", 10*time.Second, "How long sending an email may take (MAIL_SEND_TIMEOUT)")
+	mailAttempts := flag.Int("mailattempts", 8, "Failed sends of an email before it is given up on")
+	mailBackoff := flag.Duration("mailbackoff", time.Minute, "How long to wait before sending an email again after it first failed")
+	mailWorkers := flag.Int("mailworkers", 4, "Emails sent at the same time")
+	shutdownTimeout := flag.Duration("shutdowntimeout", 30*time.Second, "How long finishing requests and sending queued mail may take when stopping")
+	propertyName := flag.String("propertyname", "Fort Smythe", "Name of the property, in calendar events for guests")
+	propertyAddress := flag.String("propertyaddress", "", "Address of the property, in emails and calendar events for guests")
+	checkIn := flag.String("checkin", "15:00", "Time of day guests can check in from")
+	checkOut := flag.String("checkout", "11:00", "Time of day guests must check out by")
+	timeZone := flag.String("timezone", "Local", "Time zone of the property, e.g. America/Toronto")
 
 	flag.Parse()
+	if err := flagsFromEnv(mailEnv); err != nil {
+		return nil, err
+	}
 	if *dbName == "" || *dbUser == "" {
 		fmt.Println("Missing requied flag to start the application")
 		// stop the application
@@ -87,10 +169,32 @@ func run() (*driver.DB, error) {
 	// for the blockMap in the admin/reservation-calendar
 	gob.Register(map[string]int{})
 
-	// create a channel
-	mailChain := make(chan models.MailData)
-	// make is avaiable to every part of the application
-	app.MailChan = mailChain
+	// messages wait in the outbox until they are sent; one wake up
+	// is enough for the worker to send everything queued before it
+	app.MailQueued = make(chan struct{}, 1)
+
+	app.Mail = mailer.Config{
+		Host:           *mailHost,
+		Port:           *mailPort,
+		Username:       *mailUser,
+		Password:       *mailPass,
+		Encryption:     *mailEncryption,
+		From:           *mailFrom,
+		ConnectTimeout: *mailConnectTimeout,
+		SendTimeout:    *mailSendTimeout,
+	}
+	if err := app.Mail.Validate(); err != nil {
+		return nil, err
+	}
+	app.Mailer = mailer.NewSMTP(app.Mail)
+	// every failed send doubles the wait before the next one, up to 6 hours
+	app.MailRetry = outbox.Policy{
+		MaxAttempts: *mailAttempts,
+		Backoff:     *mailBackoff,
+		MaxBackoff:  6 * time.Hour,
+	}
+	app.MailWorkers = *mailWorkers
+	app.ShutdownTimeout = *shutdownTimeout
 
 	// change this to true when in production
 	app.InProduction = *inProduction
@@ -110,6 +214,59 @@ func run() (*driver.DB, error) {
 	//====================================================
 	app.Session = session
 	//====================================================
+	// card payments; swap in a real provider's Gateway here
+	app.Payments = payments.NewFakeGateway()
+
+	// links mailed to guests
+	key := []byte(*secret)
+	if len(key) == 0 {
+		// links stop working when the application restarts, and guests
+		// can't cancel with the links they were mailed
+		if app.InProduction {
+			return nil, fmt.Errorf("-secret is required in production, for signing links in emails")
+		}
+		log.Println("No -secret given, using a random key for signing links")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	app.Signer = signer.New(key)
+	app.BaseURL = strings.TrimSuffix(*baseURL, "/")
+	app.Cancellation = pricing.CancellationPolicy{
+		CutoffDays:     *cancelCutoff,
+		LateFeePercent: *cancelFee,
+		LateFeeMin:     *cancelFeeMin,
+	}
+	app.TrashRetention = *trashRetention
+	// every failed login after the limit doubles the lockout, up to an hour
+	app.LoginAccount = throttle.Policy{
+		MaxFailures: *loginFailures,
+		Window:      15 * time.Minute,
+		Lockout:     *loginLockout,
+		MaxLockout:  time.Hour,
+	}
+	app.LoginIP = throttle.Policy{
+		MaxFailures: *loginIPFailures,
+		Window:      15 * time.Minute,
+		Lockout:     *loginLockout,
+		MaxLockout:  time.Hour,
+	}
+	app.TwoFactorAccessLevel = *twoFactorLevel
+
+	// where guests stay, for the calendar events mailed to them
+	app.Property = models.Property{Name: *propertyName, Address: *propertyAddress}
+	var err error
+	if app.Property.CheckIn, err = timeOfDay(*checkIn); err != nil {
+		return nil, fmt.Errorf("checkin: %w", err)
+	}
+	if app.Property.CheckOut, err = timeOfDay(*checkOut); err != nil {
+		return nil, fmt.Errorf("checkout: %w", err)
+	}
+	if app.Property.Location, err = time.LoadLocation(*timeZone); err != nil {
+		return nil, fmt.Errorf("timezone: %w", err)
+	}
+
 	// connect to the database
 	log.Println("Connecting to database....")
 	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s",
@@ -126,6 +283,17 @@ func run() (*driver.DB, error) {
 		log.Fatal("Cannot connect to the database! Dying...")
 	}
 	log.Println("Connect to database!")
+	app.DBTimeout = *dbTimeout
+
+	// sessions in memory are lost whenever the application restarts
+	switch *sessionStore {
+	case "postgres":
+		session.Store = sessionstore.New(db.SQL, app.DBTimeout)
+	case "memory":
+		// the default store of scs
+	default:
+		return nil, fmt.Errorf("unknown session store %q", *sessionStore)
+	}
 
 	// ======================================================
 
@@ -138,14 +306,50 @@ func run() (*driver.DB, error) {
 	app.TemplateCache = tc
 	app.UseCache = *useCache
 
+	mtc, err := email.CreateTemplateCache("./email-template")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create email template cache: %w", err)
+	}
+	app.MailTemplateCache = mtc
+
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
 
 	// initialize the renderer
 	render.NewRenderer(&app)
+	email.NewRenderer(&app)
 
 	// initialize the helper
 	helpers.NewHelpers(&app)
 
 	return db, nil
 }
+
+// flagsFromEnv sets the flags that weren't given on the command line
+// from the environment variables env names for them
+func flagsFromEnv(env map[string]string) error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for name, key := range env {
+		value, ok := os.LookupEnv(key)
+		if !ok || given[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// timeOfDay reads a time of day given as 15:04, as the time after midnight
+func timeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q isn't a time of day like 15:00", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -1,6 +1,10 @@
 package main
 
-import "testing"
+import (
+	"flag"
+	"testing"
+	"time"
+)
 
 func TestRun(t *testing.T) {
 	_, err := run()
@@ -8,3 +12,46 @@ func TestRun(t *testing.T) {
 		t.Error("failed run()")
 	}
 }
+
+func TestFlagsFromEnv(t *testing.T) {
+	given := flag.String("testgiven", "default", "")
+	fromEnv := flag.Int("testfromenv", 1, "")
+	flag.Int("testinvalid", 1, "")
+	if err := flag.Set("testgiven", "flag"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_GIVEN", "env")
+	t.Setenv("TEST_FROM_ENV", "2")
+	t.Setenv("TEST_INVALID", "two")
+
+	err := flagsFromEnv(map[string]string{"testgiven": "TEST_GIVEN", "testfromenv": "TEST_FROM_ENV"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if *given != "flag" {
+		t.Errorf("expected the flag to win over the environment, but got %q", *given)
+	}
+	if *fromEnv != 2 {
+		t.Errorf("expected the flag from the environment, but got %d", *fromEnv)
+	}
+
+	if err = flagsFromEnv(map[string]string{"testinvalid": "TEST_INVALID"}); err == nil {
+		t.Error("expected an error for an invalid value")
+	}
+}
+
+func TestTimeOfDay(t *testing.T) {
+	d, err := timeOfDay("15:30")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if d != 15*time.Hour+30*time.Minute {
+		t.Errorf("expected 15h30m but got %v", d)
+	}
+
+	for _, bad := range []string{"", "3pm", "25:00", "15"} {
+		if _, err := timeOfDay(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
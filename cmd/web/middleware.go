@@ -1,9 +1,14 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
+	"strings"
 
+	"github.com/byt3er/bookings/internals/handlers"
 	"github.com/byt3er/bookings/internals/helpers"
+	"github.com/byt3er/bookings/internals/models"
 	"github.com/justinas/nosurf"
 )
 
@@ -46,3 +51,70 @@ func Auth(next http.Handler) http.Handler {
 
 	})
 }
+
+// LoadUser puts the logged in user in the request context, so that handlers,
+// templates and RequirePermission know who is asking. A user that no longer
+// exists or has been disabled, or whose session was ended elsewhere, is logged out.
+func LoadUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !helpers.IsAuthenticate(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u, err := handlers.Repo.DB.GetUserByID(r.Context(), session.GetInt(r.Context(), "user_id"))
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !u.DisabledAt.IsZero()) {
+			session.Remove(r.Context(), "user_id")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		// sessions from before they were recorded have no key, and are ended too
+		err = handlers.Repo.DB.TouchUserSession(r.Context(), session.GetString(r.Context(), "session_key"), u.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = session.Destroy(r.Context())
+			_ = session.RenewToken(r.Context())
+			session.Put(r.Context(), "error", "Your session has ended, please log in again")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithUser(r.Context(), u)))
+	})
+}
+
+// RequirePermission only lets users whose role has permission p through;
+// everyone else gets the forbidden page
+func RequirePermission(p models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !helpers.CurrentUser(r).Can(p) {
+				handlers.Repo.Forbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireTwoFactor sends users who must use two-factor login, but haven't set it up,
+// to the page for setting it up
+func RequireTwoFactor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := helpers.CurrentUser(r)
+		if u.TwoFactorRequired(app.TwoFactorAccessLevel) && !strings.HasPrefix(r.URL.Path, "/admin/two-factor") {
+			session.Put(r.Context(), "Warning", "Please set up two-factor login to keep using the admin tool")
+			http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,7 +3,13 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/byt3er/bookings/internals/helpers"
+	"github.com/byt3er/bookings/internals/models"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -38,3 +44,71 @@ func TestSessionLoad(t *testing.T) {
 	}
 
 }
+
+func TestLoadUser(t *testing.T) {
+	var myH myHandler
+
+	h := LoadUser(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing , passed the test
+	default:
+		t.Error(fmt.Sprintf(" type is not http.Handler but is %T", v))
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	h := RequirePermission(models.PermManageRooms)(next)
+
+	// a manager may manage rooms
+	req, _ := http.NewRequest("GET", "/admin/rooms/1/show", nil)
+	req = req.WithContext(helpers.WithUser(req.Context(), models.User{ID: 1, AccessLevel: int(models.RoleManager)}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !called {
+		t.Error("expected a manager to be let through")
+	}
+}
+
+var requireTwoFactorTests = []struct {
+	name       string
+	path       string
+	user       models.User
+	expectNext bool
+}{
+	{"owner-without", "/admin/dashboard", models.User{ID: 1, AccessLevel: int(models.RoleOwner)}, false},
+	{"owner-setting-up", "/admin/two-factor", models.User{ID: 1, AccessLevel: int(models.RoleOwner)}, true},
+	{"owner-with", "/admin/dashboard", models.User{ID: 1, AccessLevel: int(models.RoleOwner), TwoFactorEnabledAt: time.Now()}, true},
+	{"front-desk-without", "/admin/dashboard", models.User{ID: 2, AccessLevel: int(models.RoleFrontDesk)}, true},
+}
+
+func TestRequireTwoFactor(t *testing.T) {
+	session = scs.New()
+	app.TwoFactorAccessLevel = int(models.RoleFrontDesk)
+
+	for _, e := range requireTwoFactorTests {
+		called := false
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})
+		h := session.LoadAndSave(RequireTwoFactor(next))
+
+		req, _ := http.NewRequest("GET", e.path, nil)
+		req = req.WithContext(helpers.WithUser(req.Context(), e.user))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if called != e.expectNext {
+			t.Errorf("failed %s: expected to be let through to be %v", e.name, e.expectNext)
+		}
+		if !called && rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+	}
+}
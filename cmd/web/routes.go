@@ -5,6 +5,7 @@ import (
 
 	"github.com/byt3er/bookings/internals/config"
 	"github.com/byt3er/bookings/internals/handlers"
+	"github.com/byt3er/bookings/internals/models"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -15,55 +16,149 @@ func routes(app *config.AppConfig) http.Handler {
 
 	// middlewares
 	mux.Use(middleware.Recoverer)
-	mux.Use(NoSurf)
-	mux.Use(SessionLoad)
 
-	// routes for serving static content
+	// routes for serving static content, which need no session or user
 	mux.Handle("/static/*", http.StripPrefix("/static", http.FileServer(http.Dir("./static/"))))
 	mux.Handle("/favicon.ico", http.NotFoundHandler())
 
-	mux.Get("/", handlers.Repo.Home)
-	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/contact", handlers.Repo.Contact)
-
-	mux.Get("/generals-quaters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
-
-	mux.Get("/search-availability", handlers.Repo.Availability)
-	mux.Post("/search-availability", handlers.Repo.PostAvailability)
-	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
-	// here {id} is the variable name
-	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
-	mux.Get("/book-room", handlers.Repo.BookRoom)
-
-	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
-	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
-
-	mux.Get("/user/login", handlers.Repo.ShowLogin)
-	mux.Post("/user/login", handlers.Repo.PostShowLogin)
-	mux.Get("/user/logout", handlers.Repo.Logout)
-
-	// need to set a pattern "/admin"
-	// anything that starts with admin will be handled by this function(mux.Route function)
-	// on the mux router
-	mux.Route("/admin", func(mux chi.Router) {
-		// we're going to use Auth middleware to only apply to things
-		// that are inside thid mux.Route func
-		// mux.Use(Auth)
-
-		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
-		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
-		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
-		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalender)
-		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalender)
-		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
-
-		// src and id are matching parameters or matching parts of the route
-		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
-		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+	mux.Group(func(mux chi.Router) {
+		mux.Use(NoSurf)
+		mux.Use(SessionLoad)
+		mux.Use(LoadUser)
 
+		mux.Get("/", handlers.Repo.Home)
+		mux.Get("/about", handlers.Repo.About)
+		mux.Get("/contact", handlers.Repo.Contact)
+
+		mux.Get("/rooms", handlers.Repo.Rooms)
+		mux.Get("/rooms/{slug}", handlers.Repo.Room)
+		// the old room pages, kept so existing links keep working
+		mux.Handle("/generals-quaters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+		mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
+
+		mux.Get("/search-availability", handlers.Repo.Availability)
+		mux.Post("/search-availability", handlers.Repo.PostAvailability)
+		mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+		// here {id} is the variable name
+		mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
+		mux.Get("/book-room", handlers.Repo.BookRoom)
+
+		mux.Get("/make-reservation", handlers.Repo.Reservation)
+		mux.Post("/make-reservation", handlers.Repo.PostReservation)
+		mux.Get("/checkout", handlers.Repo.Checkout)
+		mux.Post("/checkout", handlers.Repo.PostCheckout)
+		mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+		mux.Get("/reservation/lookup", handlers.Repo.LookupReservation)
+		mux.Post("/reservation/lookup", handlers.Repo.PostLookupReservation)
+		mux.Get("/reservation/{code}/cancel", handlers.Repo.CancelReservation)
+		mux.Post("/reservation/{code}/cancel", handlers.Repo.PostCancelReservation)
+
+		mux.Get("/user/login", handlers.Repo.ShowLogin)
+		mux.Post("/user/login", handlers.Repo.PostShowLogin)
+		mux.Get("/user/logout", handlers.Repo.Logout)
+		mux.Get("/user/forgot-password", handlers.Repo.ForgotPassword)
+		mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+		mux.Get("/user/reset-password", handlers.Repo.ResetPassword)
+		mux.Post("/user/reset-password", handlers.Repo.PostResetPassword)
+		// the second step of logging in, for users with two-factor login
+		mux.Get("/user/two-factor", handlers.Repo.TwoFactorLogin)
+		mux.Post("/user/two-factor", handlers.Repo.PostTwoFactorLogin)
+
+		// need to set a pattern "/admin"
+		// anything that starts with admin will be handled by this function(mux.Route function)
+		// on the mux router
+		mux.Route("/admin", func(mux chi.Router) {
+			// we're going to use Auth middleware to only apply to things
+			// that are inside thid mux.Route func
+			mux.Use(Auth)
+			// every page needs a role, the groups below need more trusted ones
+			mux.Use(RequirePermission(models.PermViewAdmin))
+			// users who must use two-factor login can only set it up until they have
+			mux.Use(RequireTwoFactor)
+
+			mux.Get("/two-factor", handlers.Repo.AdminTwoFactor)
+			mux.Post("/two-factor", handlers.Repo.AdminPostTwoFactor)
+			mux.Post("/two-factor/recovery-codes", handlers.Repo.AdminPostRecoveryCodes)
+			mux.Post("/two-factor/disable", handlers.Repo.AdminPostDisableTwoFactor)
+			mux.Get("/sessions", handlers.Repo.AdminSessions)
+			mux.Post("/revoke-session/{id}/do", handlers.Repo.AdminRevokeSession)
+			mux.Post("/revoke-sessions/do", handlers.Repo.AdminRevokeOtherSessions)
+
+			mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+			mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
+			mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+			mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalender)
+			// src and id are matching parameters or matching parts of the route
+			mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
+			mux.Get("/rooms", handlers.Repo.AdminAllRooms)
+			// id 0 shows an empty form for adding a new room
+			mux.Get("/rooms/{id}/show", handlers.Repo.AdminShowRoom)
+			mux.Get("/promo-codes", handlers.Repo.AdminAllPromoCodes)
+			mux.Get("/promo-codes/{id}/show", handlers.Repo.AdminShowPromoCode)
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(RequirePermission(models.PermEditReservations))
+
+				mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalender)
+				mux.Post("/reservation-status/{src}/{id}/{status}/do", handlers.Repo.AdminTransitionReservation)
+				mux.Post("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+				mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+			})
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(RequirePermission(models.PermManageTrash))
+
+				mux.Get("/reservations-trash", handlers.Repo.AdminReservationsTrash)
+				mux.Post("/restore-reservation/{id}/do", handlers.Repo.AdminRestoreReservation)
+				mux.Post("/purge-reservation/{id}/do", handlers.Repo.AdminPurgeReservation)
+			})
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(RequirePermission(models.PermManageRooms))
+
+				mux.Post("/rooms/{id}", handlers.Repo.AdminPostShowRoom)
+				mux.Post("/activate-room/{id}/do", handlers.Repo.AdminActivateRoom)
+				mux.Post("/deactivate-room/{id}/do", handlers.Repo.AdminDeactivateRoom)
+				mux.Post("/delete-room/{id}/do", handlers.Repo.AdminDeleteRoom)
+				mux.Post("/rooms/{id}/seasonal-rates", handlers.Repo.AdminPostSeasonalRate)
+				mux.Post("/rooms/{id}/delete-seasonal-rate/{season_id}/do", handlers.Repo.AdminDeleteSeasonalRate)
+			})
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(RequirePermission(models.PermManagePromoCodes))
+
+				mux.Post("/promo-codes/{id}", handlers.Repo.AdminPostShowPromoCode)
+				mux.Post("/delete-promo-code/{id}/do", handlers.Repo.AdminDeletePromoCode)
+			})
+
+			mux.With(RequirePermission(models.PermViewAudit)).Get("/audit", handlers.Repo.AdminAudit)
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(RequirePermission(models.PermManageUsers))
+
+				mux.Get("/users", handlers.Repo.AdminAllUsers)
+				// id 0 shows an empty form for inviting a user
+				mux.Get("/users/{id}/show", handlers.Repo.AdminShowUser)
+				mux.Post("/users/{id}", handlers.Repo.AdminPostShowUser)
+				mux.Post("/users/{id}/password", handlers.Repo.AdminPostUserPassword)
+				// changes to accounts are posted, so nosurf checks where they came from
+				mux.Post("/enable-user/{id}/do", handlers.Repo.AdminEnableUser)
+				mux.Post("/disable-user/{id}/do", handlers.Repo.AdminDisableUser)
+				mux.Post("/reset-two-factor/{id}/do", handlers.Repo.AdminResetTwoFactor)
+				mux.Post("/users/{id}/revoke-session/{session_id}/do", handlers.Repo.AdminRevokeUserSession)
+				mux.Post("/users/{id}/revoke-sessions/do", handlers.Repo.AdminRevokeUserSessions)
+				mux.Get("/login-lockouts", handlers.Repo.AdminLoginLockouts)
+				mux.Post("/unlock-login/{id}/do", handlers.Repo.AdminUnlockLogin)
+			})
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(RequirePermission(models.PermManageMail))
+
+				mux.Get("/mail-outbox", handlers.Repo.AdminMailOutbox)
+				mux.Get("/mail-outbox/{id}/show", handlers.Repo.AdminShowMailMessage)
+				mux.Post("/resend-mail/{id}/do", handlers.Repo.AdminResendMail)
+			})
+		})
 	})
 	return mux
 }
@@ -20,3 +20,38 @@ func TestRoutes(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not *chi.Mux, type is %T", v))
 	}
 }
+
+// TestRoutes_Posts checks that admin actions with side effects only take POSTs,
+// which nosurf checks the CSRF token of
+func TestRoutes_Posts(t *testing.T) {
+	var app config.AppConfig
+	mux := routes(&app).(*chi.Mux)
+
+	for _, path := range []string{
+		"/admin/revoke-session/1/do",
+		"/admin/revoke-sessions/do",
+		"/admin/enable-user/1/do",
+		"/admin/disable-user/1/do",
+		"/admin/reset-two-factor/1/do",
+		"/admin/users/1/revoke-session/1/do",
+		"/admin/users/1/revoke-sessions/do",
+		"/admin/unlock-login/1/do",
+		"/admin/reservation-status/all/1/confirmed/do",
+		"/admin/delete-reservation/all/1/do",
+		"/admin/restore-reservation/1/do",
+		"/admin/purge-reservation/1/do",
+		"/admin/activate-room/1/do",
+		"/admin/deactivate-room/1/do",
+		"/admin/delete-room/1/do",
+		"/admin/rooms/1/delete-seasonal-rate/1/do",
+		"/admin/delete-promo-code/1/do",
+		"/admin/resend-mail/1/do",
+	} {
+		if mux.Match(chi.NewRouteContext(), "GET", path) {
+			t.Errorf("expected no GET route for %s", path)
+		}
+		if !mux.Match(chi.NewRouteContext(), "POST", path) {
+			t.Errorf("expected a POST route for %s", path)
+		}
+	}
+}
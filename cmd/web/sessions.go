@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/byt3er/bookings/internals/repository"
+	"github.com/byt3er/bookings/internals/sessionstore"
+)
+
+// sessionCleanupInterval is how often expired sessions are removed from the database
+const sessionCleanupInterval = 5 * time.Minute
+
+// cleanupSessions removes expired sessions from the database when the application
+// starts and then every sessionCleanupInterval, until ctx is done
+func cleanupSessions(ctx context.Context, store *sessionstore.PostgresStore) {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := store.DeleteExpired(ctx)
+		if err != nil {
+			app.ErrorLog.Println(err)
+		} else if n > 0 {
+			app.InfoLog.Printf("Removed %d expired sessions", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanupUserSessions forgets the logins of sessions that have expired, when the
+// application starts and then every hour, until ctx is done
+func cleanupUserSessions(ctx context.Context, repo repository.DatabaseRepo) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		n, err := repo.DeleteStaleUserSessions(ctx, time.Now().Add(-session.Lifetime))
+		if err != nil {
+			app.ErrorLog.Println(err)
+		} else if n > 0 {
+			app.InfoLog.Printf("Forgot %d expired logins", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/byt3er/bookings/internals/repository"
+)
+
+// purgeTrash deletes reservations that have been in the trash longer than the retention
+// period, when the application starts and then every hour, until ctx is done
+func purgeTrash(ctx context.Context, repo repository.DatabaseRepo) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		n, err := repo.PurgeDeletedReservations(ctx, time.Now().Add(-app.TrashRetention))
+		if err != nil {
+			app.ErrorLog.Println(err)
+		} else if n > 0 {
+			app.InfoLog.Printf("Purged %d reservations from the trash", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/byt3er/bookings/internals/repository/dbrepo"
+)
+
+func TestPurgeTrash(t *testing.T) {
+	app.ErrorLog = log.New(io.Discard, "", 0)
+	app.InfoLog = log.New(io.Discard, "", 0)
+
+	// a purge isn't started once the application is stopping
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		purgeTrash(ctx, dbrepo.NewTestingRepo(&app))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected purgeTrash to stop when its context is done")
+	}
+}
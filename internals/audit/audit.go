@@ -0,0 +1,94 @@
+// Package audit describes the changes users make in the admin tool, so that
+// the audit log can show who changed what and when
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/byt3er/bookings/internals/models"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionCreate     = "create"
+	ActionUpdate     = "update"
+	ActionDelete     = "delete"
+	ActionRestore    = "restore"
+	ActionPurge      = "purge"
+	ActionTransition = "transition"
+	ActionActivate   = "activate"
+	ActionDeactivate = "deactivate"
+	ActionBlock      = "block"
+	ActionUnblock    = "unblock"
+	ActionPassword   = "password"
+	ActionUnlock     = "unlock"
+	ActionRevoke     = "revoke"
+	ActionResend     = "resend"
+)
+
+// Entities recorded in the audit log
+const (
+	EntityReservation  = "reservation"
+	EntityRoom         = "room"
+	EntitySeasonalRate = "seasonal_rate"
+	EntityPromoCode    = "promo_code"
+	EntityUser         = "user"
+	EntityLoginLockout = "login_lockout"
+	EntityMail         = "mail"
+)
+
+// Entities lists every entity, in the order the audit page offers them
+var Entities = []string{EntityReservation, EntityRoom, EntitySeasonalRate, EntityPromoCode, EntityUser, EntityLoginLockout, EntityMail}
+
+// ValidEntity reports whether entity is one of Entities
+func ValidEntity(entity string) bool {
+	for _, e := range Entities {
+		if e == entity {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff compares the JSON form of before and after, field by field, and returns the
+// fields that differ. Either of them may be nil, when something was created or deleted.
+func Diff(before, after interface{}) (map[string]models.AuditChange, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]models.AuditChange)
+	for name, value := range b {
+		if !reflect.DeepEqual(value, a[name]) {
+			changes[name] = models.AuditChange{Before: value, After: a[name]}
+		}
+	}
+	for name, value := range a {
+		if _, ok := b[name]; !ok && value != nil {
+			changes[name] = models.AuditChange{After: value}
+		}
+	}
+	return changes, nil
+}
+
+// fields returns the top level fields of v as they look in JSON
+func fields(v interface{}) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	if v == nil {
+		return m, nil
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(j, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
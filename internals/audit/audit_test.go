@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/byt3er/bookings/internals/models"
+)
+
+type guest struct {
+	Name  string
+	Email string
+	Age   int
+}
+
+var diffTests = []struct {
+	name     string
+	before   interface{}
+	after    interface{}
+	expected map[string]models.AuditChange
+}{
+	{"no change", guest{"John", "john@here.ca", 30}, guest{"John", "john@here.ca", 30}, map[string]models.AuditChange{}},
+	{"one field", guest{"John", "john@here.ca", 30}, guest{"John", "jack@here.ca", 30}, map[string]models.AuditChange{
+		"Email": {Before: "john@here.ca", After: "jack@here.ca"},
+	}},
+	{"created", nil, map[string]interface{}{"Active": true}, map[string]models.AuditChange{
+		"Active": {After: true},
+	}},
+	{"deleted", map[string]interface{}{"Active": true}, nil, map[string]models.AuditChange{
+		"Active": {Before: true},
+	}},
+	{"nothing", nil, nil, map[string]models.AuditChange{}},
+}
+
+func TestDiff(t *testing.T) {
+	for _, e := range diffTests {
+		changes, err := Diff(e.before, e.after)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(changes, e.expected) {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expected, changes)
+		}
+	}
+}
+
+func TestDiffNumbers(t *testing.T) {
+	// numbers come back from JSON as float64
+	changes, _ := Diff(guest{Age: 30}, guest{Age: 31})
+	if c := changes["Age"]; c.Before != float64(30) || c.After != float64(31) {
+		t.Errorf("expected age to change from 30 to 31, but got %v", c)
+	}
+}
+
+func TestValidEntity(t *testing.T) {
+	if !ValidEntity(EntityRoom) {
+		t.Error("expected room to be a valid entity")
+	}
+	if ValidEntity("payment") {
+		t.Error("expected payment not to be a valid entity")
+	}
+}
@@ -3,18 +3,45 @@ package config
 import (
 	"html/template"
 	"log"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/byt3er/bookings/internals/mailer"
 	"github.com/byt3er/bookings/internals/models"
+	"github.com/byt3er/bookings/internals/outbox"
+	"github.com/byt3er/bookings/internals/payments"
+	"github.com/byt3er/bookings/internals/pricing"
+	"github.com/byt3er/bookings/internals/signer"
+	"github.com/byt3er/bookings/internals/throttle"
 )
 
 // AppConfig holds the application config
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseCache       bool
+	TemplateCache  map[string]*template.Template
+	InfoLog        *log.Logger
+	ErrorLog       *log.Logger
+	InProduction   bool
+	Session        *scs.SessionManager
+	MailQueued     chan struct{} // wakes the mail worker when a message is put in the outbox
+	Mail           mailer.Config // mail server and sender address
+	Mailer         mailer.Mailer // sends the messages in the outbox
+	MailRetry      outbox.Policy // how often sending a message is tried
+	MailWorkers    int           // messages sent at the same time
+	DBTimeout      time.Duration // how long a single database query may take
+	Payments       payments.Gateway
+	Signer         *signer.Signer             // signs the links mailed to guests
+	BaseURL        string                     // where the site is reached, for links in emails
+	Cancellation   pricing.CancellationPolicy // what guests pay for cancelling
+	TrashRetention time.Duration              // how long deleted reservations can be restored
+	LoginAccount   throttle.Policy            // failed logins allowed for an email address
+	LoginIP        throttle.Policy            // failed logins allowed from an IP address
+	// users with an access level above this one must use two-factor login
+	TwoFactorAccessLevel int
+	// how long finishing requests and sending queued mail may take when stopping
+	ShutdownTimeout time.Duration
+	// the templates emails are made from, by file name
+	MailTemplateCache map[string]*template.Template
+	// where guests stay and when they check in and out
+	Property models.Property
 }
@@ -0,0 +1,60 @@
+package email
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/byt3er/bookings/internals/ical"
+	"github.com/byt3er/bookings/internals/models"
+)
+
+// ReservationCalendar returns the calendar event for a guest's stay, to attach to the
+// email about res. ical.MethodRequest puts the stay in their calendar and
+// ical.MethodCancel takes it out again
+func ReservationCalendar(method string, res models.Reservation) models.Attachment {
+	p := app.Property
+
+	// a cancellation only replaces the event if it is a newer version
+	sequence := 0
+	if method == ical.MethodCancel {
+		sequence = 1
+	}
+
+	description := []string{
+		"Booking code: " + res.Code,
+		"Room: " + res.Room.RoomName,
+		fmt.Sprintf("Check-in from %s, check-out by %s",
+			p.CheckInTime(res.StartDate).Format("3:04 PM"), p.CheckOutTime(res.EndDate).Format("3:04 PM")),
+	}
+
+	event := ical.Event{
+		UID:         fmt.Sprintf("reservation-%s@%s", res.Code, calendarDomain()),
+		Sequence:    sequence,
+		Summary:     "Stay at " + p.Name,
+		Description: strings.Join(description, "\n"),
+		Location:    p.Address,
+		Start:       p.CheckInTime(res.StartDate),
+		End:         p.CheckOutTime(res.EndDate),
+		Organizer:   ical.Person{Name: p.Name, Email: app.Mail.From},
+		Attendee:    ical.Person{Name: res.FirstName + " " + res.LastName, Email: res.Email},
+		Stamp:       time.Now(),
+	}
+
+	return models.Attachment{
+		Name:        "reservation.ics",
+		ContentType: fmt.Sprintf("%s; charset=utf-8; method=%s", ical.ContentType, method),
+		Data:        ical.Calendar(method, event),
+	}
+}
+
+// calendarDomain returns the domain of the site, which keeps the ids of its
+// calendar events apart from everyone else's
+func calendarDomain() string {
+	u, err := url.Parse(app.BaseURL)
+	if err != nil || u.Hostname() == "" {
+		return "localhost"
+	}
+	return u.Hostname()
+}
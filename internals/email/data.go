@@ -0,0 +1,54 @@
+package email
+
+import (
+	"time"
+
+	"github.com/byt3er/bookings/internals/models"
+)
+
+// Guest is who a reservation was made for
+type Guest struct {
+	FirstName string
+	LastName  string
+	Email     string
+}
+
+// Name returns the full name of the guest
+func (g Guest) Name() string {
+	return g.FirstName + " " + g.LastName
+}
+
+// ReservationData is what the reservation-*.mail.tmpl templates are executed with
+type ReservationData struct {
+	Guest       Guest
+	Reservation models.Reservation
+	Room        models.Room
+	Property    models.Property
+	CheckIn     time.Time // from when the guest can check in, where the property is
+	CheckOut    time.Time // by when the guest must check out
+	CancelURL   string    // where the guest can cancel, in the confirmation
+	Fee         int       // in cents, kept when the reservation was cancelled
+}
+
+// NewReservationData returns the data for an email about res
+func NewReservationData(res models.Reservation) ReservationData {
+	return ReservationData{
+		Guest: Guest{
+			FirstName: res.FirstName,
+			LastName:  res.LastName,
+			Email:     res.Email,
+		},
+		Reservation: res,
+		Room:        res.Room,
+		Property:    app.Property,
+		CheckIn:     app.Property.CheckInTime(res.StartDate),
+		CheckOut:    app.Property.CheckOutTime(res.EndDate),
+	}
+}
+
+// UserData is what the emails to staff users are executed with
+type UserData struct {
+	User      models.User
+	URL       string // of the link in the email, e.g. to log in
+	ValidMins int    // how long the link works, when it expires
+}
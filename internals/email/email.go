@@ -0,0 +1,102 @@
+// Package email builds the emails the application sends from the templates in the
+// email-template directory. Every *.mail.tmpl is parsed with the *.layout.tmpl files,
+// and each message gets a plain-text part made from its HTML
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"path/filepath"
+
+	"github.com/byt3er/bookings/internals/config"
+	"github.com/byt3er/bookings/internals/models"
+	"github.com/byt3er/bookings/internals/pricing"
+	"github.com/byt3er/bookings/internals/render"
+)
+
+var functions = template.FuncMap{
+	"humanDate":   render.HumanDate,
+	"formatDate":  render.FormatDate,
+	"formatMoney": pricing.FormatMoney,
+}
+
+var app *config.AppConfig
+var pathToTemplates = "./email-template"
+
+// NewRenderer sets the config for the email package
+func NewRenderer(a *config.AppConfig) {
+	app = a
+}
+
+// Message executes the email template tmpl with data, which should be the type
+// the template is written for, and returns the message to send to `to`
+func Message(to, subject, tmpl string, data interface{}) (models.MailData, error) {
+	var tc map[string]*template.Template
+	if app.UseCache {
+		tc = app.MailTemplateCache
+	} else {
+		var err error
+		tc, err = CreateTemplateCache(pathToTemplates)
+		if err != nil {
+			return models.MailData{}, err
+		}
+	}
+
+	t, ok := tc[tmpl]
+	if !ok {
+		return models.MailData{}, fmt.Errorf("can't get email template %s from cache", tmpl)
+	}
+
+	html := new(bytes.Buffer)
+	if err := t.Execute(html, data); err != nil {
+		return models.MailData{}, err
+	}
+
+	// the text part leaves out the layout, only the content is worth reading
+	content := new(bytes.Buffer)
+	if err := t.ExecuteTemplate(content, "content", data); err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		To:      to,
+		From:    app.Mail.From,
+		Subject: subject,
+		Content: html.String(),
+		Text:    PlainText(content.String()),
+	}, nil
+}
+
+// CreateTemplateCache parses the email templates in dir, as a map by file name
+func CreateTemplateCache(dir string) (map[string]*template.Template, error) {
+	myCache := map[string]*template.Template{}
+
+	pages, err := filepath.Glob(filepath.Join(dir, "*.mail.tmpl"))
+	if err != nil {
+		return myCache, err
+	}
+	if len(pages) == 0 {
+		return myCache, errors.New("no email templates in " + dir)
+	}
+
+	layouts, err := filepath.Glob(filepath.Join(dir, "*.layout.tmpl"))
+	if err != nil {
+		return myCache, err
+	}
+
+	for _, page := range pages {
+		name := filepath.Base(page)
+		ts, err := template.New(name).Funcs(functions).ParseFiles(append([]string{page}, layouts...)...)
+		if err != nil {
+			return myCache, err
+		}
+		if ts.Lookup("content") == nil {
+			return myCache, fmt.Errorf("email template %s doesn't define content", name)
+		}
+		myCache[name] = ts
+	}
+
+	return myCache, nil
+}
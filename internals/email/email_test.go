@@ -0,0 +1,162 @@
+package email
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byt3er/bookings/internals/config"
+	"github.com/byt3er/bookings/internals/ical"
+	"github.com/byt3er/bookings/internals/mailer"
+	"github.com/byt3er/bookings/internals/models"
+)
+
+func newTestApp(t *testing.T) {
+	tc, err := CreateTemplateCache("./../../email-template")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	NewRenderer(&config.AppConfig{
+		UseCache:          true,
+		MailTemplateCache: tc,
+		Mail:              mailer.Config{From: "bookings@here.ca"},
+		BaseURL:           "https://fortsmythe.ca",
+		Property: models.Property{
+			Name:     "Fort Smythe",
+			Address:  "1 Main Street, Fort Smythe",
+			CheckIn:  15 * time.Hour,
+			CheckOut: 11 * time.Hour,
+			Location: time.FixedZone("EST", -5*60*60),
+		},
+	})
+}
+
+func testReservationData() ReservationData {
+	data := NewReservationData(models.Reservation{
+		Code:      "ABCD2345",
+		FirstName: "<John>",
+		LastName:  "O'Brien",
+		Email:     "john@here.ca",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "General's Quarters"},
+	})
+	data.CancelURL = "http://localhost:8080/reservation/cancel?token=a&b"
+	data.Fee = 5000
+	return data
+}
+
+// TestTemplates executes every email template with the data it is written for
+func TestTemplates(t *testing.T) {
+	newTestApp(t)
+	user := UserData{User: models.User{FirstName: "Jane", LastName: "Smith", AccessLevel: int(models.RoleManager)}, URL: "http://localhost:8080/user/login", ValidMins: 60}
+
+	data := map[string]interface{}{
+		"reservation-confirmation.mail.tmpl": testReservationData(),
+		"reservation-notification.mail.tmpl": testReservationData(),
+		"reservation-cancelled.mail.tmpl":    testReservationData(),
+		"reservation-cancellation.mail.tmpl": testReservationData(),
+		"password-reset.mail.tmpl":           user,
+		"password-changed.mail.tmpl":         user,
+		"invitation.mail.tmpl":               user,
+	}
+	if len(app.MailTemplateCache) != len(data) {
+		t.Errorf("expected %d email templates but got %d", len(data), len(app.MailTemplateCache))
+	}
+
+	for name := range app.MailTemplateCache {
+		d, ok := data[name]
+		if !ok {
+			t.Errorf("no test data for email template %s", name)
+			continue
+		}
+		msg, err := Message("john@here.ca", "Subject", name, d)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+			continue
+		}
+		if msg.From != "bookings@here.ca" || msg.To != "john@here.ca" || msg.Subject != "Subject" {
+			t.Errorf("%s: unexpected addresses %+v", name, msg)
+		}
+		if !strings.Contains(msg.Content, "<html") || msg.Text == "" || strings.Contains(msg.Text, "</") {
+			t.Errorf("%s: expected an HTML message in the layout with a text part", name)
+		}
+	}
+
+	if _, err := Message("john@here.ca", "Subject", "missing.mail.tmpl", nil); err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
+
+func TestMessage_Escaping(t *testing.T) {
+	newTestApp(t)
+
+	msg, err := Message("john@here.ca", "Reservation Confirmation", "reservation-confirmation.mail.tmpl", testReservationData())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if strings.Contains(msg.Content, "<John>") || !strings.Contains(msg.Content, "&lt;John&gt;") {
+		t.Error("expected the guest's name to be escaped")
+	}
+	if !strings.Contains(msg.Text, "Dear <John> O'Brien,") {
+		t.Errorf("expected the guest's name as typed in the text part, but got\n%s", msg.Text)
+	}
+	if !strings.Contains(msg.Text, "cancel your reservation (http://localhost:8080/reservation/cancel?token=a&b)") {
+		t.Errorf("expected the cancellation link in the text part, but got\n%s", msg.Text)
+	}
+	if !strings.Contains(msg.Text, "check in from 3:00 PM") || !strings.Contains(msg.Text, "at 1 Main Street, Fort Smythe.") {
+		t.Errorf("expected the check-in time and the address in the text part, but got\n%s", msg.Text)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	html := `<html><head><style>p { color: red; }</style></head><body>
+		<h4>Welcome</h4>
+		<p>Dear   Jane,<br>
+		you can <a href="https://here.ca/login?a=1&amp;b=2">log in</a> now.</p>
+		<p>See <a href="https://here.ca">https://here.ca</a> &amp; <a href="#">nothing</a></p>
+	</body></html>`
+
+	expected := "Welcome\n\nDear Jane,\nyou can log in (https://here.ca/login?a=1&b=2) now.\n\nSee https://here.ca & nothing\n"
+	if text := PlainText(html); text != expected {
+		t.Errorf("expected\n%q\nbut got\n%q", expected, text)
+	}
+}
+
+func TestReservationCalendar(t *testing.T) {
+	newTestApp(t)
+	res := testReservationData().Reservation
+
+	a := ReservationCalendar(ical.MethodRequest, res)
+	if a.Name != "reservation.ics" || a.ContentType != "text/calendar; charset=utf-8; method=REQUEST" {
+		t.Errorf("unexpected attachment %s of type %s", a.Name, a.ContentType)
+	}
+	cal := strings.ReplaceAll(string(a.Data), "\r\n ", "")
+	for _, want := range []string{
+		"\r\nMETHOD:REQUEST\r\n",
+		"\r\nUID:reservation-ABCD2345@fortsmythe.ca\r\n",
+		"\r\nSEQUENCE:0\r\n",
+		"\r\nDTSTART:20500101T200000Z\r\n",
+		"\r\nDTEND:20500103T160000Z\r\n",
+		"\r\nLOCATION:1 Main Street\\, Fort Smythe\r\n",
+		"Booking code: ABCD2345",
+		"mailto:john@here.ca",
+	} {
+		if !strings.Contains(cal, want) {
+			t.Errorf("expected %q in\n%s", want, cal)
+		}
+	}
+
+	// the cancellation is a newer version of the same event
+	cal = string(ReservationCalendar(ical.MethodCancel, res).Data)
+	for _, want := range []string{
+		"\r\nMETHOD:CANCEL\r\n",
+		"\r\nUID:reservation-ABCD2345@fortsmythe.ca\r\n",
+		"\r\nSEQUENCE:1\r\n",
+		"\r\nSTATUS:CANCELLED\r\n",
+	} {
+		if !strings.Contains(cal, want) {
+			t.Errorf("expected %q in\n%s", want, cal)
+		}
+	}
+}
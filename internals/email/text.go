@@ -0,0 +1,48 @@
+package email
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	// elements whose content isn't text
+	hiddenElements = regexp.MustCompile(`(?is)<(head|style|script)\b.*?</(head|style|script)>`)
+	// links that go somewhere, shown as their text followed by the address
+	links = regexp.MustCompile(`(?is)<a\b[^>]*\bhref="([^"#][^"]*)"[^>]*>(.*?)</a>`)
+	// tags that end a paragraph, followed by an empty line
+	paragraphs = regexp.MustCompile(`(?i)</(p|h[1-6]|table)>`)
+	// tags that start a new line
+	lineBreaks = regexp.MustCompile(`(?i)<(br|/div|/li|/tr|hr)\b[^>]*>`)
+	tags       = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// PlainText turns the HTML of an email into text, for mail programs
+// that don't show HTML. It only knows the markup the email templates use
+func PlainText(s string) string {
+	s = hiddenElements.ReplaceAllString(s, "")
+	// whitespace in HTML is only a space, line breaks come from the tags
+	s = strings.Join(strings.Fields(s), " ")
+	s = links.ReplaceAllStringFunc(s, func(a string) string {
+		m := links.FindStringSubmatch(a)
+		text := strings.TrimSpace(tags.ReplaceAllString(m[2], ""))
+		href := m[1]
+		if text == "" || text == href {
+			return href
+		}
+		return text + " (" + href + ")"
+	})
+	s = paragraphs.ReplaceAllString(s, "$0\n\n")
+	s = lineBreaks.ReplaceAllString(s, "$0\n")
+	s = html.UnescapeString(tags.ReplaceAllString(s, ""))
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	s = strings.Join(lines, "\n")
+
+	return strings.TrimSpace(blankLines.ReplaceAllString(s, "\n\n")) + "\n"
+}
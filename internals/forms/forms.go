@@ -3,6 +3,8 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
@@ -78,3 +80,76 @@ func (f *Form) IsEmail(field string) bool {
 	}
 	return true
 }
+
+// slugRegex matches lower case words separated by single dashes, e.g. "majors-suite"
+var slugRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// IsSlug checks that a field can be used as part of a url
+func (f *Form) IsSlug(field string) bool {
+	if !slugRegex.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Only lower case letters, numbers and dashes are allowed")
+		return false
+	}
+	return true
+}
+
+// IsInt checks that a field holds a whole number
+func (f *Form) IsInt(field string) bool {
+	if _, err := strconv.Atoi(strings.TrimSpace(f.Get(field))); err != nil {
+		f.Errors.Add(field, "This field must be a whole number")
+		return false
+	}
+	return true
+}
+
+// IntBetween checks that a field holds a whole number from min to max, inclusive
+func (f *Form) IntBetween(field string, min, max int) bool {
+	x, err := strconv.Atoi(strings.TrimSpace(f.Get(field)))
+	if err != nil || x < min || x > max {
+		f.Errors.Add(field, fmt.Sprintf("This field must be between %d and %d", min, max))
+		return false
+	}
+	return true
+}
+
+// IsAlphanumeric checks that a field only holds letters and numbers
+func (f *Form) IsAlphanumeric(field string) bool {
+	if !govalidator.IsAlphanumeric(f.Get(field)) {
+		f.Errors.Add(field, "Only letters and numbers are allowed")
+		return false
+	}
+	return true
+}
+
+// IsCardNumber checks for a valid credit card number
+func (f *Form) IsCardNumber(field string) bool {
+	if !govalidator.IsCreditCard(strings.ReplaceAll(f.Get(field), " ", "")) {
+		f.Errors.Add(field, "Invalid card number")
+		return false
+	}
+	return true
+}
+
+// Matches checks that two fields hold the same value, e.g. a password and its
+// confirmation. The error is added to the second field
+func (f *Form) Matches(field, other string) bool {
+	if f.Get(field) != f.Get(other) {
+		f.Errors.Add(other, "The values don't match")
+		return false
+	}
+	return true
+}
+
+// Unique checks that the value of a field isn't used already. taken looks the value
+// up, usually in the database, and any error it returns is passed back unchanged
+func (f *Form) Unique(field string, taken func(value string) (bool, error)) (bool, error) {
+	used, err := taken(f.Get(field))
+	if err != nil {
+		return false, err
+	}
+	if used {
+		f.Errors.Add(field, "This value is already in use")
+		return false, nil
+	}
+	return true, nil
+}
@@ -1,6 +1,7 @@
 package forms
 
 import (
+	"fmt"
 	"net/url"
 	"testing"
 )
@@ -141,3 +142,159 @@ func TestForm_IsEmail(t *testing.T) {
 	// }
 
 }
+
+func TestForm_IsSlug(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("slug", "majors-suite")
+	form := New(postedValues)
+	form.IsSlug("slug")
+	if !form.Valid() {
+		t.Error("got invalid slug when we should not have")
+	}
+
+	for _, slug := range []string{"", "Majors Suite", "majors--suite", "-majors", "majors/suite"} {
+		postedValues = url.Values{}
+		postedValues.Add("slug", slug)
+		form = New(postedValues)
+		form.IsSlug("slug")
+		if form.Valid() {
+			t.Errorf("got valid for invalid slug %q", slug)
+		}
+	}
+}
+
+func TestForm_IsInt(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("adults", "2")
+	form := New(postedValues)
+	form.IsInt("adults")
+	if !form.Valid() {
+		t.Error("got invalid number when we should not have")
+	}
+
+	for _, value := range []string{"", "two", "1.5"} {
+		postedValues = url.Values{}
+		postedValues.Add("adults", value)
+		form = New(postedValues)
+		form.IsInt("adults")
+		if form.Valid() {
+			t.Errorf("got valid for invalid number %q", value)
+		}
+		if form.Errors.Get("adults") == "" {
+			t.Error("should have an error, but did not get one")
+		}
+	}
+}
+
+func TestForm_IntBetween(t *testing.T) {
+	for _, value := range []string{"1", "3", "4"} {
+		postedValues := url.Values{}
+		postedValues.Add("adults", value)
+		form := New(postedValues)
+		form.IntBetween("adults", 1, 4)
+		if !form.Valid() {
+			t.Errorf("got invalid for %q when we should not have", value)
+		}
+	}
+
+	for _, value := range []string{"", "0", "5", "x"} {
+		postedValues := url.Values{}
+		postedValues.Add("adults", value)
+		form := New(postedValues)
+		form.IntBetween("adults", 1, 4)
+		if form.Valid() {
+			t.Errorf("got valid for %q when it is out of range", value)
+		}
+	}
+}
+
+func TestForm_IsAlphanumeric(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("code", "Summer2050")
+	form := New(postedValues)
+	form.IsAlphanumeric("code")
+	if !form.Valid() {
+		t.Error("got invalid code when we should not have")
+	}
+
+	for _, code := range []string{"SUMMER 2050", "SUMMER-2050"} {
+		postedValues = url.Values{}
+		postedValues.Add("code", code)
+		form = New(postedValues)
+		form.IsAlphanumeric("code")
+		if form.Valid() {
+			t.Errorf("got valid for invalid code %q", code)
+		}
+	}
+}
+
+func TestForm_IsCardNumber(t *testing.T) {
+	for _, number := range []string{"4242424242424242", "4242 4242 4242 4242", "4000000000000002"} {
+		postedValues := url.Values{}
+		postedValues.Add("card_number", number)
+		form := New(postedValues)
+		form.IsCardNumber("card_number")
+		if !form.Valid() {
+			t.Errorf("got invalid for valid card number %q", number)
+		}
+	}
+
+	for _, number := range []string{"", "1234", "4242424242424241", "4242-abcd-4242-4242"} {
+		postedValues := url.Values{}
+		postedValues.Add("card_number", number)
+		form := New(postedValues)
+		form.IsCardNumber("card_number")
+		if form.Valid() {
+			t.Errorf("got valid for invalid card number %q", number)
+		}
+	}
+}
+
+func TestForm_Matches(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("password", "secret123")
+	postedValues.Add("password_confirm", "secret123")
+	form := New(postedValues)
+	if !form.Matches("password", "password_confirm") {
+		t.Error("got no match for equal values")
+	}
+
+	postedValues.Set("password_confirm", "secret124")
+	form = New(postedValues)
+	if form.Matches("password", "password_confirm") {
+		t.Error("got a match for different values")
+	}
+	if form.Errors.Get("password_confirm") == "" {
+		t.Error("should have an error on the confirmation field")
+	}
+}
+
+func TestForm_Unique(t *testing.T) {
+	taken := func(value string) (bool, error) {
+		if value == "broken" {
+			return false, fmt.Errorf("some error")
+		}
+		return value == "used", nil
+	}
+
+	for _, e := range []struct {
+		value       string
+		expected    bool
+		expectedErr bool
+	}{
+		{"new", true, false},
+		{"used", false, false},
+		{"broken", false, true},
+	} {
+		postedValues := url.Values{}
+		postedValues.Add("email", e.value)
+		form := New(postedValues)
+		ok, err := form.Unique("email", taken)
+		if ok != e.expected || (err != nil) != e.expectedErr {
+			t.Errorf("%s: expected %v and error %v, but got %v and %v", e.value, e.expected, e.expectedErr, ok, err)
+		}
+		if !ok && err == nil && form.Errors.Get("email") == "" {
+			t.Errorf("%s: should have an error", e.value)
+		}
+	}
+}
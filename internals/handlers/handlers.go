@@ -1,22 +1,33 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/byt3er/bookings/internals/audit"
 	"github.com/byt3er/bookings/internals/config"
 	"github.com/byt3er/bookings/internals/driver"
+	"github.com/byt3er/bookings/internals/email"
 	"github.com/byt3er/bookings/internals/forms"
 	"github.com/byt3er/bookings/internals/helpers"
+	"github.com/byt3er/bookings/internals/ical"
 	"github.com/byt3er/bookings/internals/models"
+	"github.com/byt3er/bookings/internals/payments"
+	"github.com/byt3er/bookings/internals/pricing"
 	"github.com/byt3er/bookings/internals/render"
 	"github.com/byt3er/bookings/internals/repository"
 	"github.com/byt3er/bookings/internals/repository/dbrepo"
+	"github.com/byt3er/bookings/internals/signer"
+	"github.com/byt3er/bookings/internals/totp"
 	"github.com/go-chi/chi"
 )
 
@@ -51,7 +62,7 @@ func NewHandlers(r *Repository) {
 // Home is the handler for the home page
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
 
-	//m.DB.AllUsers()
+	//m.DB.AllUsers(r.Context())
 	//fmt.Println("X-Session", r.Header.Get("X-Session"))
 
 	render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
@@ -79,7 +90,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	//fmt.Println("X-Session:", r.Header.Get("X-Session"))
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, err := m.DB.GetRoomByID(r.Context(), res.RoomID)
 	if err != nil {
 		// helpers.ServerError(w, err)
 		// return
@@ -89,8 +100,20 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	res.Room.RoomName = room.RoomName
+	res.Room.Capacity = room.Capacity
 	//res.Room.RoomName = "Heaven on planet Earth"
 
+	res.Nights, res.TotalPrice, err = m.quote(r.Context(), room, res.StartDate, res.EndDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't work out the price of the room!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	// the total is quoted afresh, so drop the discount of an earlier attempt;
+	// posting the form applies the promo code again
+	res.Discount = 0
+	res.PromoCodeID = 0
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	// convert StartDate and EndDate from time to string
@@ -135,11 +158,50 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
 
+	// children may be left empty
+	if strings.TrimSpace(r.PostForm.Get("children")) == "" {
+		r.PostForm.Set("children", "0")
+	}
+
 	form := forms.New(r.PostForm)
 	//form.Has("first_name", r)
-	form.Required("first_name", "last_name", "email")
+	form.Required("first_name", "last_name", "email", "adults")
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
+	if form.IntBetween("adults", 1, reservation.Room.Capacity) &&
+		form.IntBetween("children", 0, reservation.Room.Capacity) {
+		adults, _ := strconv.Atoi(strings.TrimSpace(r.PostForm.Get("adults")))
+		children, _ := strconv.Atoi(strings.TrimSpace(r.PostForm.Get("children")))
+		if adults+children > reservation.Room.Capacity {
+			form.Errors.Add("children", fmt.Sprintf("This room sleeps at most %d guests", reservation.Room.Capacity))
+		}
+	}
+
+	// keep what the guest typed, so the form can be shown again if needed
+	reservation.Adults, _ = strconv.Atoi(strings.TrimSpace(r.PostForm.Get("adults")))
+	reservation.Children, _ = strconv.Atoi(strings.TrimSpace(r.PostForm.Get("children")))
+
+	// the promo code is optional; undo any discount from an earlier attempt first
+	reservation.TotalPrice += reservation.Discount
+	reservation.Discount = 0
+	reservation.PromoCodeID = 0
+	reservation.PromoCode = strings.ToUpper(strings.TrimSpace(r.PostForm.Get("promo_code")))
+	r.PostForm.Set("promo_code", reservation.PromoCode)
+	if reservation.PromoCode != "" && form.IsAlphanumeric("promo_code") {
+		promo, err := m.DB.GetPromoCodeByCode(r.Context(), reservation.PromoCode)
+		if errors.Is(err, sql.ErrNoRows) {
+			form.Errors.Add("promo_code", "This promo code doesn't exist")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		} else if discount, err := pricing.Discount(promo, reservation, time.Now()); err != nil {
+			form.Errors.Add("promo_code", promoCodeMessage(err))
+		} else {
+			reservation.PromoCodeID = promo.ID
+			reservation.Discount = discount
+			reservation.TotalPrice -= discount
+		}
+	}
 
 	if !form.Valid() {
 		reservation.FirstName = r.Form.Get("first_name")
@@ -162,79 +224,226 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	reservation.Email = r.Form.Get("email")
 	reservation.Phone = r.Form.Get("phone")
 
-	// save the rerservation to database
-	// get a new reservation_id
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	// the room is only booked once the guest has paid
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+
+	http.Redirect(w, r, "/checkout", http.StatusSeeOther)
+}
+
+// Checkout renders the payment page for the reservation in the session
+func (m *Repository) Checkout(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok || reservation.Email == "" {
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	m.renderCheckout(w, r, reservation, forms.New(nil))
+}
+
+// PostCheckout takes the payment, books the room and sends the confirmation emails.
+// The payment is only authorized until the room is booked, so it can be released
+// if someone else got the room first
+func (m *Repository) PostCheckout(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "reservation not found in session!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	err := r.ParseForm()
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert reservation into the database!")
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+	// nothing to pay when a promo code covers the whole stay
+	var card payments.Card
+	form := forms.New(r.PostForm)
+	if reservation.TotalPrice > 0 {
+		form.Required("card_name", "card_number", "card_expiry", "card_cvc")
+		form.IsCardNumber("card_number")
+		form.MinLength("card_cvc", 3)
+
+		card.Name = form.Get("card_name")
+		card.Number = strings.ReplaceAll(form.Get("card_number"), " ", "")
+		card.CVC = form.Get("card_cvc")
+		card.ExpMonth, card.ExpYear, ok = parseCardExpiry(form.Get("card_expiry"))
+		if !ok && form.Has("card_expiry") {
+			form.Errors.Add("card_expiry", "Please enter the expiry date as MM/YY")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderCheckout(w, r, reservation, form)
+		return
+	}
+
+	var transactionID string
+	if reservation.TotalPrice > 0 {
+		transactionID, err = m.App.Payments.Authorize(r.Context(), payments.Charge{
+			Amount:   reservation.TotalPrice,
+			Currency: payments.Currency,
+			Description: fmt.Sprintf("%s from %s to %s", reservation.Room.RoomName,
+				reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02")),
+			Card: card,
+		})
+		if errors.Is(err, payments.ErrDeclined) {
+			form.Errors.Add("card_number", "Your card was declined")
+			m.renderCheckout(w, r, reservation, form)
+			return
+		}
+		if errors.Is(err, payments.ErrCardExpired) {
+			form.Errors.Add("card_expiry", "Your card has expired")
+			m.renderCheckout(w, r, reservation, form)
+			return
+		}
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			m.App.Session.Put(r.Context(), "error", "We couldn't take the payment. Please try again.")
+			http.Redirect(w, r, "/checkout", http.StatusSeeOther)
+			return
+		}
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	// save the reservation and its room restriction to the database
+	// in one go, re-checking availability first
+	newReservationID, code, err := m.DB.InsertReservationWithRestriction(r.Context(), reservation)
+	if err != nil && transactionID != "" {
+		// the guest is not charged for a room they didn't get
+		if err := m.App.Payments.Void(r.Context(), transactionID); err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room has just been booked for those dates. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, repository.ErrPromoCodeExhausted) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this promo code has just been used up.")
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, repository.ErrTooManyGuests) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room can't sleep that many guests.")
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room_restriction into the database!")
+		m.App.Session.Put(r.Context(), "error", "can't insert reservation into the database!")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	reservation.ID = newReservationID
+	reservation.Code = code
+	reservation.Status = models.StatusPending
+
+	if transactionID != "" {
+		payment := models.Payment{
+			ReservationID: reservation.ID,
+			Provider:      m.App.Payments.Name(),
+			TransactionID: transactionID,
+			Amount:        reservation.TotalPrice,
+			Currency:      payments.Currency,
+			Status:        payments.StatusCaptured,
+			CardLast4:     card.Last4(),
+		}
+		if err := m.App.Payments.Capture(r.Context(), transactionID); err != nil {
+			// the room is booked and the money is held on the card;
+			// the payment record shows it still has to be captured
+			m.App.ErrorLog.Println(err)
+			payment.Status = payments.StatusAuthorized
+		}
+		if _, err := m.DB.InsertPayment(r.Context(), payment); err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
 
 	// send notifications -> first to guest
-	htmlMessage := fmt.Sprintf(`
-		<strong> Reservation Confirmation </strong> <br>
-		Dear %s, <br>
-		This is confirm your reservation from %s to %s.
-	`,
-		reservation.FirstName+" "+reservation.LastName,
-		reservation.StartDate.Format("2006-01-02"),
-		reservation.EndDate.Format("2006-01-02"),
-	)
+	data := email.NewReservationData(reservation)
+	data.CancelURL = m.cancellationLink(reservation)
+	m.queueMail(r, reservation.Email, "Reservation Confirmation", "reservation-confirmation.mail.tmpl", data,
+		email.ReservationCalendar(ical.MethodRequest, reservation))
 
-	msg := models.MailData{}
-	msg.To = reservation.Email
-	msg.From = "me@here.com"
-	msg.Subject = "Reservation Confirmation"
-	msg.Content = htmlMessage
-	m.App.MailChan <- msg
-
-	// send notifications -> first to property owner
-	htmlMessage = fmt.Sprintf(`
-		<strong> Reservation Notification </strong> <br>
-		A reservation has been made for %s from %s to %s.
-	`,
-		reservation.Room.RoomName,
-		reservation.StartDate.Format("2006-01-02"),
-		reservation.EndDate.Format("2006-01-02"),
-	)
-
-	msg = models.MailData{}
-	msg.To = "me@here.com"
-	msg.From = "me@here.com"
-	msg.Subject = "Reservation Notification"
-	msg.Content = htmlMessage
-	msg.Template = "basic.html"
-	m.App.MailChan <- msg
+	// then to the property owner
+	m.queueMail(r, "me@here.com", "Reservation Notification", "reservation-notification.mail.tmpl", data)
 
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// renderCheckout shows the payment form with the price of the stay
+func (m *Repository) renderCheckout(w http.ResponseWriter, r *http.Request, reservation models.Reservation, form *forms.Form) {
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = reservation.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = reservation.EndDate.Format("2006-01-02")
+
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+
+	render.Template(w, r, "checkout.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// Rooms renders the list of rooms that can be booked
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var active []models.Room
+	for _, x := range rooms {
+		if x.Active {
+			x.Photos, err = m.DB.GetPhotosForRoom(r.Context(), x.ID)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			active = append(active, x)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = active
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+// Room renders the page of a single room, looked up by the slug in the url
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !room.Active {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
 // Availability renders the search availability page
@@ -254,6 +463,25 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	start := r.Form.Get("start") // string
 	end := r.Form.Get("end")     // string
 
+	// guests default to one adult and no children
+	adults, children := 1, 0
+	if x := strings.TrimSpace(r.Form.Get("adults")); x != "" {
+		adults, err = strconv.Atoi(x)
+		if err != nil || adults < 1 {
+			m.App.Session.Put(r.Context(), "error", "Please enter at least one adult")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+	}
+	if x := strings.TrimSpace(r.Form.Get("children")); x != "" {
+		children, err = strconv.Atoi(x)
+		if err != nil || children < 0 {
+			m.App.Session.Put(r.Context(), "error", "Please enter a valid number of children")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+	}
+
 	layout := "2006-01-02"
 
 	// parse start & end into time type
@@ -276,7 +504,7 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate, adults+children)
 	if err != nil {
 		fmt.Println("faield to query for SearchAvailablityForAllRooms")
 		m.App.Session.Put(r.Context(), "error", "database error:can't find rooms!")
@@ -293,13 +521,27 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		//m.App.InfoLog.Println("No Availability")
 	}
 	// availablity
+	// price of the whole stay for each room, by room id
+	prices := make(map[int]int)
+	for _, room := range rooms {
+		_, prices[room.ID], err = m.quote(r.Context(), room, startDate, endDate)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't work out the price of the rooms!")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+	}
+
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["prices"] = prices
 
 	// create a new reservation
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
 	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
@@ -380,7 +622,7 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
-	available, _ := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	available, _ := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID)
 
 	resp := jsonResponse{
 		Ok:        available,
@@ -435,6 +677,252 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// LookupReservation shows the form guests use to find their booking
+func (m *Repository) LookupReservation(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "lookup-reservation.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: make(map[string]interface{}),
+	})
+}
+
+// PostLookupReservation shows a guest their booking when the booking code and email match
+func (m *Repository) PostLookupReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code", "email")
+	form.IsEmail("email")
+
+	// guests may type the code in lower case or with spaces and dashes
+	code := strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(form.Get("code")))
+	if form.Has("code") && len(code) != 8 {
+		form.Errors.Add("code", "Booking codes have 8 letters and numbers")
+	}
+
+	data := make(map[string]interface{})
+	stringMap := make(map[string]string)
+	if !form.Valid() {
+		render.Template(w, r, "lookup-reservation.page.tmpl", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	res, err := m.DB.GetReservationByCode(r.Context(), code)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+	// the same answer for a wrong code and a wrong email, so codes can't be probed
+	if err != nil || !strings.EqualFold(res.Email, form.Get("email")) {
+		form.Errors.Add("code", "We couldn't find a reservation with this booking code and email")
+		render.Template(w, r, "lookup-reservation.page.tmpl", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	data["reservation"] = res
+	if _, err := m.App.Cancellation.CancellationFee(res, time.Now()); err == nil && res.CancelledAt.IsZero() {
+		stringMap["cancellation_link"] = m.cancellationLink(res)
+	}
+
+	render.Template(w, r, "lookup-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// cancellationLink returns the link mailed to a guest for cancelling their reservation.
+// It stops working on the day of arrival
+func (m *Repository) cancellationLink(res models.Reservation) string {
+	token := m.App.Signer.Sign(cancellationTokenValue(res.Code), res.StartDate)
+	return fmt.Sprintf("%s/reservation/%s/cancel?token=%s", m.App.BaseURL, res.Code, url.QueryEscape(token))
+}
+
+// cancellationTokenValue is what the token in a cancellation link is signed for
+func cancellationTokenValue(code string) string {
+	return "cancel:" + code
+}
+
+// reservationFromCancellationLink checks the token of a cancellation link and loads
+// its reservation. When ok is false a response has already been written
+func (m *Repository) reservationFromCancellationLink(w http.ResponseWriter, r *http.Request, token string) (res models.Reservation, ok bool) {
+	code := chi.URLParam(r, "code")
+	err := m.App.Signer.Verify(cancellationTokenValue(code), token, time.Now())
+	if errors.Is(err, signer.ErrExpiredToken) {
+		m.App.Session.Put(r.Context(), "error", "This cancellation link has expired. Please contact us about your reservation.")
+		http.Redirect(w, r, "/contact", http.StatusSeeOther)
+		return res, false
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This cancellation link is not valid.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return res, false
+	}
+
+	res, err = m.DB.GetReservationByCode(r.Context(), code)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the reservation was removed by the staff
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+	return res, true
+}
+
+// CancelReservation shows a guest what cancelling their reservation costs
+func (m *Repository) CancelReservation(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	res, ok := m.reservationFromCancellationLink(w, r, token)
+	if !ok {
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	intMap := make(map[string]int)
+	if res.CancelledAt.IsZero() {
+		fee, err := m.App.Cancellation.CancellationFee(res, time.Now())
+		if err == nil {
+			intMap["can_cancel"] = 1
+			intMap["fee"] = fee
+			intMap["cutoff_days"] = m.App.Cancellation.CutoffDays
+		}
+	}
+
+	render.Template(w, r, "cancel-reservation.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// PostCancelReservation cancels a guest's reservation, refunds what the policy allows
+// and lets the guest and the owner know
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, ok := m.reservationFromCancellationLink(w, r, r.Form.Get("token"))
+	if !ok {
+		return
+	}
+
+	fee, err := m.App.Cancellation.CancellationFee(res, time.Now())
+	if errors.Is(err, pricing.ErrCancellationClosed) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled. Please contact us.")
+		http.Redirect(w, r, "/contact", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.CancelReservation(r.Context(), res.ID, fee)
+	if errors.Is(err, repository.ErrInvalidTransition) {
+		// the guest has checked in or didn't show up
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled. Please contact us.")
+		http.Redirect(w, r, "/contact", http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, repository.ErrReservationCancelled) {
+		m.App.Session.Put(r.Context(), "Warning", "This reservation has already been cancelled.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.refundPayments(r.Context(), res.ID, fee)
+	m.queueCancellationMail(r, res, fee)
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// queueCancellationMail tells the guest and the owner that res was cancelled with fee kept.
+// The guest's email takes the stay out of their calendar
+func (m *Repository) queueCancellationMail(r *http.Request, res models.Reservation, fee int) {
+	data := email.NewReservationData(res)
+	data.Fee = fee
+	m.queueMail(r, res.Email, "Reservation Cancelled", "reservation-cancelled.mail.tmpl", data,
+		email.ReservationCalendar(ical.MethodCancel, res))
+	m.queueMail(r, "me@here.com", "Reservation Cancellation", "reservation-cancellation.mail.tmpl", data)
+}
+
+// refundPayments gives back what was paid for a cancelled reservation, less the fee,
+// and releases amounts that were only authorized when no fee is kept. The reservation
+// is already cancelled, so failures are logged for the staff to sort out
+func (m *Repository) refundPayments(ctx context.Context, reservationID, fee int) {
+	charges, err := m.DB.GetPaymentsForReservation(ctx, reservationID)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+
+	for _, p := range charges {
+		if p.Status == payments.StatusAuthorized && fee == 0 {
+			if err := m.App.Payments.Void(ctx, p.TransactionID); err != nil {
+				m.App.ErrorLog.Println(err)
+				continue
+			}
+			p.Status = payments.StatusVoided
+			if err := m.DB.UpdatePayment(ctx, p); err != nil {
+				m.App.ErrorLog.Println(err)
+			}
+			continue
+		}
+		if p.Status != payments.StatusCaptured {
+			continue
+		}
+
+		keep := p.Amount - p.Refunded
+		if keep > fee {
+			keep = fee
+		}
+		fee -= keep
+
+		refund := p.Amount - p.Refunded - keep
+		if refund <= 0 {
+			continue
+		}
+		if err := m.App.Payments.Refund(ctx, p.TransactionID, refund); err != nil {
+			m.App.ErrorLog.Println(err)
+			continue
+		}
+
+		p.Refunded += refund
+		if p.Refunded == p.Amount {
+			p.Status = payments.StatusRefunded
+		}
+		if err := m.DB.UpdatePayment(ctx, p); err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+}
+
 // BookRoom takes URL parameter, builds a sessional variable
 // and takes user to make-servation page
 func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
@@ -448,7 +936,7 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 
 	var res models.Reservation
 
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", err)
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -457,7 +945,9 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	res.RoomID = roomID
 	res.StartDate = startDate
 	res.EndDate = endDate
+	res.Adults = 1
 	res.Room.RoomName = room.RoomName
+	res.Room.Capacity = room.Capacity
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
@@ -495,24 +985,83 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 	}
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
-	// Authenticate the user
-	id, _, err := m.DB.Authenticate(email, password)
+	ip := helpers.ClientIP(r)
+
+	// lockouts count for any email address, known or not,
+	// so being locked out doesn't tell whether someone has an account
+	throttles, err := m.DB.GetLoginThrottles(r.Context(), ip, email)
 	if err != nil {
-		log.Println(err)
-		m.App.Session.Put(r.Context(), "flash", "Invalid login credentials")
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
+	for _, t := range throttles {
+		if t.Locked(time.Now()) {
+			m.App.Session.Put(r.Context(), "error", loginLockedMessage)
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+	}
+
+	// Authenticate the user
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
+	if errors.Is(err, repository.ErrInvalidCredentials) {
+		locked, err := m.recordLoginFailure(r, ip, email)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if locked {
+			m.App.Session.Put(r.Context(), "error", loginLockedMessage)
+		} else {
+			m.App.Session.Put(r.Context(), "flash", "Invalid login credentials")
+		}
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the address keeps its failures, or one account could be used
+	// to reset the count while guessing the passwords of others
+	err = m.DB.ClearLoginFailures(r.Context(), models.LoginScopeAccount, email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	u, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !u.TwoFactorEnabledAt.IsZero() {
+		// the password was right, but they aren't logged in until they give a code
+		m.App.Session.Put(r.Context(), "two_factor_user_id", id)
+		m.App.Session.Put(r.Context(), "two_factor_started", time.Now().Unix())
+		http.Redirect(w, r, "/user/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	// store the id in the session
+	err = m.startUserSession(r, id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 
-	// store the id in the session
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-
 }
 
 // Logout logs a user out
 func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	if key := m.App.Session.GetString(r.Context(), "session_key"); key != "" {
+		if err := m.DB.DeleteUserSessionByKey(r.Context(), key); err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
 	_ = m.App.Session.Destroy(r.Context())
 	// Always good practice to renew the session token
 	_ = m.App.Session.RenewToken(r.Context())
@@ -520,13 +1069,361 @@ func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
+// startUserSession logs a user in, recording the session so that
+// they can see it, and end it, from another one
+func (m *Repository) startUserSession(r *http.Request, userID int) error {
+	key, err := signer.NewToken()
+	if err != nil {
+		return err
+	}
+
+	err = m.DB.InsertUserSession(r.Context(), models.UserSession{
+		UserID:    userID,
+		Key:       key,
+		IP:        helpers.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		return err
+	}
+
+	m.App.Session.Put(r.Context(), "user_id", userID)
+	m.App.Session.Put(r.Context(), "session_key", key)
+	return nil
+}
+
+// loginLockedMessage is shown while an email address or an IP address
+// is locked out after too many failed logins
+const loginLockedMessage = "Too many failed logins, please try again later"
+
+// recordLoginFailure counts a failed login for the IP address and the email address,
+// and reports whether either of them is locked out now
+func (m *Repository) recordLoginFailure(r *http.Request, ip, email string) (bool, error) {
+	now := time.Now()
+
+	byIP, err := m.DB.RecordLoginFailure(r.Context(), models.LoginScopeIP, ip, m.App.LoginIP)
+	if err != nil {
+		return false, err
+	}
+	byAccount, err := m.DB.RecordLoginFailure(r.Context(), models.LoginScopeAccount, email, m.App.LoginAccount)
+	if err != nil {
+		return false, err
+	}
+
+	if byAccount.Locked(now) {
+		m.App.InfoLog.Printf("Login for %s locked out until %s", email, byAccount.LockedUntil.Format(time.RFC3339))
+	}
+	if byIP.Locked(now) {
+		m.App.InfoLog.Printf("Login from %s locked out until %s", ip, byIP.LockedUntil.Format(time.RFC3339))
+	}
+	return byIP.Locked(now) || byAccount.Locked(now), nil
+}
+
+// twoFactorIssuer is how the site is named in authenticator apps
+const twoFactorIssuer = "Fort Smythe"
+
+// twoFactorLoginTTL is how long after giving their password a user can give their code
+const twoFactorLoginTTL = 5 * time.Minute
+
+// TwoFactorLogin shows the second step of logging in, asking for a code
+// from the authenticator app or a recovery code
+func (m *Repository) TwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	if m.pendingTwoFactorUser(r) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Please log in again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	render.Template(w, r, "two-factor.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostTwoFactorLogin checks the code of the second step and logs the user in
+func (m *Repository) PostTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	id := m.pendingTwoFactorUser(r)
+	if id == 0 {
+		m.App.Session.Put(r.Context(), "error", "Please log in again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		render.Template(w, r, "two-factor.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	u, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// codes can be guessed like passwords, so the same lockouts apply
+	ip := helpers.ClientIP(r)
+	throttles, err := m.DB.GetLoginThrottles(r.Context(), ip, u.Email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	for _, t := range throttles {
+		if t.Locked(time.Now()) {
+			m.App.Session.Put(r.Context(), "error", loginLockedMessage)
+			http.Redirect(w, r, "/user/two-factor", http.StatusSeeOther)
+			return
+		}
+	}
+
+	f, err := m.DB.GetTwoFactor(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	usedRecoveryCode, err := m.useTwoFactorCode(r, f, form.Get("code"))
+	if errors.Is(err, repository.ErrInvalidTwoFactorCode) {
+		locked, err := m.recordLoginFailure(r, ip, u.Email)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if locked {
+			m.App.Session.Put(r.Context(), "error", loginLockedMessage)
+		} else {
+			m.App.Session.Put(r.Context(), "error", "Invalid code")
+		}
+		http.Redirect(w, r, "/user/two-factor", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ClearLoginFailures(r.Context(), models.LoginScopeAccount, u.Email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Remove(r.Context(), "two_factor_user_id")
+	m.App.Session.Remove(r.Context(), "two_factor_started")
+	err = m.startUserSession(r, id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	if usedRecoveryCode {
+		m.App.Session.Put(r.Context(), "Warning",
+			fmt.Sprintf("You have %d recovery codes left", f.RecoveryCodesLeft-1))
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// pendingTwoFactorUser returns the id of the user who gave their password and now
+// has to give a code, or 0 when there is nobody or they took too long
+func (m *Repository) pendingTwoFactorUser(r *http.Request) int {
+	id := m.App.Session.GetInt(r.Context(), "two_factor_user_id")
+	started := time.Unix(m.App.Session.GetInt64(r.Context(), "two_factor_started"), 0)
+	if id == 0 || time.Since(started) > twoFactorLoginTTL {
+		m.App.Session.Remove(r.Context(), "two_factor_user_id")
+		m.App.Session.Remove(r.Context(), "two_factor_started")
+		return 0
+	}
+	return id
+}
+
+// useTwoFactorCode checks a code from the authenticator app, or a recovery code,
+// and uses it up. It reports whether it was a recovery code, and returns
+// repository.ErrInvalidTwoFactorCode when the code can't be used.
+func (m *Repository) useTwoFactorCode(r *http.Request, f models.TwoFactor, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(strings.ReplaceAll(code, " ", "")) == totp.Digits {
+		step, ok := totp.Verify(f.Secret, code, time.Now())
+		if !ok {
+			return false, repository.ErrInvalidTwoFactorCode
+		}
+		return false, m.DB.UseTwoFactorCode(r.Context(), f.UserID, step)
+	}
+
+	err := m.DB.UseRecoveryCode(r.Context(), f.UserID, signer.HashToken(totp.NormalizeRecoveryCode(code)))
+	return true, err
+}
+
+// passwordResetTTL is how long a password reset link can be used
+const passwordResetTTL = time.Hour
+
+// forgotPasswordMessage is shown whether or not there is an account for the
+// address, so the form can't be used to find out who has one
+const forgotPasswordMessage = "If there is an account for this address, we have emailed it a link to reset the password"
+
+// ForgotPassword shows the form to ask for a password reset link
+func (m *Repository) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostForgotPassword mails a password reset link to the user with the posted email address
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	u, err := m.DB.GetUserByEmail(r.Context(), form.Get("email"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+	if err == nil && u.DisabledAt.IsZero() {
+		token, err := signer.NewToken()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		err = m.DB.InsertPasswordReset(r.Context(), models.PasswordReset{
+			UserID:    u.ID,
+			TokenHash: signer.HashToken(token),
+			ExpiresAt: time.Now().Add(passwordResetTTL),
+		})
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.sendPasswordReset(r, u, token)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", forgotPasswordMessage)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// ResetPassword shows the form to choose a new password, for the token in a password reset link
+func (m *Repository) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	p, err := m.DB.GetPasswordReset(r.Context(), signer.HashToken(token))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !p.Usable(time.Now())) {
+		m.App.Session.Put(r.Context(), "error", "This link is invalid or has expired. Please ask for a new one.")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// PostResetPassword saves the new password chosen through a password reset link
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	token := r.Form.Get("token")
+
+	form := forms.New(r.PostForm)
+	form.Required("password")
+	form.MinLength("password", minPasswordLength)
+	form.Matches("password", "password_confirm")
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["token"] = token
+
+		render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+			StringMap: stringMap,
+			Form:      form,
+		})
+		return
+	}
+
+	userID, err := m.DB.ResetPassword(r.Context(), signer.HashToken(token), form.Get("password"))
+	if errors.Is(err, repository.ErrInvalidResetToken) {
+		m.App.Session.Put(r.Context(), "error", "This link is invalid or has expired. Please ask for a new one.")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// whoever knew the old password is logged out
+	_, err = m.DB.DeleteUserSessions(r.Context(), userID, "")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// don't let a session that was around before the reset carry on with the new password
+	_ = m.App.Session.RenewToken(r.Context())
+
+	m.App.Session.Put(r.Context(), "flash", "Your password has been changed, you can log in now")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// sendPasswordReset mails a user the link to reset their password with token
+func (m *Repository) sendPasswordReset(r *http.Request, u models.User, token string) {
+	m.queueMail(r, u.Email, "Reset your password", "password-reset.mail.tmpl", email.UserData{
+		User:      u,
+		URL:       fmt.Sprintf("%s/user/reset-password?token=%s", m.App.BaseURL, url.QueryEscape(token)),
+		ValidMins: int(passwordResetTTL.Minutes()),
+	})
+}
+
+// Forbidden tells a logged in user that their role doesn't allow what they asked for
+func (m *Repository) Forbidden(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusForbidden)
+	render.Template(w, r, "forbidden.page.tmpl", &models.TemplateData{})
+}
+
 func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
 }
 
 // AdminAllReservations show all reservations in admin tool
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservation()
+	// an empty status shows every reservation
+	status := models.ReservationStatus(r.URL.Query().Get("status"))
+	if status != "" && !status.Valid() {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	reservations, err := m.DB.AllReservation(r.Context(), status)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -534,15 +1431,20 @@ func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request
 
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
+	data["statuses"] = models.ReservationStatuses
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = string(status)
 
 	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+		Data:      data,
+		StringMap: stringMap,
 	})
 }
 
 // AdminNewReservations shows all new reservations in admin tool
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservation()
+	reservations, err := m.DB.AllNewReservation(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -578,7 +1480,19 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	stringMap["year"] = year
 
 	// get reservation from the database
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	charges, err := m.DB.GetPaymentsForReservation(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	history, err := m.DB.GetStatusHistory(r.Context(), id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -586,6 +1500,8 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["payments"] = charges
+	data["history"] = history
 
 	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
@@ -614,22 +1530,24 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	year := r.Form.Get("year")
 	month := r.Form.Get("month")
 
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
+	before := res
 	res.FirstName = r.Form.Get("first_name")
 	res.LastName = r.Form.Get("last_name")
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
-	err = m.DB.UpdateReservation(res)
+	err = m.DB.UpdateReservation(r.Context(), res)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
+	m.recordAudit(r, audit.ActionUpdate, audit.EntityReservation, id, before, res)
 
 	log.Println("year:", year)
 	log.Println("month:", month)
@@ -690,7 +1608,7 @@ func (m *Repository) AdminReservationsCalender(w http.ResponseWriter, r *http.Re
 	intMap["days_in_month"] = lastOfMonth.Day()
 
 	// get the rooms
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -711,7 +1629,7 @@ func (m *Repository) AdminReservationsCalender(w http.ResponseWriter, r *http.Re
 		//************************************************
 		// get all the restrictions for the current room
 		//***********************************************
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), x.ID, firstOfMonth, lastOfMonth)
 		if err != nil {
 			helpers.ServerError(w, err)
 			return
@@ -764,35 +1682,77 @@ func (m *Repository) AdminReservationsCalender(w http.ResponseWriter, r *http.Re
 	})
 }
 
-//AdminProcessedReservation marks a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+// AdminTransitionReservation moves a reservation to the status in the URL
+func (m *Repository) AdminTransitionReservation(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
+	status := models.ReservationStatus(chi.URLParam(r, "status"))
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
 
-	// set proccessed to 1 (means that reservation is processed)
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
-	if src == "cal" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	if !status.Valid() {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
 	}
 
-}
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-//AdminDeleteReservation delete a reservation by id
+	err = m.DB.TransitionReservation(r.Context(), id, status, m.App.Session.GetInt(r.Context(), "user_id"))
+	switch {
+	case errors.Is(err, repository.ErrInvalidTransition), errors.Is(err, repository.ErrReservationCancelled):
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("This reservation can't be marked as %s", status.Label()))
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.recordAudit(r, audit.ActionTransition, audit.EntityReservation, id,
+			map[string]interface{}{"Status": res.Status}, map[string]interface{}{"Status": status})
+		if status == models.StatusCancelled {
+			// no fee is kept when the staff cancel
+			m.refundPayments(r.Context(), id, 0)
+			m.queueCancellationMail(r, res, 0)
+		}
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation marked as %s", status.Label()))
+	}
+
+	if src == "cal" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	}
+
+}
+
+//AdminDeleteReservation delete a reservation by id
 func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
-	// set proccessed to 1 (means that reservation is processed)
-	_ = m.DB.DeleteReservation(id)
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteReservation(r.Context(), id, m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionDelete, audit.EntityReservation, id, res, nil)
+	// the guest gets their money back for a stay still to come; a cancelled one was settled
+	// when it was cancelled, and the rest are paid for whether the guest came or not
+	if res.Status == models.StatusPending || res.Status == models.StatusConfirmed {
+		m.refundPayments(r.Context(), id, 0)
+	}
+	m.App.Session.Put(r.Context(), "flash", "Reservation moved to the trash")
 
 	if src == "cal" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
@@ -802,6 +1762,72 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 
 }
 
+// AdminReservationsTrash shows the reservations that were deleted and can still be restored
+func (m *Repository) AdminReservationsTrash(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllDeletedReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+
+	intMap := make(map[string]int)
+	intMap["retention_days"] = int(m.App.TrashRetention.Hours() / 24)
+
+	render.Template(w, r, "admin-reservations-trash.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// AdminRestoreReservation takes a reservation out of the trash
+func (m *Repository) AdminRestoreReservation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.RestoreReservation(r.Context(), id)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "The room has been booked for these dates since, so the reservation can't be restored")
+		http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.recordAudit(r, audit.ActionRestore, audit.EntityReservation, id, nil, nil)
+	m.App.Session.Put(r.Context(), "flash", "Reservation restored")
+	http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+}
+
+// AdminPurgeReservation deletes a reservation in the trash for good
+func (m *Repository) AdminPurgeReservation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.PurgeReservation(r.Context(), id)
+	if errors.Is(err, repository.ErrReservationHasPayments) {
+		m.App.Session.Put(r.Context(), "error", "This reservation has payments and can't be deleted for good. It stays in the trash.")
+		http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionPurge, audit.EntityReservation, id, res, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation deleted for good")
+	http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+}
+
 // AdminPostReservationsCalender handles the POST of reservation calendar
 func (m *Repository) AdminPostReservationsCalender(w http.ResponseWriter, r *http.Request) {
 	// parse the form
@@ -815,7 +1841,7 @@ func (m *Repository) AdminPostReservationsCalender(w http.ResponseWriter, r *htt
 	month, _ := strconv.Atoi(r.Form.Get("m"))
 
 	// process blocks
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -832,7 +1858,8 @@ func (m *Repository) AdminPostReservationsCalender(w http.ResponseWriter, r *htt
 		// Loop through entire map , if we have an entry in the map
 		// that does not exist in our posted data and , if the
 		// restriction id > 0, then it is a block we need to remove.
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
+		// a room added since the calendar was displayed has no block map, and no blocks to remove
+		curMap, _ := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
 		for name, value := range curMap {
 			// make sure the value exists in the map
 			// and the value is greater than zero
@@ -847,9 +1874,12 @@ func (m *Repository) AdminPostReservationsCalender(w http.ResponseWriter, r *htt
 					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
 						// delete the restriction by id
 						//log.Println("would delete block", value)
-						err := m.DB.DeleteBlockByID(value)
+						err := m.DB.DeleteBlockByID(r.Context(), value)
 						if err != nil {
 							log.Println(err)
+						} else {
+							m.recordAudit(r, audit.ActionUnblock, audit.EntityRoom, x.ID,
+								map[string]interface{}{"Blocked": name}, nil)
 						}
 					}
 				}
@@ -867,9 +1897,12 @@ func (m *Repository) AdminPostReservationsCalender(w http.ResponseWriter, r *htt
 			t, _ := time.Parse("2006-01-02", exploded[3])
 			// insert a new block for roomID
 			//log.Println("Would insert block for room id", roomID, "for date", exploded[3])
-			err := m.DB.InsertBlockForRoom(roomID, t)
+			err := m.DB.InsertBlockForRoom(r.Context(), roomID, t)
 			if err != nil {
 				log.Println(err)
+			} else {
+				m.recordAudit(r, audit.ActionBlock, audit.EntityRoom, roomID,
+					nil, map[string]interface{}{"Blocked": exploded[3]})
 			}
 		}
 
@@ -879,3 +1912,1402 @@ func (m *Repository) AdminPostReservationsCalender(w http.ResponseWriter, r *htt
 	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
 
 }
+
+// AdminAllRooms shows all rooms in the admin tool
+func (m *Repository) AdminAllRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowRoom shows the room form in the admin tool; id 0 adds a new room
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room := models.Room{Active: true, Capacity: 2}
+	var seasons []models.SeasonalRate
+	if id > 0 {
+		room, err = m.DB.GetRoomByID(r.Context(), id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find room!")
+			http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+			return
+		}
+
+		seasons, err = m.DB.AllSeasonalRatesForRoom(r.Context(), id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["seasons"] = seasons
+
+	render.Template(w, r, "admin-room-show.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// maxRoomCapacity is the most guests a room can be set up to sleep
+const maxRoomCapacity = 20
+
+// AdminPostShowRoom handles the POST of the room form, inserting or updating a room
+func (m *Repository) AdminPostShowRoom(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room := models.Room{Active: true, Capacity: 2}
+	var before interface{}
+	if id > 0 {
+		room, err = m.DB.GetRoomByID(r.Context(), id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find room!")
+			http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+			return
+		}
+		before = room
+	}
+	room.ID = id
+	room.RoomName = r.Form.Get("room_name")
+	room.Slug = strings.TrimSpace(r.Form.Get("slug"))
+	if room.Slug == "" {
+		room.Slug = slugify(room.RoomName)
+		r.PostForm.Set("slug", room.Slug)
+	}
+	room.Description = r.Form.Get("description")
+	room.Capacity, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("capacity")))
+	room.Amenities = models.SplitLines(r.Form.Get("amenities"))
+	room.Photos = nil
+	for _, line := range models.SplitLines(r.Form.Get("photos")) {
+		// each line is "file name" or "file name | caption"
+		parts := strings.SplitN(line, "|", 2)
+		photo := models.RoomPhoto{FileName: strings.TrimSpace(parts[0])}
+		if len(parts) == 2 {
+			photo.Caption = strings.TrimSpace(parts[1])
+		}
+		room.Photos = append(room.Photos, photo)
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name")
+	form.MinLength("room_name", 3)
+	form.IntBetween("capacity", 1, maxRoomCapacity)
+	room.BaseRate, err = pricing.ParseMoney(r.Form.Get("base_rate"))
+	if err != nil || room.BaseRate == 0 {
+		form.Errors.Add("base_rate", "Please enter the price of a night, e.g. 120.00")
+	}
+	room.WeekendRate = 0
+	if strings.TrimSpace(r.Form.Get("weekend_rate")) != "" {
+		room.WeekendRate, err = pricing.ParseMoney(r.Form.Get("weekend_rate"))
+		if err != nil {
+			form.Errors.Add("weekend_rate", "Please enter the price of a night, e.g. 150.00, or leave it empty")
+		}
+	}
+	if form.IsSlug("slug") {
+		// the slug must not be used by another room
+		existing, err := m.DB.GetRoomBySlug(r.Context(), room.Slug)
+		if err == nil && existing.ID != room.ID {
+			form.Errors.Add("slug", "This slug is already used by another room")
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		var seasons []models.SeasonalRate
+		if id > 0 {
+			seasons, err = m.DB.AllSeasonalRatesForRoom(r.Context(), id)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+		}
+
+		data := make(map[string]interface{})
+		data["room"] = room
+		data["seasons"] = seasons
+
+		render.Template(w, r, "admin-room-show.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	// the room is saved together with its photos
+	if id == 0 {
+		room.ID, err = m.DB.InsertRoom(r.Context(), room)
+	} else {
+		err = m.DB.UpdateRoom(r.Context(), room)
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if id == 0 {
+		m.recordAudit(r, audit.ActionCreate, audit.EntityRoom, room.ID, nil, room)
+	} else {
+		m.recordAudit(r, audit.ActionUpdate, audit.EntityRoom, room.ID, before, room)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminActivateRoom puts a room back into service
+func (m *Repository) AdminActivateRoom(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.UpdateActiveForRoom(r.Context(), id, true)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionActivate, audit.EntityRoom, id,
+		map[string]interface{}{"Active": false}, map[string]interface{}{"Active": true})
+
+	m.App.Session.Put(r.Context(), "flash", "Room activated")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminDeactivateRoom takes a room out of service, so it can't be booked any more.
+// Reservations that already exist are kept.
+func (m *Repository) AdminDeactivateRoom(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	upcoming, err := m.DB.CountFutureReservationsForRoom(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateActiveForRoom(r.Context(), id, false)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionDeactivate, audit.EntityRoom, id,
+		map[string]interface{}{"Active": true}, map[string]interface{}{"Active": false})
+
+	if upcoming > 0 {
+		m.App.Session.Put(r.Context(), "Warning",
+			fmt.Sprintf("Room deactivated, but it still has %d upcoming reservation(s)", upcoming))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Room deactivated")
+	}
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminDeleteRoom deletes a room that has never been reserved
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteRoom(r.Context(), id)
+	if errors.Is(err, repository.ErrRoomHasReservations) {
+		m.App.Session.Put(r.Context(), "error", "This room has reservations and can't be deleted. Deactivate it instead.")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/show", id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.recordAudit(r, audit.ActionDelete, audit.EntityRoom, id, room, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Room deleted")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminPostSeasonalRate adds a seasonal rate to a room
+func (m *Repository) AdminPostSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	roomURL := fmt.Sprintf("/admin/rooms/%d/show", roomID)
+
+	layout := "2006-01-02"
+	season := models.SeasonalRate{
+		RoomID: roomID,
+		Name:   strings.TrimSpace(r.Form.Get("name")),
+	}
+
+	var problems []string
+	if season.Name == "" {
+		problems = append(problems, "a name")
+	}
+	season.StartDate, err = time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		problems = append(problems, "a start date")
+	}
+	season.EndDate, err = time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil || season.EndDate.Before(season.StartDate) {
+		problems = append(problems, "an end date on or after the start date")
+	}
+	season.Rate, err = pricing.ParseMoney(r.Form.Get("rate"))
+	if err != nil || season.Rate == 0 {
+		problems = append(problems, "a nightly rate")
+	}
+	if strings.TrimSpace(r.Form.Get("weekend_rate")) != "" {
+		season.WeekendRate, err = pricing.ParseMoney(r.Form.Get("weekend_rate"))
+		if err != nil {
+			problems = append(problems, "a valid weekend rate, or none")
+		}
+	}
+
+	if len(problems) > 0 {
+		m.App.Session.Put(r.Context(), "error", "A seasonal rate needs "+strings.Join(problems, ", "))
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	season.ID, err = m.DB.InsertSeasonalRate(r.Context(), season)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionCreate, audit.EntitySeasonalRate, season.ID, nil, season)
+
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate added")
+	http.Redirect(w, r, roomURL, http.StatusSeeOther)
+}
+
+// AdminDeleteSeasonalRate removes a seasonal rate from a room
+func (m *Repository) AdminDeleteSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	roomID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	seasonID, _ := strconv.Atoi(chi.URLParam(r, "season_id"))
+
+	err := m.DB.DeleteSeasonalRate(r.Context(), seasonID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionDelete, audit.EntitySeasonalRate, seasonID,
+		map[string]interface{}{"RoomID": roomID}, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/show", roomID), http.StatusSeeOther)
+}
+
+// AdminAllPromoCodes shows all promo codes
+func (m *Repository) AdminAllPromoCodes(w http.ResponseWriter, r *http.Request) {
+	codes, err := m.DB.AllPromoCodes(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["promo_codes"] = codes
+
+	render.Template(w, r, "admin-promo-codes.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowPromoCode shows the form to add (id 0) or edit a promo code
+func (m *Repository) AdminShowPromoCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	promo := models.PromoCode{
+		DiscountType: pricing.DiscountPercent,
+		ValidFrom:    today,
+		ValidTo:      today.AddDate(0, 1, 0),
+		MinNights:    1,
+		Active:       true,
+	}
+	if id > 0 {
+		promo, err = m.DB.GetPromoCodeByID(r.Context(), id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find promo code!")
+			http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+			return
+		}
+	}
+
+	m.renderPromoCodeForm(w, r, promo, forms.New(nil))
+}
+
+// AdminPostShowPromoCode handles the POST of the promo code form, inserting or updating a promo code
+func (m *Repository) AdminPostShowPromoCode(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var promo models.PromoCode
+	var before interface{}
+	if id > 0 {
+		promo, err = m.DB.GetPromoCodeByID(r.Context(), id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find promo code!")
+			http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+			return
+		}
+		before = promo
+	}
+	promo.ID = id
+
+	// optional numbers get their defaults
+	if strings.TrimSpace(r.PostForm.Get("min_nights")) == "" {
+		r.PostForm.Set("min_nights", "1")
+	}
+	if strings.TrimSpace(r.PostForm.Get("max_redemptions")) == "" {
+		r.PostForm.Set("max_redemptions", "0")
+	}
+	r.PostForm.Set("code", strings.ToUpper(strings.TrimSpace(r.PostForm.Get("code"))))
+
+	form := forms.New(r.PostForm)
+	form.Required("code", "amount", "valid_from", "valid_to")
+	form.MinLength("code", 3)
+	form.IsAlphanumeric("code")
+	form.IntBetween("min_nights", 1, 365)
+	form.IntBetween("max_redemptions", 0, 1000000)
+
+	promo.Code = form.Get("code")
+	promo.Description = strings.TrimSpace(form.Get("description"))
+	promo.DiscountType = form.Get("discount_type")
+	promo.MinNights, _ = strconv.Atoi(form.Get("min_nights"))
+	promo.MaxRedemptions, _ = strconv.Atoi(form.Get("max_redemptions"))
+	promo.Active = form.Get("active") != ""
+
+	switch promo.DiscountType {
+	case pricing.DiscountPercent:
+		if form.IntBetween("amount", 1, 100) {
+			promo.Amount, _ = strconv.Atoi(strings.TrimSpace(form.Get("amount")))
+		}
+	case pricing.DiscountFixed:
+		promo.Amount, err = pricing.ParseMoney(form.Get("amount"))
+		if err != nil || promo.Amount == 0 {
+			form.Errors.Add("amount", "Please enter an amount, e.g. 25.00")
+		}
+	default:
+		form.Errors.Add("discount_type", "Please choose a type of discount")
+	}
+
+	layout := "2006-01-02"
+	promo.ValidFrom, err = time.Parse(layout, form.Get("valid_from"))
+	if err != nil {
+		form.Errors.Add("valid_from", "Please enter a date")
+	}
+	promo.ValidTo, err = time.Parse(layout, form.Get("valid_to"))
+	if err != nil || promo.ValidTo.Before(promo.ValidFrom) {
+		form.Errors.Add("valid_to", "Please enter a date on or after the first day")
+	}
+
+	promo.RoomIDs = nil
+	for _, x := range r.PostForm["room_ids"] {
+		roomID, err := strconv.Atoi(x)
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		promo.RoomIDs = append(promo.RoomIDs, roomID)
+	}
+
+	if promo.Code != "" {
+		// the code must not be used by another promo code
+		existing, err := m.DB.GetPromoCodeByCode(r.Context(), promo.Code)
+		if err == nil && existing.ID != promo.ID {
+			form.Errors.Add("code", "This code is already used")
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderPromoCodeForm(w, r, promo, form)
+		return
+	}
+
+	if id == 0 {
+		promo.ID, err = m.DB.InsertPromoCode(r.Context(), promo)
+	} else {
+		err = m.DB.UpdatePromoCode(r.Context(), promo)
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if id == 0 {
+		m.recordAudit(r, audit.ActionCreate, audit.EntityPromoCode, promo.ID, nil, promo)
+	} else {
+		m.recordAudit(r, audit.ActionUpdate, audit.EntityPromoCode, promo.ID, before, promo)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code saved")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// renderPromoCodeForm shows the promo code form with the rooms the code can be limited to
+func (m *Repository) renderPromoCodeForm(w http.ResponseWriter, r *http.Request, promo models.PromoCode, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	selected := make(map[int]bool)
+	for _, id := range promo.RoomIDs {
+		selected[id] = true
+	}
+
+	data := make(map[string]interface{})
+	data["promo_code"] = promo
+	data["rooms"] = rooms
+	data["selected_rooms"] = selected
+
+	render.Template(w, r, "admin-promo-code-show.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminDeletePromoCode deletes a promo code that has never been used
+func (m *Repository) AdminDeletePromoCode(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	promo, err := m.DB.GetPromoCodeByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if promo.Redemptions > 0 {
+		m.App.Session.Put(r.Context(), "error", "This promo code has been used and can't be deleted. Deactivate it instead.")
+		http.Redirect(w, r, fmt.Sprintf("/admin/promo-codes/%d/show", id), http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeletePromoCode(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionDelete, audit.EntityPromoCode, id, promo, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code deleted")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// minPasswordLength is the shortest password a user may have
+const minPasswordLength = 8
+
+// AdminAllUsers shows every member of staff
+func (m *Repository) AdminAllUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := m.DB.AllUsers(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["users"] = users
+
+	render.Template(w, r, "admin-users.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowUser shows a user; id 0 shows an empty form for inviting someone
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	u := models.User{AccessLevel: int(models.RoleViewer)}
+	if id > 0 {
+		u, err = m.DB.GetUserByID(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.renderUserForm(w, r, u, forms.New(nil))
+}
+
+// AdminPostShowUser saves a user, or invites a new one when id is 0
+func (m *Repository) AdminPostShowUser(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var u models.User
+	if id > 0 {
+		u, err = m.DB.GetUserByID(r.Context(), id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find user!")
+			http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+			return
+		}
+		// the hash isn't needed here and must not end up in the audit log
+		u.Password = ""
+	}
+	before := u
+	u.ID = id
+
+	r.PostForm.Set("email", strings.ToLower(strings.TrimSpace(r.PostForm.Get("email"))))
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IntBetween("access_level", int(models.RoleViewer), int(models.RoleOwner))
+	if form.IsEmail("email") {
+		_, err = form.Unique("email", func(email string) (bool, error) {
+			existing, err := m.DB.GetUserByEmail(r.Context(), email)
+			if errors.Is(err, sql.ErrNoRows) {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			return existing.ID != id, nil
+		})
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	if id == 0 {
+		form.Required("password")
+		form.MinLength("password", minPasswordLength)
+		form.Matches("password", "password_confirm")
+	}
+
+	u.FirstName = strings.TrimSpace(form.Get("first_name"))
+	u.LastName = strings.TrimSpace(form.Get("last_name"))
+	u.Email = form.Get("email")
+	u.AccessLevel, _ = strconv.Atoi(form.Get("access_level"))
+
+	if !form.Valid() {
+		m.renderUserForm(w, r, u, form)
+		return
+	}
+
+	if id == 0 {
+		u.ID, err = m.DB.InsertUser(r.Context(), u, form.Get("password"))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.recordAudit(r, audit.ActionCreate, audit.EntityUser, u.ID, nil, u)
+		m.sendInvitation(r, u)
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Invitation sent to %s", u.Email))
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateUser(r.Context(), u)
+	if errors.Is(err, repository.ErrLastOwner) {
+		form.Errors.Add("access_level", "This is the last owner. Make someone else an owner first.")
+		m.renderUserForm(w, r, u, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionUpdate, audit.EntityUser, u.ID, before, u)
+
+	m.App.Session.Put(r.Context(), "flash", "User saved")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminPostUserPassword sets a new password for a user, e.g. when they forgot theirs
+func (m *Repository) AdminPostUserPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	u, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find user!")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+	u.Password = ""
+
+	form := forms.New(r.PostForm)
+	form.Required("password")
+	form.MinLength("password", minPasswordLength)
+	form.Matches("password", "password_confirm")
+	if !form.Valid() {
+		m.renderUserForm(w, r, u, form)
+		return
+	}
+
+	err = m.DB.UpdatePassword(r.Context(), id, form.Get("password"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionPassword, audit.EntityUser, id, nil, nil)
+
+	// whoever knew the old password is logged out, but not the one setting the new one
+	except := ""
+	if id == m.App.Session.GetInt(r.Context(), "user_id") {
+		except = m.App.Session.GetString(r.Context(), "session_key")
+	}
+	_, err = m.DB.DeleteUserSessions(r.Context(), id, except)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.queueMail(r, u.Email, "Your password was changed", "password-changed.mail.tmpl", email.UserData{User: u})
+
+	m.App.Session.Put(r.Context(), "flash", "Password changed")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d/show", id), http.StatusSeeOther)
+}
+
+// AdminEnableUser lets a disabled user log in again
+func (m *Repository) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.UpdateActiveForUser(r.Context(), id, true)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionActivate, audit.EntityUser, id,
+		map[string]interface{}{"Active": false}, map[string]interface{}{"Active": true})
+
+	m.App.Session.Put(r.Context(), "flash", "User enabled")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminDisableUser stops a user from logging in. Their account and history are kept.
+func (m *Repository) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if id == m.App.Session.GetInt(r.Context(), "user_id") {
+		m.App.Session.Put(r.Context(), "error", "You can't disable your own account")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.UpdateActiveForUser(r.Context(), id, false)
+	if errors.Is(err, repository.ErrLastOwner) {
+		m.App.Session.Put(r.Context(), "error", "This is the last owner and can't be disabled")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionDeactivate, audit.EntityUser, id,
+		map[string]interface{}{"Active": true}, map[string]interface{}{"Active": false})
+
+	_, err = m.DB.DeleteUserSessions(r.Context(), id, "")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "User disabled")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminLoginLockouts shows the email and IP addresses locked out after too many failed logins
+func (m *Repository) AdminLoginLockouts(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := m.DB.AllLoginLockouts(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["lockouts"] = lockouts
+
+	render.Template(w, r, "admin-login-lockouts.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminUnlockLogin lets an email address or an IP address try to log in again straight away
+func (m *Repository) AdminUnlockLogin(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	l, err := m.DB.UnlockLogin(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the lockout ended or was lifted by someone else
+		m.App.Session.Put(r.Context(), "Warning", "This lockout has already been lifted")
+		http.Redirect(w, r, "/admin/login-lockouts", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionUnlock, audit.EntityLoginLockout, id, l, nil)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Unlocked %s", l.Key))
+	http.Redirect(w, r, "/admin/login-lockouts", http.StatusSeeOther)
+}
+
+// AdminMailOutbox shows the newest messages in the mail outbox, or only those
+// with the status in the query string
+func (m *Repository) AdminMailOutbox(w http.ResponseWriter, r *http.Request) {
+	// an empty status shows every message
+	status := r.URL.Query().Get("status")
+	if status != "" && !models.ValidOutboxStatus(status) {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	messages, err := m.DB.AllOutboxMessages(r.Context(), status)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["messages"] = messages
+	data["statuses"] = models.OutboxStatuses
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	render.Template(w, r, "admin-mail-outbox.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminShowMailMessage shows a message in the mail outbox and how sending it went
+func (m *Repository) AdminShowMailMessage(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	msg, err := m.DB.GetOutboxMessage(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["message"] = msg
+
+	render.Template(w, r, "admin-mail-message.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminResendMail sends a message that was sent or given up on again, straight away
+func (m *Repository) AdminResendMail(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	msg, err := m.DB.GetOutboxMessage(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	page := fmt.Sprintf("/admin/mail-outbox/%d/show", id)
+	err = m.DB.ResendOutboxMessage(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "Warning", "This message is already waiting to be sent")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.wakeMailWorker()
+
+	// the content is left out of the audit log, it may hold a password reset link
+	before := mailAudit{Status: msg.Status, Attempts: msg.Attempts}
+	after := mailAudit{Status: models.OutboxPending}
+	m.recordAudit(r, audit.ActionResend, audit.EntityMail, id, before, after)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("The message to %s will be sent again", msg.Mail.To))
+	http.Redirect(w, r, page, http.StatusSeeOther)
+}
+
+// mailAudit is what the audit log keeps of a message in the mail outbox
+type mailAudit struct {
+	Status   string
+	Attempts int
+}
+
+// AdminTwoFactor shows the two-factor login of the logged in user, or the QR code
+// for setting it up. Recovery codes are shown once, right after they were made.
+func (m *Repository) AdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	f, err := m.DB.GetTwoFactor(r.Context(), u.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["two_factor"] = f
+	if codes, ok := m.App.Session.Pop(r.Context(), "recovery_codes").([]string); ok {
+		data["recovery_codes"] = codes
+	}
+
+	stringMap := make(map[string]string)
+	if f.EnabledAt.IsZero() {
+		// the secret is only stored once a code from it has been checked
+		secret := m.App.Session.GetString(r.Context(), "two_factor_secret")
+		if secret == "" {
+			secret, err = totp.NewSecret()
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			m.App.Session.Put(r.Context(), "two_factor_secret", secret)
+		}
+		stringMap["secret"] = secret
+		stringMap["uri"] = totp.URI(twoFactorIssuer, u.Email, secret)
+	}
+	if u.AccessLevel > m.App.TwoFactorAccessLevel {
+		stringMap["required"] = "true"
+	}
+
+	render.Template(w, r, "admin-two-factor.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostTwoFactor turns on two-factor login for the logged in user, once they
+// have given a code from the secret they were shown
+func (m *Repository) AdminPostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id := m.App.Session.GetInt(r.Context(), "user_id")
+	secret := m.App.Session.GetString(r.Context(), "two_factor_secret")
+	if secret == "" {
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	step, ok := totp.Verify(secret, form.Get("code"), time.Now())
+	if form.Valid() && !ok {
+		form.Errors.Add("code", "This code isn't right, check that the time on your device is correct")
+	}
+	if !form.Valid() {
+		u, err := m.DB.GetUserByID(r.Context(), id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data := make(map[string]interface{})
+		data["two_factor"] = models.TwoFactor{UserID: id}
+		render.Template(w, r, "admin-two-factor.page.tmpl", &models.TemplateData{
+			Data: data,
+			StringMap: map[string]string{
+				"secret": secret,
+				"uri":    totp.URI(twoFactorIssuer, u.Email, secret),
+			},
+			Form: form,
+		})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.DB.EnableTwoFactor(r.Context(), id, secret, step, hashes)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionUpdate, audit.EntityUser, id,
+		map[string]interface{}{"TwoFactor": false}, map[string]interface{}{"TwoFactor": true})
+
+	m.App.Session.Remove(r.Context(), "two_factor_secret")
+	m.App.Session.Put(r.Context(), "recovery_codes", codes)
+	m.App.Session.Put(r.Context(), "flash", "Two-factor login is on")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// AdminPostRecoveryCodes gives the logged in user new recovery codes, and stops the old ones working
+func (m *Repository) AdminPostRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	id := m.App.Session.GetInt(r.Context(), "user_id")
+
+	f, err := m.DB.GetTwoFactor(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if f.EnabledAt.IsZero() {
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.DB.ReplaceRecoveryCodes(r.Context(), id, hashes)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "recovery_codes", codes)
+	m.App.Session.Put(r.Context(), "flash", "New recovery codes made, the old ones no longer work")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// AdminPostDisableTwoFactor turns off two-factor login for the logged in user,
+// after checking their password, unless their role must use it
+func (m *Repository) AdminPostDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if u.AccessLevel > m.App.TwoFactorAccessLevel {
+		m.App.Session.Put(r.Context(), "error", "Your role must use two-factor login")
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	_, _, err = m.DB.Authenticate(r.Context(), u.Email, r.Form.Get("password"))
+	if errors.Is(err, repository.ErrInvalidCredentials) {
+		m.App.Session.Put(r.Context(), "error", "Your password isn't right")
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DisableTwoFactor(r.Context(), u.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionUpdate, audit.EntityUser, u.ID,
+		map[string]interface{}{"TwoFactor": true}, map[string]interface{}{"TwoFactor": false})
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor login is off")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// AdminResetTwoFactor turns off two-factor login for a user who lost their device
+// and their recovery codes, so they can set it up again
+func (m *Repository) AdminResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DisableTwoFactor(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionUpdate, audit.EntityUser, id,
+		map[string]interface{}{"TwoFactor": true}, map[string]interface{}{"TwoFactor": false})
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor login reset, the user will set it up again when they log in")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d/show", id), http.StatusSeeOther)
+}
+
+// newRecoveryCodes returns new recovery codes to show the user, and the hashes of them to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := totp.NewRecoveryCodes(totp.RecoveryCodes)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = signer.HashToken(c)
+	}
+	return codes, hashes, nil
+}
+
+// AdminSessions shows where the logged in user is logged in
+func (m *Repository) AdminSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := m.DB.AllUserSessions(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["sessions"] = sessions
+
+	render.Template(w, r, "admin-sessions.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: map[string]string{"current": m.App.Session.GetString(r.Context(), "session_key")},
+	})
+}
+
+// AdminRevokeSession logs the logged in user out of one of their other sessions
+func (m *Repository) AdminRevokeSession(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteUserSession(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "Warning", "This session has already ended")
+		http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Session ended")
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
+
+// AdminRevokeOtherSessions logs the logged in user out everywhere but here
+func (m *Repository) AdminRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	n, err := m.DB.DeleteUserSessions(r.Context(),
+		m.App.Session.GetInt(r.Context(), "user_id"),
+		m.App.Session.GetString(r.Context(), "session_key"),
+	)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Ended %d other sessions", n))
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
+
+// AdminRevokeUserSession logs a user out of one of their sessions
+func (m *Repository) AdminRevokeUserSession(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	sessionID, _ := strconv.Atoi(chi.URLParam(r, "session_id"))
+
+	err := m.DB.DeleteUserSession(r.Context(), id, sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "Warning", "This session has already ended")
+		http.Redirect(w, r, fmt.Sprintf("/admin/users/%d/show", id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionRevoke, audit.EntityUser, id, nil, map[string]interface{}{"Session": sessionID})
+
+	m.App.Session.Put(r.Context(), "flash", "Session ended")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d/show", id), http.StatusSeeOther)
+}
+
+// AdminRevokeUserSessions logs a user out everywhere
+func (m *Repository) AdminRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	// an admin doing this to themselves stays logged in here
+	except := ""
+	if id == m.App.Session.GetInt(r.Context(), "user_id") {
+		except = m.App.Session.GetString(r.Context(), "session_key")
+	}
+	n, err := m.DB.DeleteUserSessions(r.Context(), id, except)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, audit.ActionRevoke, audit.EntityUser, id, nil, map[string]interface{}{"Sessions": n})
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Ended %d sessions", n))
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d/show", id), http.StatusSeeOther)
+}
+
+// renderUserForm shows the user form with the roles a user can have
+func (m *Repository) renderUserForm(w http.ResponseWriter, r *http.Request, u models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = u
+	data["roles"] = models.Roles
+	if u.ID > 0 {
+		sessions, err := m.DB.AllUserSessions(r.Context(), u.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["sessions"] = sessions
+	}
+
+	render.Template(w, r, "admin-user-show.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// sendInvitation tells a new user that they have an account
+func (m *Repository) sendInvitation(r *http.Request, u models.User) {
+	m.queueMail(r, u.Email, "You have been invited", "invitation.mail.tmpl", email.UserData{
+		User: u,
+		URL:  m.App.BaseURL + "/user/login",
+	})
+}
+
+// AdminAudit shows the audit log, filtered by the user, entity and dates in the query string
+func (m *Repository) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	layout := "2006-01-02"
+	q := r.URL.Query()
+
+	var filter models.AuditFilter
+	var err error
+	if q.Get("user") != "" {
+		filter.UserID, err = strconv.Atoi(q.Get("user"))
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	filter.Entity = q.Get("entity")
+	if filter.Entity != "" && !audit.ValidEntity(filter.Entity) {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if q.Get("from") != "" {
+		filter.From, err = time.Parse(layout, q.Get("from"))
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	if q.Get("to") != "" {
+		filter.To, err = time.Parse(layout, q.Get("to"))
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := m.DB.AllAuditEntries(r.Context(), filter)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	users, err := m.DB.AllAuditUsers(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+	data["users"] = users
+	data["entities"] = audit.Entities
+
+	stringMap := make(map[string]string)
+	stringMap["entity"] = filter.Entity
+	stringMap["from"] = q.Get("from")
+	stringMap["to"] = q.Get("to")
+
+	intMap := make(map[string]int)
+	intMap["user_id"] = filter.UserID
+
+	render.Template(w, r, "admin-audit.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+	})
+}
+
+// recordAudit adds a change made by the logged in user to the audit log. The change is
+// already saved by then, so a failure is logged instead of failing the request.
+func (m *Repository) recordAudit(r *http.Request, action, entity string, entityID int, before, after interface{}) {
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+
+	err = m.DB.InsertAuditEntry(r.Context(), models.AuditEntry{
+		UserID:   m.App.Session.GetInt(r.Context(), "user_id"),
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Changes:  changes,
+	})
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// queueMail makes an email to `to` from the email template tmpl and data, with any
+// attachments, and puts it in the outbox, in the same request that made it. It is kept
+// there until the mail worker manages to send it
+func (m *Repository) queueMail(r *http.Request, to, subject, tmpl string, data interface{}, attachments ...models.Attachment) {
+	msg, err := email.Message(to, subject, tmpl, data)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+	msg.Attachments = attachments
+
+	if _, err := m.DB.InsertOutboxMessage(r.Context(), msg); err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+	m.wakeMailWorker()
+}
+
+// wakeMailWorker tells the mail worker to look at the outbox straight away
+func (m *Repository) wakeMailWorker() {
+	select {
+	case m.App.MailQueued <- struct{}{}:
+	default:
+		// the worker is already due to look at the outbox
+	}
+}
+
+// parseCardExpiry reads a card expiry date typed as MM/YY or MM/YYYY
+func parseCardExpiry(s string) (month, year int, ok bool) {
+	parts := strings.Split(strings.ReplaceAll(s, " ", ""), "/")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	month, err := strconv.Atoi(parts[0])
+	if err != nil || month < 1 || month > 12 {
+		return 0, 0, false
+	}
+	year, err = strconv.Atoi(parts[1])
+	if err != nil || (len(parts[1]) != 2 && len(parts[1]) != 4) {
+		return 0, 0, false
+	}
+	if year < 100 {
+		year += 2000
+	}
+	return month, year, true
+}
+
+// promoCodeMessage explains to a guest why a promo code can't be used
+func promoCodeMessage(err error) string {
+	switch {
+	case errors.Is(err, pricing.ErrPromoNotStarted):
+		return "This promo code can't be used yet"
+	case errors.Is(err, pricing.ErrPromoExpired), errors.Is(err, pricing.ErrPromoInactive):
+		return "This promo code is no longer valid"
+	case errors.Is(err, pricing.ErrPromoMinStay):
+		return "Your stay is too short for this promo code"
+	case errors.Is(err, pricing.ErrPromoRoom):
+		return "This promo code can't be used for this room"
+	case errors.Is(err, pricing.ErrPromoExhausted):
+		return "This promo code has been used up"
+	}
+	return "This promo code can't be used"
+}
+
+// quote prices a stay in a room, applying the room's seasonal rates
+func (m *Repository) quote(ctx context.Context, room models.Room, start, end time.Time) ([]models.NightlyRate, int, error) {
+	seasons, err := m.DB.GetSeasonalRatesForRoom(ctx, room.ID, start, end)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pricing.Quote(room, seasons, start, end)
+}
+
+// slugify turns a room name into a slug, e.g. "Major's Suite" becomes "major-s-suite"
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
@@ -11,7 +11,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/byt3er/bookings/internals/email"
+	"github.com/byt3er/bookings/internals/helpers"
 	"github.com/byt3er/bookings/internals/models"
+	"github.com/byt3er/bookings/internals/payments"
+	"github.com/byt3er/bookings/internals/totp"
 	"github.com/go-chi/chi"
 )
 
@@ -40,8 +44,14 @@ var theTests = []struct {
 	{"about", "/about", "GET", http.StatusOK},
 	{"gq", "/generals-quaters", "GET", http.StatusOK},
 	{"ms", "/majors-suite", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
+	{"room", "/rooms/generals-quarters", "GET", http.StatusOK},
+	{"inactive room", "/rooms/inactive", "GET", http.StatusNotFound},
+	{"unknown room", "/rooms/no-such-room", "GET", http.StatusNotFound},
+	{"room db error", "/rooms/db-error", "GET", http.StatusInternalServerError},
 	{"sa", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
+	{"lookup-reservation", "/reservation/lookup", "GET", http.StatusOK},
 	{"non-existent", "/green/eggs/and/han", "GET", http.StatusNotFound},
 
 	// new routes
@@ -50,7 +60,32 @@ var theTests = []struct {
 	{"dashboard", "/admin/dashboard", "GET", http.StatusOK},
 	{"new res", "/admin/reservations-new", "GET", http.StatusOK},
 	{"all res", "/admin/reservations-all", "GET", http.StatusOK},
+	{"trash", "/admin/reservations-trash", "GET", http.StatusOK},
+	{"audit", "/admin/audit", "GET", http.StatusOK},
+	{"forgot password", "/user/forgot-password", "GET", http.StatusOK},
+	{"users", "/admin/users", "GET", http.StatusOK},
+	{"login lockouts", "/admin/login-lockouts", "GET", http.StatusOK},
+	{"my sessions", "/admin/sessions", "GET", http.StatusOK},
+	{"mail outbox", "/admin/mail-outbox", "GET", http.StatusOK},
+	{"mail outbox by status", "/admin/mail-outbox?status=dead", "GET", http.StatusOK},
+	{"mail outbox unknown status", "/admin/mail-outbox?status=lost", "GET", http.StatusBadRequest},
+	{"show mail", "/admin/mail-outbox/3/show", "GET", http.StatusOK},
+	{"show mail with attachment", "/admin/mail-outbox/1/show", "GET", http.StatusOK},
+	{"show unknown mail", "/admin/mail-outbox/50/show", "GET", http.StatusNotFound},
+	{"show mail fails", "/admin/mail-outbox/101/show", "GET", http.StatusInternalServerError},
+	{"invite user", "/admin/users/0/show", "GET", http.StatusOK},
+	{"show user", "/admin/users/2/show", "GET", http.StatusOK},
+	{"unknown user", "/admin/users/99/show", "GET", http.StatusNotFound},
+	{"all res by status", "/admin/reservations-all?status=checked_in", "GET", http.StatusOK},
+	{"all res unknown status", "/admin/reservations-all?status=processed", "GET", http.StatusBadRequest},
+	{"all res db error", "/admin/reservations-all?status=no_show", "GET", http.StatusInternalServerError},
 	{"show res", "/admin/reservations/new/7/show", "GET", http.StatusOK},
+	{"all rooms", "/admin/rooms", "GET", http.StatusOK},
+	{"show room", "/admin/rooms/1/show", "GET", http.StatusOK},
+	{"new room", "/admin/rooms/0/show", "GET", http.StatusOK},
+	{"all promo codes", "/admin/promo-codes", "GET", http.StatusOK},
+	{"show promo code", "/admin/promo-codes/1/show", "GET", http.StatusOK},
+	{"new promo code", "/admin/promo-codes/0/show", "GET", http.StatusOK},
 	// {"mr", "/make-reservation", "GET", []postData{}, http.StatusOK},
 
 	// {"post-search-avail", "/search-availability", "POST", []postData{
@@ -100,7 +135,9 @@ func TestHandlers(t *testing.T) {
 // test for the Reservation hander
 func TestRepository_Reservation(t *testing.T) {
 	reservation := models.Reservation{
-		RoomID: 1,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
 		Room: models.Room{
 			ID:       1,
 			RoomName: "Generals's Quaters",
@@ -166,6 +203,20 @@ func TestRepository_Reservation(t *testing.T) {
 		t.Errorf("Reservation handler returned wrong response code : got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
 
+	//*********** *********/
+	// test with seasonal rates that can't be loaded
+
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr = httptest.NewRecorder()
+	reservation.RoomID = 2
+	session.Put(ctx, "reservation", reservation)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("Reservation handler returned wrong response code for pricing error: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
 }
 
 //{"post-make-reservation", "/make-reservation", "POST", []postData{
@@ -182,6 +233,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=555-555-5555")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
 
 	postedData := url.Values{}
 	postedData.Encode()
@@ -206,6 +258,7 @@ func TestRepository_PostReservation(t *testing.T) {
 		RoomID:    1,
 		Room: models.Room{
 			RoomName: "General's Quaters",
+			Capacity: 2,
 		},
 	}
 	session.Put(ctx, "reservation", reservation)
@@ -224,6 +277,9 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("PostReservation handler returned wrong response code : got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
+	if loc, _ := rr.Result().Location(); loc == nil || loc.String() != "/checkout" {
+		t.Errorf("PostReservation handler redirected to wrong location: got %v, wanted %s", loc, "/checkout")
+	}
 
 	// ********************************
 	// test for missing POST body (failing Parse form)
@@ -284,89 +340,37 @@ func TestRepository_PostReservation(t *testing.T) {
 		t.Errorf("PostReservation handler returned wrong response code for missing post body: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
 
-	//****************************************
-	// test : failed to enter new reservation
+	// test : more guests than the room sleeps
 
 	reqBody = "first_name=John"
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=555-555-5555")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2&children=1")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req) // this ctx knows about the session
+	ctx = getCtx(req)
 	req = req.WithContext(ctx)
 
-	layout = "2006-01-02"
-	startDate, err = time.Parse(layout, "2050-01-01") //date
-	if err != nil {
-		log.Println("unable to parse start-date")
-	}
-	endDate, err = time.Parse(layout, "2050-01-02") // date
-	if err != nil {
-		log.Println("unable to parse end-date!")
-	}
-
 	reservation = models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
-		RoomID:    23,
+		RoomID:    1,
 		Room: models.Room{
-			RoomName: "General's Home",
+			RoomName: "General's Quaters",
+			Capacity: 2,
 		},
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded") // get pain in the ass
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	session.Put(ctx, "reservation", reservation)
 	rr = httptest.NewRecorder()
 	handler = http.HandlerFunc(Repo.PostReservation)
 
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler returned wrong response code for failed to enter new reservation: got %d, wanted %d", rr.Code, http.StatusSeeOther)
-	}
-
-	// test : failed to enter new room-restriction
-
-	reqBody = "first_name=John"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=555-555-5555")
-
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req) // this ctx knows about the session
-	req = req.WithContext(ctx)
-
-	layout = "2006-01-02"
-	startDate, err = time.Parse(layout, "2050-01-01") //date
-	if err != nil {
-		log.Println("unable to parse start-date")
-	}
-	endDate, err = time.Parse(layout, "2050-01-02") // date
-	if err != nil {
-		log.Println("unable to parse end-date!")
-	}
-
-	reservation = models.Reservation{
-		StartDate: startDate,
-		EndDate:   endDate,
-		RoomID:    2,
-		Room: models.Room{
-			RoomName: "General's Home",
-		},
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded") // get pain in the ass
-	session.Put(ctx, "reservation", reservation)
-	rr = httptest.NewRecorder()
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-	fmt.Println("******Room Restriction failed********")
-	fmt.Println("rr.Code:", rr.Code)
-	fmt.Println("Temporary Redirect:", http.StatusTemporaryRedirect)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler returned wrong response code for failed to enter new room-restriction: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	if rr.Code != http.StatusOK {
+		t.Errorf("PostReservation handler returned wrong response code for too many guests: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
-
 }
 
 func TestRepository_PostAvailability(t *testing.T) {
@@ -455,6 +459,50 @@ func TestRepository_PostAvailability(t *testing.T) {
 		t.Errorf("test failed for fail parseform() : got %d expected %d", rr.Code, http.StatusSeeOther)
 	}
 
+	// Test for available rooms, with prices
+	reqBody = "start=2050-12-02"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2050-12-05")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
+	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostAvailability).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("test failed for available rooms : got %d expected %d", rr.Code, http.StatusOK)
+	}
+
+	// Test for invalid number of guests
+	reqBody = "start=2050-12-02"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2050-12-05")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=0")
+	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostAvailability).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("test failed for invalid number of guests : got %d expected %d", rr.Code, http.StatusSeeOther)
+	}
+
+	// Test for no room big enough
+	reqBody = "start=2050-12-02"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2050-12-05")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=4&children=2")
+	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostAvailability).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("test failed for no room big enough : got %d expected %d", rr.Code, http.StatusSeeOther)
+	}
 }
 
 func TestRepository_ReservationSummary(t *testing.T) {
@@ -465,6 +513,7 @@ func TestRepository_ReservationSummary(t *testing.T) {
 		RoomID:    2,
 		Room: models.Room{
 			RoomName: "General's Home",
+			Capacity: 2,
 		},
 	}
 	req, _ := http.NewRequest("GET", "/reservation-summary", nil)
@@ -678,257 +727,1866 @@ func TestLogin(t *testing.T) {
 	}
 }
 
-var adminPostShowReservationTests = []struct {
+var loginLockoutTests = []struct {
 	name                 string
-	url                  string
-	postedData           url.Values
+	email                string
+	remoteAddr           string
 	expectedResponseCode int
-	expectedLocation     string
-	expectedHTML         string
+	expectedFlash        string
+	expectedError        string
 }{
-	{
-		name: "valid-data-from-new",
-		url:  "/admin/reservations/new/1/show",
-		postedData: url.Values{
-			"first_name": {"John"},
-			"last_name":  {"Smith"},
-			"email":      {"john@smith.com"},
-			"phone":      {"555-555-5555"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "/admin/reservations-new",
-		expectedHTML:         "",
-	},
-	{
-		name: "valid-data-from-all",
-		url:  "/admin/reservations/all/1/show",
-		postedData: url.Values{
-			"first_name": {"John"},
-			"last_name":  {"Smith"},
-			"email":      {"john@smith.com"},
-			"phone":      {"555-555-5555"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "/admin/reservations-all",
-		expectedHTML:         "",
-	},
-	{
-		name: "valid-data-from-cal",
-		url:  "/admin/reservations/cal/1/show",
-		postedData: url.Values{
-			"first_name": {"John"},
-			"last_name":  {"Smith"},
-			"email":      {"john@smith.com"},
-			"phone":      {"555-555-5555"},
-			"year":       {"2022"},
-			"month":      {"01"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "/admin/reservations-calendar?y=2022&m=01",
-		expectedHTML:         "",
-	},
+	{"valid-credentials", "me@here.ca", "10.0.0.1:5000", http.StatusSeeOther, "Logged in successfully", ""},
+	{"wrong-password", "jane@here.ca", "10.0.0.1:5000", http.StatusSeeOther, "Invalid login credentials", ""},
+	{"unknown-email", "nobody@here.ca", "10.0.0.1:5000", http.StatusSeeOther, "Invalid login credentials", ""},
+	{"account-locked", "locked@here.ca", "10.0.0.1:5000", http.StatusSeeOther, "", loginLockedMessage},
+	// the right password doesn't help while the address is locked out
+	{"address-locked", "me@here.ca", "10.0.0.66:5000", http.StatusSeeOther, "", loginLockedMessage},
+	{"failure-locks", "lastchance@here.ca", "10.0.0.1:5000", http.StatusSeeOther, "", loginLockedMessage},
+	{"lockout-lookup-fails", "lockerror@here.ca", "10.0.0.1:5000", http.StatusInternalServerError, "", ""},
+	{"recording-failure-fails", "failure@here.ca", "10.0.0.1:5000", http.StatusInternalServerError, "", ""},
+	{"authenticate-fails", "dberror@here.ca", "10.0.0.1:5000", http.StatusInternalServerError, "", ""},
 }
 
-// TestAdminPostShowReservation tests the AdminPostReservation handler
-func TestAdminPostShowReservation(t *testing.T) {
-	for _, e := range adminPostShowReservationTests {
-		var req *http.Request
-		if e.postedData != nil {
-			req, _ = http.NewRequest("POST", "/user/login", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req, _ = http.NewRequest("POST", "/user/login", nil)
-		}
+// TestLoginLockout tests that too many failed logins lock out an email or IP address
+func TestLoginLockout(t *testing.T) {
+	for _, e := range loginLockoutTests {
+		postedData := url.Values{"email": {e.email}, "password": {"password"}}
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		req.RemoteAddr = e.remoteAddr
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
-		req.RequestURI = e.url
-
-		// set the header
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
-		handler.ServeHTTP(rr, req)
+		http.HandlerFunc(Repo.PostShowLogin).ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
 
-		if e.expectedLocation != "" {
-			// get the URL from test
-			actualLoc, _ := rr.Result().Location()
-			if actualLoc.String() != e.expectedLocation {
-				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
-			}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
 		}
 
-		// checking for expected values in HTML
-		if e.expectedHTML != "" {
-			// read the response body into a string
-			html := rr.Body.String()
-			if !strings.Contains(html, e.expectedHTML) {
-				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
-			}
+		// every login is recorded, so it can be ended from another session
+		loggedIn := session.Exists(ctx, "user_id")
+		if loggedIn != (session.GetString(ctx, "session_key") != "") {
+			t.Errorf("failed %s: expected a session key only when logged in", e.name)
 		}
 	}
 }
 
-var adminPostReservationCalendarTests = []struct {
+// testTOTPSecret is the two-factor secret the test repository gives user 5
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// TestLoginTwoFactor tests that a user with two-factor login isn't logged in by their password alone
+func TestLoginTwoFactor(t *testing.T) {
+	postedData := url.Values{"email": {"two@here.ca"}, "password": {"password"}}
+	req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.PostShowLogin).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if actualLoc, _ := rr.Result().Location(); actualLoc.String() != "/user/two-factor" {
+		t.Errorf("expected location /user/two-factor, but got %s", actualLoc)
+	}
+	if session.Exists(ctx, "user_id") {
+		t.Error("expected the user not to be logged in yet")
+	}
+	if id := session.GetInt(ctx, "two_factor_user_id"); id != 5 {
+		t.Errorf("expected user 5 to be asked for a code, but got %d", id)
+	}
+}
+
+var postTwoFactorLoginTests = []struct {
 	name                 string
-	postedData           url.Values
+	code                 string
+	started              time.Duration // before now
 	expectedResponseCode int
 	expectedLocation     string
-	expectedHTML         string
-	blocks               int
-	reservations         int
+	expectedWarning      string
 }{
-	{
-		name: "cal",
-		postedData: url.Values{
-			"year":  {time.Now().Format("2006")},
-			"month": {time.Now().Format("01")},
-			fmt.Sprintf("add_block_1_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-	},
-	{
-		name:                 "cal-blocks",
-		postedData:           url.Values{},
-		expectedResponseCode: http.StatusSeeOther,
-		blocks:               1,
-	},
-	{
-		name:                 "cal-res",
-		postedData:           url.Values{},
-		expectedResponseCode: http.StatusSeeOther,
-		reservations:         1,
-	},
+	{"app-code", "current", time.Minute, http.StatusSeeOther, "/", ""},
+	{"old-app-code", "old", time.Minute, http.StatusSeeOther, "/user/two-factor", ""},
+	{"recovery-code", "abcde fghjk", time.Minute, http.StatusSeeOther, "/", "You have 8 recovery codes left"},
+	{"unknown-recovery-code", "ZZZZZ-ZZZZZ", time.Minute, http.StatusSeeOther, "/user/two-factor", ""},
+	{"missing-code", "", time.Minute, http.StatusOK, "", ""},
+	{"too-slow", "current", 10 * time.Minute, http.StatusSeeOther, "/user/login", ""},
 }
 
-func TestPostReservationCalendar(t *testing.T) {
-	for _, e := range adminPostReservationCalendarTests {
-		var req *http.Request
-		if e.postedData != nil {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", nil)
+// TestPostTwoFactorLogin tests the second step of logging in
+func TestPostTwoFactorLogin(t *testing.T) {
+	for _, e := range postTwoFactorLoginTests {
+		code := e.code
+		switch code {
+		case "current":
+			code, _ = totp.Code(testTOTPSecret, totp.Step(time.Now()))
+		case "old":
+			code, _ = totp.Code(testTOTPSecret, totp.Step(time.Now())-10)
 		}
+
+		postedData := url.Values{"code": {code}}
+		req, _ := http.NewRequest("POST", "/user/two-factor", strings.NewReader(postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
-
-		now := time.Now()
-		bm := make(map[string]int)
-		rm := make(map[string]int)
-
-		currentYear, currentMonth, _ := now.Date()
-		currentLocation := now.Location()
-
-		firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
-
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			rm[d.Format("2006-01-2")] = 0
-			bm[d.Format("2006-01-2")] = 0
-		}
-
-		if e.blocks > 0 {
-			bm[firstOfMonth.Format("2006-01-2")] = e.blocks
-		}
-
-		if e.reservations > 0 {
-			rm[lastOfMonth.Format("2006-01-2")] = e.reservations
-		}
-
-		session.Put(ctx, "block_map_1", bm)
-		session.Put(ctx, "reservation_map_1", rm)
-
-		// set the header
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "two_factor_user_id", 5)
+		session.Put(ctx, "two_factor_started", time.Now().Add(-e.started).Unix())
 		rr := httptest.NewRecorder()
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostReservationsCalender)
-		handler.ServeHTTP(rr, req)
+		http.HandlerFunc(Repo.PostTwoFactorLogin).ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
 
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		loggedIn := session.GetInt(ctx, "user_id") == 5
+		if loggedIn != (e.expectedLocation == "/") {
+			t.Errorf("failed %s: expected logged in to be %v", e.name, !loggedIn)
+		}
+		if warning := session.GetString(ctx, "Warning"); warning != e.expectedWarning {
+			t.Errorf("failed %s: expected warning %q, but got %q", e.name, e.expectedWarning, warning)
+		}
 	}
 }
 
-var adminProcessReservationTests = []struct {
+func TestTwoFactorLogin(t *testing.T) {
+	// nobody gave their password
+	req, _ := http.NewRequest("GET", "/user/two-factor", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.TwoFactorLogin).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/user/two-factor", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "two_factor_user_id", 5)
+	session.Put(ctx, "two_factor_started", time.Now().Unix())
+	rr = httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.TwoFactorLogin).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `action="/user/two-factor"`) {
+		t.Error("expected the code form")
+	}
+}
+
+var adminTwoFactorTests = []struct {
+	name         string
+	userID       int
+	expectedHTML string
+}{
+	{"set-up", 1, `data-otpauth="otpauth://totp/Fort%20Smythe:me@here.ca?`},
+	{"turned-on", 5, "You have 9 recovery codes left"},
+}
+
+// TestAdminTwoFactor tests the page for setting up two-factor login
+func TestAdminTwoFactor(t *testing.T) {
+	for _, e := range adminTwoFactorTests {
+		req, _ := http.NewRequest("GET", "/admin/two-factor", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", e.userID)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminPostTwoFactorTests = []struct {
+	name                 string
+	secret               string
+	code                 string
+	expectedResponseCode int
+	expectedCodes        bool
+}{
+	{"turn-on", testTOTPSecret, "current", http.StatusSeeOther, true},
+	{"wrong-code", testTOTPSecret, "old", http.StatusOK, false},
+	{"missing-code", testTOTPSecret, "", http.StatusOK, false},
+	{"no-secret", "", "current", http.StatusSeeOther, false},
+}
+
+// TestAdminPostTwoFactor tests turning on two-factor login
+func TestAdminPostTwoFactor(t *testing.T) {
+	for _, e := range adminPostTwoFactorTests {
+		code := e.code
+		switch code {
+		case "current":
+			code, _ = totp.Code(testTOTPSecret, totp.Step(time.Now()))
+		case "old":
+			code, _ = totp.Code(testTOTPSecret, totp.Step(time.Now())-10)
+		}
+
+		postedData := url.Values{"code": {code}}
+		req, _ := http.NewRequest("POST", "/admin/two-factor", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "user_id", 1)
+		if e.secret != "" {
+			session.Put(ctx, "two_factor_secret", e.secret)
+		}
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		codes, _ := session.Get(ctx, "recovery_codes").([]string)
+		if e.expectedCodes && len(codes) != totp.RecoveryCodes {
+			t.Errorf("failed %s: expected %d recovery codes, but got %d", e.name, totp.RecoveryCodes, len(codes))
+		}
+		if !e.expectedCodes && codes != nil {
+			t.Errorf("failed %s: expected no recovery codes", e.name)
+		}
+		if e.expectedCodes && session.Exists(ctx, "two_factor_secret") {
+			t.Errorf("failed %s: expected the secret to be forgotten once it is stored", e.name)
+		}
+	}
+}
+
+func TestAdminPostRecoveryCodes(t *testing.T) {
+	for _, id := range []int{1, 5} {
+		req, _ := http.NewRequest("POST", "/admin/two-factor/recovery-codes", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", id)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostRecoveryCodes).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("user %d: expected code %d, but got %d", id, http.StatusSeeOther, rr.Code)
+		}
+		// only users with two-factor login get recovery codes
+		codes, _ := session.Get(ctx, "recovery_codes").([]string)
+		if (len(codes) > 0) != (id == 5) {
+			t.Errorf("user %d: got %d recovery codes", id, len(codes))
+		}
+	}
+}
+
+var adminPostDisableTwoFactorTests = []struct {
+	name          string
+	level         int
+	expectedFlash string
+	expectedError string
+}{
+	{"turn-off", int(models.RoleOwner), "Two-factor login is off", ""},
+	{"required-for-role", int(models.RoleFrontDesk), "", "Your role must use two-factor login"},
+}
+
+// TestAdminPostDisableTwoFactor tests turning off two-factor login
+func TestAdminPostDisableTwoFactor(t *testing.T) {
+	defer func(level int) { app.TwoFactorAccessLevel = level }(app.TwoFactorAccessLevel)
+
+	for _, e := range adminPostDisableTwoFactorTests {
+		app.TwoFactorAccessLevel = e.level
+
+		postedData := url.Values{"password": {"password"}}
+		req, _ := http.NewRequest("POST", "/admin/two-factor/disable", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "user_id", 5)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostDisableTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+var adminResetTwoFactorTests = []struct {
+	name                 string
+	id                   string
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"reset", "5", http.StatusSeeOther, "/admin/users/5/show"},
+	{"reset-fails", "101", http.StatusInternalServerError, ""},
+}
+
+// TestAdminResetTwoFactor tests resetting the two-factor login of another user
+func TestAdminResetTwoFactor(t *testing.T) {
+	for _, e := range adminResetTwoFactorTests {
+		req, _ := http.NewRequest("POST", "/admin/reset-two-factor/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, e.id)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminResetTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+// TestAdminSessions tests the list of the logged in user's sessions
+func TestAdminSessions(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/sessions", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+	session.Put(ctx, "session_key", "current-key")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AdminSessions).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	html := rr.Body.String()
+	if !strings.Contains(html, "This session") || !strings.Contains(html, "/admin/revoke-session/2/do") {
+		t.Error("expected the other session to be offered for ending")
+	}
+	if strings.Contains(html, "/admin/revoke-session/1/do") {
+		t.Error("expected the current session not to be offered for ending")
+	}
+}
+
+var adminRevokeSessionTests = []struct {
+	name                 string
+	url                  string
+	id                   string
+	sessionID            string
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"own-session", "/admin/revoke-session/2/do", "2", "", http.StatusSeeOther, "/admin/sessions"},
+	{"own-session-ended", "/admin/revoke-session/50/do", "50", "", http.StatusSeeOther, "/admin/sessions"},
+	{"own-session-fails", "/admin/revoke-session/101/do", "101", "", http.StatusInternalServerError, ""},
+	{"own-other-sessions", "/admin/revoke-sessions/do", "", "", http.StatusSeeOther, "/admin/sessions"},
+	{"user-session", "/admin/users/2/revoke-session/1/do", "2", "1", http.StatusSeeOther, "/admin/users/2/show"},
+	{"user-session-ended", "/admin/users/2/revoke-session/50/do", "2", "50", http.StatusSeeOther, "/admin/users/2/show"},
+	{"user-session-fails", "/admin/users/2/revoke-session/101/do", "2", "101", http.StatusInternalServerError, ""},
+	{"user-sessions", "/admin/users/2/revoke-sessions/do", "2", "", http.StatusSeeOther, "/admin/users/2/show"},
+	{"user-sessions-fail", "/admin/users/101/revoke-sessions/do", "101", "", http.StatusInternalServerError, ""},
+}
+
+// TestAdminRevokeSessions tests ending sessions, of the logged in user and of others
+func TestAdminRevokeSessions(t *testing.T) {
+	for _, e := range adminRevokeSessionTests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("id", e.id)
+		chiCtx.URLParams.Add("session_id", e.sessionID)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+		session.Put(ctx, "session_key", "current-key")
+		rr := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		switch {
+		case strings.HasPrefix(e.url, "/admin/revoke-session/"):
+			handler = Repo.AdminRevokeSession
+		case e.url == "/admin/revoke-sessions/do":
+			handler = Repo.AdminRevokeOtherSessions
+		case strings.Contains(e.url, "/revoke-session/"):
+			handler = Repo.AdminRevokeUserSession
+		default:
+			handler = Repo.AdminRevokeUserSessions
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var adminResendMailTests = []struct {
+	name                 string
+	id                   string
+	expectedResponseCode int
+	expectedLocation     string
+	expectedWarning      string
+}{
+	{"resend", "3", http.StatusSeeOther, "/admin/mail-outbox/3/show", ""},
+	{"already-pending", "1", http.StatusSeeOther, "/admin/mail-outbox/1/show", "This message is already waiting to be sent"},
+	{"unknown", "50", http.StatusNotFound, "", ""},
+	{"resend-fails", "101", http.StatusInternalServerError, "", ""},
+}
+
+// TestAdminResendMail tests sending a message in the outbox again
+func TestAdminResendMail(t *testing.T) {
+	for _, e := range adminResendMailTests {
+		req, _ := http.NewRequest("POST", "/admin/resend-mail/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, e.id)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminResendMail).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if msg := session.GetString(ctx, "Warning"); msg != e.expectedWarning {
+			t.Errorf("failed %s: expected warning %q, but got %q", e.name, e.expectedWarning, msg)
+		}
+	}
+}
+
+// TestQueueMail tests that queued messages wake up the mail worker
+func TestQueueMail(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = req.WithContext(getCtx(req))
+
+	data := email.UserData{User: models.User{FirstName: "John", LastName: "Smith"}}
+	Repo.queueMail(req, "guest@here.ca", "Your password was changed", "password-changed.mail.tmpl", data)
+	select {
+	case <-app.MailQueued:
+	default:
+		t.Error("expected the mail worker to be woken up")
+	}
+
+	// a message that couldn't be put in the outbox can't be sent
+	Repo.queueMail(req, "outboxerror@here.ca", "Your password was changed", "password-changed.mail.tmpl", data)
+	select {
+	case <-app.MailQueued:
+		t.Error("expected the mail worker not to be woken up")
+	default:
+	}
+
+	// nor can one whose template doesn't exist
+	Repo.queueMail(req, "guest@here.ca", "Hello", "missing.mail.tmpl", data)
+	select {
+	case <-app.MailQueued:
+		t.Error("expected the mail worker not to be woken up")
+	default:
+	}
+}
+
+var adminUnlockLoginTests = []struct {
+	name                 string
+	id                   string
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"unlock", "2", http.StatusSeeOther, "/admin/login-lockouts"},
+	{"already-unlocked", "50", http.StatusSeeOther, "/admin/login-lockouts"},
+	{"unlock-fails", "101", http.StatusInternalServerError, ""},
+}
+
+// TestAdminUnlockLogin tests lifting a login lockout
+func TestAdminUnlockLogin(t *testing.T) {
+	for _, e := range adminUnlockLoginTests {
+		req, _ := http.NewRequest("POST", "/admin/unlock-login/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, e.id)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminUnlockLogin).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var adminPostShowReservationTests = []struct {
+	name                 string
+	url                  string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name: "valid-data-from-new",
+		url:  "/admin/reservations/new/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-new",
+		expectedHTML:         "",
+	},
+	{
+		name: "valid-data-from-all",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-all",
+		expectedHTML:         "",
+	},
+	{
+		name: "valid-data-from-cal",
+		url:  "/admin/reservations/cal/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"year":       {"2022"},
+			"month":      {"01"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-calendar?y=2022&m=01",
+		expectedHTML:         "",
+	},
+}
+
+// TestAdminPostShowReservation tests the AdminPostReservation handler
+func TestAdminPostShowReservation(t *testing.T) {
+	for _, e := range adminPostShowReservationTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest("POST", "/user/login", strings.NewReader(e.postedData.Encode()))
+		} else {
+			req, _ = http.NewRequest("POST", "/user/login", nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		// set the header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		// call the handler
+		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			// get the URL from test
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		// checking for expected values in HTML
+		if e.expectedHTML != "" {
+			// read the response body into a string
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var adminPostReservationCalendarTests = []struct {
+	name                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+	blocks               int
+	reservations         int
+}{
+	{
+		name: "cal",
+		postedData: url.Values{
+			"year":  {time.Now().Format("2006")},
+			"month": {time.Now().Format("01")},
+			fmt.Sprintf("add_block_1_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name:                 "cal-blocks",
+		postedData:           url.Values{},
+		expectedResponseCode: http.StatusSeeOther,
+		blocks:               1,
+	},
+	{
+		name:                 "cal-res",
+		postedData:           url.Values{},
+		expectedResponseCode: http.StatusSeeOther,
+		reservations:         1,
+	},
+}
+
+func TestPostReservationCalendar(t *testing.T) {
+	for _, e := range adminPostReservationCalendarTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
+		} else {
+			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		now := time.Now()
+		bm := make(map[string]int)
+		rm := make(map[string]int)
+
+		currentYear, currentMonth, _ := now.Date()
+		currentLocation := now.Location()
+
+		firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
+			rm[d.Format("2006-01-2")] = 0
+			bm[d.Format("2006-01-2")] = 0
+		}
+
+		if e.blocks > 0 {
+			bm[firstOfMonth.Format("2006-01-2")] = e.blocks
+		}
+
+		if e.reservations > 0 {
+			rm[lastOfMonth.Format("2006-01-2")] = e.reservations
+		}
+
+		session.Put(ctx, "block_map_1", bm)
+		session.Put(ctx, "reservation_map_1", rm)
+
+		// set the header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		// call the handler
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalender)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+	}
+}
+
+// refundGateway records the refunds made through it
+type refundGateway struct {
+	payments.Gateway
+	refunded int
+}
+
+func (g *refundGateway) Refund(ctx context.Context, transactionID string, amount int) error {
+	g.refunded += amount
+	return nil
+}
+
+// withRefundGateway runs f with a refundGateway taking the payments, and returns what it refunded
+func withRefundGateway(f func()) int {
+	gateway := &refundGateway{Gateway: app.Payments}
+	app.Payments = gateway
+	defer func() { app.Payments = gateway.Gateway }()
+
+	f()
+	return gateway.refunded
+}
+
+var adminTransitionReservationTests = []struct {
+	name                 string
+	id                   string
+	status               string
+	src                  string
+	queryParams          string
+	expectedResponseCode int
+	expectedLocation     string
+	expectedRefund       int
+}{
+	{"confirm", "1", "confirmed", "new", "", http.StatusSeeOther, "/admin/reservations-new", 0},
+	{"check-in-back-to-cal", "1", "checked_in", "cal", "?y=2021&m=12", http.StatusSeeOther, "/admin/reservations-calendar?y=2021&m=12", 0},
+	{"cancel", "1", "cancelled", "all", "", http.StatusSeeOther, "/admin/reservations-all", 20000},
+	{"not-allowed", "2", "checked_out", "all", "", http.StatusSeeOther, "/admin/reservations-all", 0},
+	{"cancel-not-allowed", "2", "cancelled", "all", "", http.StatusSeeOther, "/admin/reservations-all", 0},
+	{"unknown-status", "1", "processed", "all", "", http.StatusBadRequest, "", 0},
+	{"database-error", "101", "confirmed", "all", "", http.StatusInternalServerError, "", 0},
+}
+
+func TestAdminTransitionReservation(t *testing.T) {
+	for _, e := range adminTransitionReservationTests {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/reservation-status/%s/%s/%s/do%s", e.src, e.id, e.status, e.queryParams), nil)
+		ctx := getCtx(req)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("src", e.src)
+		chiCtx.URLParams.Add("id", e.id)
+		chiCtx.URLParams.Add("status", e.status)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminTransitionReservation)
+		refunded := withRefundGateway(func() { handler.ServeHTTP(rr, req) })
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if refunded != e.expectedRefund {
+			t.Errorf("failed %s: expected %d refunded, but got %d", e.name, e.expectedRefund, refunded)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+// TestAdminTransitionReservation_Mail tests that the guest is emailed when the staff cancel
+func TestAdminTransitionReservation_Mail(t *testing.T) {
+	for status, expectMail := range map[string]bool{"confirmed": false, "cancelled": true} {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/reservation-status/all/1/%s/do", status), nil)
+		ctx := getCtx(req)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("src", "all")
+		chiCtx.URLParams.Add("id", "1")
+		chiCtx.URLParams.Add("status", status)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+		req = req.WithContext(ctx)
+
+		// a message queued by an earlier test
+		select {
+		case <-app.MailQueued:
+		default:
+		}
+
+		withRefundGateway(func() {
+			http.HandlerFunc(Repo.AdminTransitionReservation).ServeHTTP(httptest.NewRecorder(), req)
+		})
+
+		select {
+		case <-app.MailQueued:
+			if !expectMail {
+				t.Errorf("%s: expected no email", status)
+			}
+		default:
+			if expectMail {
+				t.Errorf("%s: expected an email to the guest", status)
+			}
+		}
+	}
+}
+
+var adminDeleteReservationTests = []struct {
+	name                 string
+	id                   string
+	queryParams          string
+	expectedResponseCode int
+	expectedLocation     string
+	expectedRefund       int
+}{
+	{
+		name:                 "delete-reservation",
+		id:                   "1",
+		queryParams:          "",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "",
+		expectedRefund:       20000,
+	},
+	{
+		name:                 "delete-reservation-back-to-cal",
+		id:                   "1",
+		queryParams:          "?y=2021&m=12",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "",
+		expectedRefund:       20000,
+	},
+	{
+		name:                 "delete-cancelled-reservation",
+		id:                   "5",
+		queryParams:          "",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "",
+	},
+	{
+		name:                 "delete-reservation-fails",
+		id:                   "101",
+		queryParams:          "",
+		expectedResponseCode: http.StatusInternalServerError,
+		expectedLocation:     "",
+	},
+}
+
+func TestAdminDeleteReservation(t *testing.T) {
+	for _, e := range adminDeleteReservationTests {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/delete-reservation/cal/%s/do%s", e.id, e.queryParams), nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContextTest(ctx, e.id, "src")
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		refunded := withRefundGateway(func() { handler.ServeHTTP(rr, req) })
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if refunded != e.expectedRefund {
+			t.Errorf("failed %s: expected %d refunded, but got %d", e.name, e.expectedRefund, refunded)
+		}
+	}
+}
+
+var adminTrashActionTests = []struct {
+	name                 string
+	url                  string
+	id                   string
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"restore", "/admin/restore-reservation/1/do", "1", http.StatusSeeOther, "/admin/reservations-trash"},
+	{"restore-room-taken", "/admin/restore-reservation/3/do", "3", http.StatusSeeOther, "/admin/reservations-trash"},
+	{"restore-fails", "/admin/restore-reservation/101/do", "101", http.StatusInternalServerError, ""},
+	{"purge", "/admin/purge-reservation/1/do", "1", http.StatusSeeOther, "/admin/reservations-trash"},
+	{"purge-with-payments", "/admin/purge-reservation/7/do", "7", http.StatusSeeOther, "/admin/reservations-trash"},
+	{"purge-fails", "/admin/purge-reservation/101/do", "101", http.StatusInternalServerError, ""},
+}
+
+// TestAdminTrashActions tests restoring and purging deleted reservations
+func TestAdminTrashActions(t *testing.T) {
+	for _, e := range adminTrashActionTests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, e.id)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		if strings.HasPrefix(e.url, "/admin/restore-reservation") {
+			handler = Repo.AdminRestoreReservation
+		} else {
+			handler = Repo.AdminPurgeReservation
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestForbidden(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/audit", nil)
+	ctx := getCtx(req)
+	ctx = helpers.WithUser(ctx, models.User{ID: 2, FirstName: "Jane", AccessLevel: int(models.RoleFrontDesk)})
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Forbidden)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Sorry Jane, your role") || !strings.Contains(rr.Body.String(), "(Front Desk)") {
+		t.Error("expected the forbidden page to name the user and their role")
+	}
+	if !strings.Contains(rr.Body.String(), `href="/admin/dashboard" class="btn`) {
+		t.Error("expected staff to be sent back to the dashboard")
+	}
+}
+
+// validUser returns the posted data of the user form, changed by the key value pairs in kv
+func validUser(kv ...string) url.Values {
+	v := url.Values{
+		"first_name": {"Sam"}, "last_name": {"Porter"}, "email": {"sam@here.ca"}, "access_level": {"2"},
+		"password": {"secret123"}, "password_confirm": {"secret123"},
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		v.Set(kv[i], kv[i+1])
+	}
+	return v
+}
+
+var adminPostShowUserTests = []struct {
+	name                 string
+	id                   string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{"invite", "0", validUser(), http.StatusSeeOther, "/admin/users", ""},
+	{"invite-email-taken", "0", validUser("email", " JANE@here.ca "), http.StatusOK, "", "This value is already in use"},
+	{"invite-short-password", "0", validUser("password", "short", "password_confirm", "short"), http.StatusOK, "", "at least 8 characters"},
+	{"invite-password-mismatch", "0", validUser("password_confirm", "secret124"), http.StatusOK, "", "The values don&#39;t match"},
+	{"invite-bad-role", "0", validUser("access_level", "9"), http.StatusOK, "", "must be between 1 and 4"},
+	{"invite-fails", "0", validUser("last_name", "Fail"), http.StatusInternalServerError, "", ""},
+	{"email-lookup-fails", "0", validUser("email", "dberror@here.ca"), http.StatusInternalServerError, "", ""},
+	{"update-keeps-own-email", "2", validUser("email", "jane@here.ca", "password", ""), http.StatusSeeOther, "/admin/users", ""},
+	{"demote-last-owner", "1", validUser("email", "me@here.ca", "access_level", "3"), http.StatusOK, "", "This is the last owner"},
+	{"unknown-user", "99", validUser(), http.StatusSeeOther, "/admin/users", ""},
+}
+
+// TestAdminPostShowUser tests inviting and editing users
+func TestAdminPostShowUser(t *testing.T) {
+	for _, e := range adminPostShowUserTests {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/users/%s", e.id), strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, e.id)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostShowUser).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminPostUserPasswordTests = []struct {
+	name                 string
+	id                   string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"new-password", "2", url.Values{"password": {"secret123"}, "password_confirm": {"secret123"}}, http.StatusSeeOther, "/admin/users/2/show"},
+	{"mismatch", "2", url.Values{"password": {"secret123"}, "password_confirm": {"secret"}}, http.StatusOK, ""},
+	{"unknown-user", "99", url.Values{"password": {"secret123"}, "password_confirm": {"secret123"}}, http.StatusSeeOther, "/admin/users"},
+}
+
+// TestAdminPostUserPassword tests setting a new password for a user
+func TestAdminPostUserPassword(t *testing.T) {
+	for _, e := range adminPostUserPasswordTests {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/users/%s/password", e.id), strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, e.id)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostUserPassword).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var adminUserActionTests = []struct {
 	name                 string
-	queryParams          string
+	url                  string
+	id                   string
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"enable", "/admin/enable-user/3/do", "3", http.StatusSeeOther, "/admin/users"},
+	{"enable-fails", "/admin/enable-user/101/do", "101", http.StatusInternalServerError, ""},
+	{"disable", "/admin/disable-user/2/do", "2", http.StatusSeeOther, "/admin/users"},
+	{"disable-last-owner", "/admin/disable-user/1/do", "1", http.StatusSeeOther, "/admin/users"},
+	{"disable-fails", "/admin/disable-user/101/do", "101", http.StatusInternalServerError, ""},
+}
+
+// TestAdminUserActions tests enabling and disabling users
+func TestAdminUserActions(t *testing.T) {
+	for _, e := range adminUserActionTests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, e.id)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		if strings.HasPrefix(e.url, "/admin/enable-user") {
+			handler = Repo.AdminEnableUser
+		} else {
+			handler = Repo.AdminDisableUser
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var postForgotPasswordTests = []struct {
+	name                 string
+	email                string
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{"known-user", "me@here.ca", http.StatusSeeOther, "/user/login", ""},
+	{"unknown-user", "nobody@here.ca", http.StatusSeeOther, "/user/login", ""},
+	{"disabled-user", "old@here.ca", http.StatusSeeOther, "/user/login", ""},
+	{"invalid-email", "me-at-here", http.StatusOK, "", "Invalid email address"},
+	{"database-error", "dberror@here.ca", http.StatusInternalServerError, "", ""},
+}
+
+func TestPostForgotPassword(t *testing.T) {
+	for _, e := range postForgotPasswordTests {
+		postedData := url.Values{"email": {e.email}}
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostForgotPassword).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+			// every address gets the same answer
+			if flash := session.GetString(ctx, "flash"); flash != forgotPasswordMessage {
+				t.Errorf("failed %s: expected the usual message, but got %q", e.name, flash)
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var resetPasswordTests = []struct {
+	name                 string
+	method               string
+	token                string
+	password             string
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"show-form", "GET", "valid-token", "", http.StatusOK, ""},
+	{"show-used", "GET", "used-token", "", http.StatusSeeOther, "/user/forgot-password"},
+	{"show-expired", "GET", "expired-token", "", http.StatusSeeOther, "/user/forgot-password"},
+	{"show-unknown", "GET", "made-up", "", http.StatusSeeOther, "/user/forgot-password"},
+	{"show-database-error", "GET", "dberror-token", "", http.StatusInternalServerError, ""},
+	{"reset", "POST", "valid-token", "secret123", http.StatusSeeOther, "/user/login"},
+	{"reset-short-password", "POST", "valid-token", "short", http.StatusOK, ""},
+	{"reset-used", "POST", "used-token", "secret123", http.StatusSeeOther, "/user/forgot-password"},
+	{"reset-database-error", "POST", "dberror-token", "secret123", http.StatusInternalServerError, ""},
+}
+
+func TestResetPassword(t *testing.T) {
+	for _, e := range resetPasswordTests {
+		var req *http.Request
+		var handler http.HandlerFunc
+		if e.method == "GET" {
+			req, _ = http.NewRequest("GET", "/user/reset-password?token="+e.token, nil)
+			handler = Repo.ResetPassword
+		} else {
+			postedData := url.Values{"token": {e.token}, "password": {e.password}, "password_confirm": {e.password}}
+			req, _ = http.NewRequest("POST", "/user/reset-password", strings.NewReader(postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			handler = Repo.PostResetPassword
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if rr.Code == http.StatusOK && !strings.Contains(rr.Body.String(), `name="token" value="`+e.token+`"`) {
+			t.Errorf("failed %s: expected the token to be kept in the form", e.name)
+		}
+	}
+}
+
+var adminAuditTests = []struct {
+	name                 string
+	query                string
+	expectedResponseCode int
+	expectedHTML         string
+}{
+	{"everything", "", http.StatusOK, `&#34;555-0199&#34;`},
+	{"filtered", "?user=1&entity=reservation&from=2050-01-01&to=2050-01-31", http.StatusOK, `<option value="reservation" selected>`},
+	{"bad-user", "?user=me", http.StatusBadRequest, ""},
+	{"unknown-entity", "?entity=payment", http.StatusBadRequest, ""},
+	{"bad-from", "?from=yesterday", http.StatusBadRequest, ""},
+	{"bad-to", "?to=2050-13-01", http.StatusBadRequest, ""},
+	{"database-error", "?user=101", http.StatusInternalServerError, ""},
+}
+
+func TestAdminAudit(t *testing.T) {
+	for _, e := range adminAuditTests {
+		req, _ := http.NewRequest("GET", "/admin/audit"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminAudit)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminPostShowRoomTests = []struct {
+	name                 string
+	id                   string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name:                 "new-room",
+		id:                   "0",
+		postedData:           url.Values{"room_name": {"Colonel's Cabin"}, "capacity": {"2"}, "base_rate": {"120"}},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms",
+	},
+	{
+		name:                 "update-room",
+		id:                   "1",
+		postedData:           url.Values{"room_name": {"General's Quarters"}, "capacity": {"2"}, "base_rate": {"120"}},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms",
+	},
+	{
+		name:                 "invalid-room-name",
+		id:                   "1",
+		postedData:           url.Values{"room_name": {"x"}},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         `action="/admin/rooms/1"`,
+	},
+	{
+		name:                 "invalid-base-rate",
+		id:                   "1",
+		postedData:           url.Values{"room_name": {"General's Quarters"}, "capacity": {"2"}, "base_rate": {"lots"}},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         `action="/admin/rooms/1"`,
+	},
+	{
+		name:                 "invalid-capacity",
+		id:                   "1",
+		postedData:           url.Values{"room_name": {"General's Quarters"}, "capacity": {"0"}, "base_rate": {"120"}},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         `action="/admin/rooms/1"`,
+	},
+	{
+		name:                 "invalid-slug",
+		id:                   "0",
+		postedData:           url.Values{"room_name": {"Colonel's Cabin"}, "slug": {"Colonel Cabin"}},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         `action="/admin/rooms/0"`,
+	},
+	{
+		name:                 "duplicate-slug",
+		id:                   "0",
+		postedData:           url.Values{"room_name": {"Colonel's Cabin"}, "slug": {"generals-quarters"}},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         `action="/admin/rooms/0"`,
+	},
+	{
+		name:                 "insert-fails",
+		id:                   "0",
+		postedData:           url.Values{"room_name": {"fail"}, "capacity": {"2"}, "base_rate": {"120"}},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+	{
+		name:                 "unknown-room",
+		id:                   "3",
+		postedData:           url.Values{"room_name": {"Colonel's Cabin"}, "capacity": {"2"}, "base_rate": {"120"}},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms",
+	},
+}
+
+// TestAdminPostShowRoom tests the AdminPostShowRoom handler
+func TestAdminPostShowRoom(t *testing.T) {
+	for _, e := range adminPostShowRoomTests {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/rooms/%s", e.id), strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, e.id)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostShowRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var adminRoomActionTests = []struct {
+	name                 string
+	url                  string
+	id                   string
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"activate-room", "/admin/activate-room/1/do", "1", http.StatusSeeOther, "/admin/rooms"},
+	{"activate-room-fails", "/admin/activate-room/3/do", "3", http.StatusInternalServerError, ""},
+	{"deactivate-room", "/admin/deactivate-room/1/do", "1", http.StatusSeeOther, "/admin/rooms"},
+	{"deactivate-room-with-reservations", "/admin/deactivate-room/2/do", "2", http.StatusSeeOther, "/admin/rooms"},
+	{"delete-room", "/admin/delete-room/1/do", "1", http.StatusSeeOther, "/admin/rooms"},
+	{"delete-room-with-reservations", "/admin/delete-room/2/do", "2", http.StatusSeeOther, "/admin/rooms/2/show"},
+	{"delete-room-fails", "/admin/delete-room/3/do", "3", http.StatusInternalServerError, ""},
+}
+
+// TestAdminRoomActions tests activating, deactivating and deleting rooms
+func TestAdminRoomActions(t *testing.T) {
+	for _, e := range adminRoomActionTests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, e.id)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		switch {
+		case strings.HasPrefix(e.url, "/admin/activate-room"):
+			handler = Repo.AdminActivateRoom
+		case strings.HasPrefix(e.url, "/admin/deactivate-room"):
+			handler = Repo.AdminDeactivateRoom
+		default:
+			handler = Repo.AdminDeleteRoom
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var adminSeasonalRateTests = []struct {
+	name                 string
+	roomID               string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{
+		name:   "valid-season",
+		roomID: "1",
+		postedData: url.Values{
+			"name":       {"Summer"},
+			"start_date": {"2050-06-01"},
+			"end_date":   {"2050-08-31"},
+			"rate":       {"150.00"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms/1/show",
+	},
+	{
+		name:   "end-before-start",
+		roomID: "1",
+		postedData: url.Values{
+			"name":       {"Summer"},
+			"start_date": {"2050-08-31"},
+			"end_date":   {"2050-06-01"},
+			"rate":       {"150.00"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms/1/show",
+	},
+	{
+		name:   "insert-fails",
+		roomID: "1",
+		postedData: url.Values{
+			"name":       {"fail"},
+			"start_date": {"2050-06-01"},
+			"end_date":   {"2050-08-31"},
+			"rate":       {"150.00"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostSeasonalRate tests adding seasonal rates to a room
+func TestAdminPostSeasonalRate(t *testing.T) {
+	for _, e := range adminSeasonalRateTests {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/rooms/%s/seasonal-rates", e.roomID), strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, e.roomID)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostSeasonalRate).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+// TestAdminDeleteSeasonalRate tests removing seasonal rates from a room
+func TestAdminDeleteSeasonalRate(t *testing.T) {
+	for seasonID, expectedCode := range map[string]int{"1": http.StatusSeeOther, "3": http.StatusInternalServerError} {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/delete-seasonal-rate/"+seasonID+"/do", nil)
+		ctx := getCtx(req)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("id", "1")
+		chiCtx.URLParams.Add("season_id", seasonID)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminDeleteSeasonalRate).ServeHTTP(rr, req)
+
+		if rr.Code != expectedCode {
+			t.Errorf("delete seasonal rate %s: expected code %d, but got %d", seasonID, expectedCode, rr.Code)
+		}
+	}
+}
+
+var postReservationPromoCodeTests = []struct {
+	name                 string
+	promoCode            string
 	expectedResponseCode int
 	expectedLocation     string
+	expectedHTML         string
 }{
-	{
-		name:                 "process-reservation",
-		queryParams:          "",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
-	{
-		name:                 "process-reservation-back-to-cal",
-		queryParams:          "?y=2021&m=12",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
+	{"valid-code", "save10", http.StatusSeeOther, "/checkout", ""},
+	{"unknown-code", "NOPE", http.StatusOK, "", "This promo code doesn&#39;t exist"},
+	{"expired-code", "EXPIRED", http.StatusOK, "", "This promo code is no longer valid"},
+	{"invalid-code", "SAVE 10", http.StatusOK, "", "Only letters and numbers are allowed"},
+	{"database-error", "DBERROR", http.StatusInternalServerError, "", ""},
 }
 
-func TestAdminProcessReservation(t *testing.T) {
-	for _, e := range adminProcessReservationTests {
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
+// TestPostReservationPromoCode tests using promo codes on the make-reservation form
+func TestPostReservationPromoCode(t *testing.T) {
+	for _, e := range postReservationPromoCodeTests {
+		postedData := url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"adults":     {"2"},
+			"promo_code": {e.promoCode},
+		}
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+		session.Put(ctx, "reservation", models.Reservation{
+			StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:    time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			RoomID:     1,
+			TotalPrice: 20000,
+			Room:       models.Room{RoomName: "General's Quarters", Capacity: 2},
+		})
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.AdminProcessReservation)
-		handler.ServeHTTP(rr, req)
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in the page", e.name, e.expectedHTML)
+		}
+
+		if e.name == "valid-code" {
+			res, _ := session.Get(ctx, "reservation").(models.Reservation)
+			if res.Discount != 2000 || res.TotalPrice != 18000 {
+				t.Errorf("failed %s: expected discount 2000 and total 18000, but got %d and %d", e.name, res.Discount, res.TotalPrice)
+			}
+		}
+	}
+}
+
+// TestPostReservationPromoCode_Back tests going back from the payment page, which quotes
+// the stay again, and then taking the promo code off
+func TestPostReservationPromoCode_Back(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "reservation", models.Reservation{
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+	})
 
+	post := func(promoCode string) models.Reservation {
+		postedData := url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"adults":     {"2"},
+			"promo_code": {promoCode},
+		}
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
 		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("posting promo code %q: expected code %d, but got %d", promoCode, http.StatusSeeOther, rr.Code)
+		}
+		res, _ := session.Get(ctx, "reservation").(models.Reservation)
+		return res
+	}
+
+	back := func() models.Reservation {
+		req, _ := http.NewRequest("GET", "/make-reservation", nil)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("going back: expected code %d, but got %d", http.StatusOK, rr.Code)
+		}
+		res, _ := session.Get(ctx, "reservation").(models.Reservation)
+		return res
+	}
+
+	back()
+	if res := post("SAVE10"); res.Discount != 2000 || res.TotalPrice != 18000 {
+		t.Errorf("expected discount 2000 and total 18000 with the promo code, but got %d and %d", res.Discount, res.TotalPrice)
+	}
+	if res := back(); res.Discount != 0 || res.PromoCodeID != 0 || res.TotalPrice != 20000 {
+		t.Errorf("expected no discount and total 20000 after going back, but got %d, promo code %d and %d", res.Discount, res.PromoCodeID, res.TotalPrice)
+	}
+	if res := post(""); res.Discount != 0 || res.TotalPrice != 20000 {
+		t.Errorf("expected no discount and total 20000 without the promo code, but got %d and %d", res.Discount, res.TotalPrice)
+	}
+	back()
+	if res := post("SAVE10"); res.Discount != 2000 || res.TotalPrice != 18000 {
+		t.Errorf("expected discount 2000 and total 18000 with the promo code again, but got %d and %d", res.Discount, res.TotalPrice)
+	}
+}
+
+// TestRepository_Checkout tests the payment page
+func TestRepository_Checkout(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/checkout", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.Checkout).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("Checkout handler returned wrong response code for no reservation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+
+	req, _ = http.NewRequest("GET", "/checkout", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	session.Put(ctx, "reservation", models.Reservation{
+		StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:    time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:     1,
+		FirstName:  "John",
+		Email:      "john@smith.com",
+		TotalPrice: 20000,
+		Room:       models.Room{RoomName: "General's Quarters", Capacity: 2},
+	})
+
+	http.HandlerFunc(Repo.Checkout).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Checkout handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+var validCard = url.Values{
+	"card_name":   {"John Smith"},
+	"card_number": {"4242 4242 4242 4242"},
+	"card_expiry": {"12/49"},
+	"card_cvc":    {"123"},
+}
+
+// withCard returns a copy of validCard with one field changed
+func withCard(field, value string) url.Values {
+	card := url.Values{}
+	for k, v := range validCard {
+		card[k] = v
+	}
+	card.Set(field, value)
+	return card
+}
+
+var postCheckoutTests = []struct {
+	name                 string
+	roomID               int
+	adults               int
+	promoCodeID          int
+	totalPrice           int
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{"paid", 1, 2, 0, 20000, validCard, http.StatusSeeOther, "/reservation-summary", ""},
+	{"nothing-to-pay", 1, 2, 0, 0, url.Values{}, http.StatusSeeOther, "/reservation-summary", ""},
+	{"missing-card", 1, 2, 0, 20000, url.Values{}, http.StatusOK, "", "This field cannot be blank"},
+	{"invalid-card-number", 1, 2, 0, 20000, withCard("card_number", "1234"), http.StatusOK, "", "Invalid card number"},
+	{"invalid-expiry", 1, 2, 0, 20000, withCard("card_expiry", "13/49"), http.StatusOK, "", "Please enter the expiry date as MM/YY"},
+	{"declined", 1, 2, 0, 20000, withCard("card_number", payments.FakeCardDeclined), http.StatusOK, "", "Your card was declined"},
+	{"expired-card", 1, 2, 0, 20000, withCard("card_expiry", "01/20"), http.StatusOK, "", "Your card has expired"},
+	{"failed-to-insert-reservation", 23, 2, 0, 0, url.Values{}, http.StatusTemporaryRedirect, "/", ""},
+	{"failed-to-insert-restriction", 2, 2, 0, 0, url.Values{}, http.StatusTemporaryRedirect, "/", ""},
+	{"room-booked-by-someone-else", 3, 2, 0, 20000, validCard, http.StatusSeeOther, "/search-availability", ""},
+	{"room-too-small", 1, 5, 0, 20000, validCard, http.StatusSeeOther, "/make-reservation", ""},
+	{"promo-code-used-up", 1, 2, 3, 18000, validCard, http.StatusSeeOther, "/make-reservation", ""},
+}
+
+// TestRepository_PostCheckout tests paying for and booking the reservation in the session
+func TestRepository_PostCheckout(t *testing.T) {
+	for _, e := range postCheckoutTests {
+		req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		session.Put(ctx, "reservation", models.Reservation{
+			StartDate:   time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:     time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			RoomID:      e.roomID,
+			FirstName:   "John",
+			LastName:    "Smith",
+			Email:       "john@smith.com",
+			Adults:      e.adults,
+			PromoCodeID: e.promoCodeID,
+			TotalPrice:  e.totalPrice,
+			Room:        models.Room{RoomName: "General's Quarters", Capacity: 6},
+		})
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostCheckout).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in the page", e.name, e.expectedHTML)
+		}
+	}
+
+	// no reservation in the session
+	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(validCard.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.PostCheckout).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("PostCheckout handler returned wrong response code for no reservation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
 }
 
-var adminDeleteReservationTests = []struct {
+var adminPostShowPromoCodeTests = []struct {
 	name                 string
-	queryParams          string
+	id                   string
+	postedData           url.Values
 	expectedResponseCode int
 	expectedLocation     string
+	expectedHTML         string
 }{
 	{
-		name:                 "delete-reservation",
-		queryParams:          "",
+		name: "new-percent-code",
+		id:   "0",
+		postedData: url.Values{
+			"code": {"spring"}, "discount_type": {"percent"}, "amount": {"15"},
+			"valid_from": {"2050-03-01"}, "valid_to": {"2050-05-31"}, "room_ids": {"1", "2"}, "active": {"1"},
+		},
 		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
+		expectedLocation:     "/admin/promo-codes",
 	},
 	{
-		name:                 "delete-reservation-back-to-cal",
-		queryParams:          "?y=2021&m=12",
+		name: "update-fixed-code",
+		id:   "1",
+		postedData: url.Values{
+			"code": {"SAVE10"}, "discount_type": {"fixed"}, "amount": {"25.00"},
+			"valid_from": {"2050-03-01"}, "valid_to": {"2050-05-31"},
+		},
 		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
+		expectedLocation:     "/admin/promo-codes",
+	},
+	{
+		name: "duplicate-code",
+		id:   "0",
+		postedData: url.Values{
+			"code": {"SAVE10"}, "discount_type": {"percent"}, "amount": {"10"},
+			"valid_from": {"2050-03-01"}, "valid_to": {"2050-05-31"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "This code is already used",
+	},
+	{
+		name: "percent-too-big",
+		id:   "0",
+		postedData: url.Values{
+			"code": {"HALF"}, "discount_type": {"percent"}, "amount": {"150"},
+			"valid_from": {"2050-03-01"}, "valid_to": {"2050-05-31"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         `action="/admin/promo-codes/0"`,
+	},
+	{
+		name: "ends-before-it-starts",
+		id:   "0",
+		postedData: url.Values{
+			"code": {"HALF"}, "discount_type": {"percent"}, "amount": {"50"},
+			"valid_from": {"2050-05-31"}, "valid_to": {"2050-03-01"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         `action="/admin/promo-codes/0"`,
+	},
+	{
+		name: "insert-fails",
+		id:   "0",
+		postedData: url.Values{
+			"code": {"fail"}, "discount_type": {"percent"}, "amount": {"10"},
+			"valid_from": {"2050-03-01"}, "valid_to": {"2050-05-31"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+	{
+		name:                 "unknown-promo-code",
+		id:                   "3",
+		postedData:           url.Values{"code": {"SAVE10"}},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/promo-codes",
 	},
 }
 
-func TestAdminDeleteReservation(t *testing.T) {
-	for _, e := range adminDeleteReservationTests {
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
+// TestAdminPostShowPromoCode tests adding and editing promo codes
+func TestAdminPostShowPromoCode(t *testing.T) {
+	for _, e := range adminPostShowPromoCodeTests {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/promo-codes/%s", e.id), strings.NewReader(e.postedData.Encode()))
 		ctx := getCtx(req)
-		ctx = addIdToChiContextTest(ctx, "1", "src")
+		ctx = addIdToChiContext(ctx, e.id)
 		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostShowPromoCode).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in the page", e.name, e.expectedHTML)
+		}
+	}
+}
 
+var adminDeletePromoCodeTests = []struct {
+	name                 string
+	id                   string
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"delete-unused-code", "1", http.StatusSeeOther, "/admin/promo-codes"},
+	{"delete-used-code", "2", http.StatusSeeOther, "/admin/promo-codes/2/show"},
+	{"delete-unknown-code", "3", http.StatusInternalServerError, ""},
+}
+
+// TestAdminDeletePromoCode tests deleting promo codes
+func TestAdminDeletePromoCode(t *testing.T) {
+	for _, e := range adminDeletePromoCodeTests {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/delete-promo-code/%s/do", e.id), nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, e.id)
+		req = req.WithContext(ctx)
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
-		handler.ServeHTTP(rr, req)
+		http.HandlerFunc(Repo.AdminDeletePromoCode).ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
+		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
 	}
 }
 
@@ -958,3 +2616,137 @@ func addIdToChiContextTest(parentCtx context.Context, id, src string) context.Co
 	chiCtx.URLParams.Add("src", src)
 	return context.WithValue(parentCtx, chi.RouteCtxKey, chiCtx)
 }
+
+var cancelReservationTests = []struct {
+	name                 string
+	code                 string
+	tokenFor             string
+	expires              time.Time
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{"valid", "GUEST234", "cancel:GUEST234", time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), http.StatusOK, "", `value="Cancel Reservation"`},
+	{"already-cancelled", "CANC5555", "cancel:CANC5555", time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), http.StatusOK, "", "This reservation was cancelled"},
+	{"arrival-today", "TDAY6666", "cancel:TDAY6666", time.Now().Add(24 * time.Hour), http.StatusOK, "", "can no longer be cancelled online"},
+	{"token-for-other-reservation", "HHHH2222", "cancel:GUEST234", time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), http.StatusSeeOther, "/", ""},
+	{"expired-token", "GUEST234", "cancel:GUEST234", time.Now().Add(-time.Hour), http.StatusSeeOther, "/contact", ""},
+	{"unknown-code", "HHHH2222", "cancel:HHHH2222", time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), http.StatusNotFound, "", ""},
+}
+
+// TestCancelReservation tests the page a guest's cancellation link leads to
+func TestCancelReservation(t *testing.T) {
+	for _, e := range cancelReservationTests {
+		token := app.Signer.Sign(e.tokenFor, e.expires)
+		req, _ := http.NewRequest("GET", "/reservation/"+e.code+"/cancel?token="+url.QueryEscape(token), nil)
+		ctx := getCtx(req)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("code", e.code)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.CancelReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var postCancelReservationTests = []struct {
+	name                 string
+	code                 string
+	tokenFor             string
+	expires              time.Time
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"valid", "GUEST234", "cancel:GUEST234", time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), http.StatusSeeOther, "/"},
+	{"already-cancelled", "CANC5555", "cancel:CANC5555", time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), http.StatusSeeOther, "/"},
+	{"cancelled-in-the-meantime", "RACE7777", "cancel:RACE7777", time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), http.StatusSeeOther, "/"},
+	{"arrival-today", "TDAY6666", "cancel:TDAY6666", time.Now().Add(24 * time.Hour), http.StatusSeeOther, "/contact"},
+	{"token-for-other-reservation", "HHHH2222", "cancel:GUEST234", time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), http.StatusSeeOther, "/"},
+	{"expired-token", "GUEST234", "cancel:GUEST234", time.Now().Add(-time.Hour), http.StatusSeeOther, "/contact"},
+}
+
+// TestPostCancelReservation tests a guest cancelling through their cancellation link
+func TestPostCancelReservation(t *testing.T) {
+	for _, e := range postCancelReservationTests {
+		postedData := url.Values{
+			"token": {app.Signer.Sign(e.tokenFor, e.expires)},
+		}
+		req, _ := http.NewRequest("POST", "/reservation/"+e.code+"/cancel", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("code", e.code)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostCancelReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+	}
+}
+
+var postLookupReservationTests = []struct {
+	name                 string
+	code                 string
+	email                string
+	expectedResponseCode int
+	expectedHTML         string
+}{
+	{"found", "GUEST234", "john@smith.com", http.StatusOK, "/reservation/GUEST234/cancel?token="},
+	{"typed-loosely", "gue st-234", "JOHN@smith.com", http.StatusOK, "General&#39;s Quarters"},
+	{"missing-email", "GUEST234", "", http.StatusOK, "This field cannot be blank"},
+	{"can-no-longer-cancel", "TDAY6666", "john@smith.com", http.StatusOK, "Booking Code:"},
+	{"wrong-email", "GUEST234", "jane@smith.com", http.StatusOK, "We couldn&#39;t find a reservation"},
+	{"unknown-code", "HHHH2222", "john@smith.com", http.StatusOK, "We couldn&#39;t find a reservation"},
+	{"short-code", "GUEST", "john@smith.com", http.StatusOK, "Booking codes have 8 letters and numbers"},
+	{"database-error", "DBERR999", "john@smith.com", http.StatusInternalServerError, ""},
+}
+
+// TestPostLookupReservation tests guests finding their booking by code and email
+func TestPostLookupReservation(t *testing.T) {
+	for _, e := range postLookupReservationTests {
+		postedData := url.Values{
+			"code":  {e.code},
+			"email": {e.email},
+		}
+		req, _ := http.NewRequest("POST", "/reservation/lookup", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostLookupReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in the page", e.name, e.expectedHTML)
+		}
+	}
+}
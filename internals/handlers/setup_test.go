@@ -13,9 +13,15 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/byt3er/bookings/internals/config"
+	"github.com/byt3er/bookings/internals/email"
 	"github.com/byt3er/bookings/internals/helpers"
+	"github.com/byt3er/bookings/internals/mailer"
 	"github.com/byt3er/bookings/internals/models"
+	"github.com/byt3er/bookings/internals/payments"
+	"github.com/byt3er/bookings/internals/pricing"
 	"github.com/byt3er/bookings/internals/render"
+	"github.com/byt3er/bookings/internals/signer"
+	"github.com/byt3er/bookings/internals/throttle"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/justinas/nosurf"
@@ -26,11 +32,13 @@ var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
 
 var functions = template.FuncMap{
-	"humanDate":  render.HumanDate,
-	"formatDate": render.FormatDate,
-	"iterate":    render.Iterate,
-	"add":        render.Add,
-	"adds":       render.AddS,
+	"humanDate":   render.HumanDate,
+	"formatDate":  render.FormatDate,
+	"iterate":     render.Iterate,
+	"add":         render.Add,
+	"adds":        render.AddS,
+	"formatMoney": pricing.FormatMoney,
+	"toJSON":      render.ToJSON,
 }
 
 func TestMain(m *testing.M) {
@@ -61,12 +69,19 @@ func TestMain(m *testing.M) {
 
 	app.Session = session
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
+	app.Payments = payments.NewFakeGateway()
+	app.Signer = signer.New([]byte("test"))
+	app.BaseURL = "http://localhost:8080"
+	app.Cancellation = pricing.CancellationPolicy{CutoffDays: 7, LateFeePercent: 50}
+	app.Property = models.Property{Name: "Fort Smythe", CheckIn: 15 * time.Hour, CheckOut: 11 * time.Hour, Location: time.UTC}
+	app.TrashRetention = 30 * 24 * time.Hour
+	app.LoginAccount = throttle.Policy{MaxFailures: 5, Window: 15 * time.Minute, Lockout: time.Minute, MaxLockout: time.Hour}
+	app.LoginIP = throttle.Policy{MaxFailures: 20, Window: 15 * time.Minute, Lockout: time.Minute, MaxLockout: time.Hour}
+	app.TwoFactorAccessLevel = int(models.RoleFrontDesk)
 
-	listenForMail()
-
-	defer close(mailChan)
+	app.MailQueued = make(chan struct{}, 1)
+	app.Mail = mailer.Config{Host: "localhost", Port: 1025, Encryption: mailer.EncryptionNone, From: "me@here.com"}
+	app.Mailer = &mailer.Recorder{}
 
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
@@ -76,23 +91,21 @@ func TestMain(m *testing.M) {
 	app.TemplateCache = tc
 	app.UseCache = true
 
+	app.MailTemplateCache, err = email.CreateTemplateCache("./../../email-template")
+	if err != nil {
+		log.Fatal("cannot create email template cache:", err)
+	}
+
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
 
 	render.NewRenderer(&app)
+	email.NewRenderer(&app)
 	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
 
-func listenForMail() {
-	go func() {
-		for {
-			_ = <-app.MailChan
-		}
-	}()
-}
-
 func getRoutes() http.Handler {
 
 	mux := chi.NewRouter()
@@ -110,8 +123,11 @@ func getRoutes() http.Handler {
 	mux.Get("/about", Repo.About)
 	mux.Get("/contact", Repo.Contact)
 
-	mux.Get("/generals-quaters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	// the old room pages, kept so existing links keep working
+	mux.Handle("/generals-quaters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
@@ -122,24 +138,74 @@ func getRoutes() http.Handler {
 
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
+	mux.Get("/checkout", Repo.Checkout)
+	mux.Post("/checkout", Repo.PostCheckout)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/reservation/lookup", Repo.LookupReservation)
+	mux.Post("/reservation/lookup", Repo.PostLookupReservation)
+	mux.Get("/reservation/{code}/cancel", Repo.CancelReservation)
+	mux.Post("/reservation/{code}/cancel", Repo.PostCancelReservation)
 
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
 	mux.Get("/user/logout", Repo.Logout)
+	mux.Get("/user/forgot-password", Repo.ForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", Repo.ResetPassword)
+	mux.Post("/user/reset-password", Repo.PostResetPassword)
+	mux.Get("/user/two-factor", Repo.TwoFactorLogin)
+	mux.Post("/user/two-factor", Repo.PostTwoFactorLogin)
 
 	mux.Get("/admin/dashboard", Repo.AdminDashboard)
 	mux.Get("/admin/reservations-new", Repo.AdminNewReservations)
 	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
 	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalender)
 	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalender)
-	mux.Get("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
-	mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
+	mux.Post("/admin/reservation-status/{src}/{id}/{status}/do", Repo.AdminTransitionReservation)
+	mux.Post("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
+	mux.Get("/admin/reservations-trash", Repo.AdminReservationsTrash)
+	mux.Post("/admin/restore-reservation/{id}/do", Repo.AdminRestoreReservation)
+	mux.Post("/admin/purge-reservation/{id}/do", Repo.AdminPurgeReservation)
 
 	// src and id are matching parameters or matching parts of the route
 	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
 
+	mux.Get("/admin/rooms", Repo.AdminAllRooms)
+	mux.Get("/admin/rooms/{id}/show", Repo.AdminShowRoom)
+	mux.Post("/admin/rooms/{id}", Repo.AdminPostShowRoom)
+	mux.Post("/admin/activate-room/{id}/do", Repo.AdminActivateRoom)
+	mux.Post("/admin/deactivate-room/{id}/do", Repo.AdminDeactivateRoom)
+	mux.Post("/admin/delete-room/{id}/do", Repo.AdminDeleteRoom)
+	mux.Post("/admin/rooms/{id}/seasonal-rates", Repo.AdminPostSeasonalRate)
+	mux.Post("/admin/rooms/{id}/delete-seasonal-rate/{season_id}/do", Repo.AdminDeleteSeasonalRate)
+	mux.Get("/admin/promo-codes", Repo.AdminAllPromoCodes)
+	mux.Get("/admin/promo-codes/{id}/show", Repo.AdminShowPromoCode)
+	mux.Post("/admin/promo-codes/{id}", Repo.AdminPostShowPromoCode)
+	mux.Post("/admin/delete-promo-code/{id}/do", Repo.AdminDeletePromoCode)
+	mux.Get("/admin/audit", Repo.AdminAudit)
+	mux.Get("/admin/users", Repo.AdminAllUsers)
+	mux.Get("/admin/users/{id}/show", Repo.AdminShowUser)
+	mux.Post("/admin/users/{id}", Repo.AdminPostShowUser)
+	mux.Post("/admin/users/{id}/password", Repo.AdminPostUserPassword)
+	mux.Post("/admin/enable-user/{id}/do", Repo.AdminEnableUser)
+	mux.Post("/admin/disable-user/{id}/do", Repo.AdminDisableUser)
+	mux.Post("/admin/reset-two-factor/{id}/do", Repo.AdminResetTwoFactor)
+	mux.Post("/admin/users/{id}/revoke-session/{session_id}/do", Repo.AdminRevokeUserSession)
+	mux.Post("/admin/users/{id}/revoke-sessions/do", Repo.AdminRevokeUserSessions)
+	mux.Get("/admin/login-lockouts", Repo.AdminLoginLockouts)
+	mux.Get("/admin/two-factor", Repo.AdminTwoFactor)
+	mux.Post("/admin/two-factor", Repo.AdminPostTwoFactor)
+	mux.Post("/admin/two-factor/recovery-codes", Repo.AdminPostRecoveryCodes)
+	mux.Post("/admin/two-factor/disable", Repo.AdminPostDisableTwoFactor)
+	mux.Get("/admin/sessions", Repo.AdminSessions)
+	mux.Post("/admin/revoke-session/{id}/do", Repo.AdminRevokeSession)
+	mux.Post("/admin/revoke-sessions/do", Repo.AdminRevokeOtherSessions)
+	mux.Post("/admin/unlock-login/{id}/do", Repo.AdminUnlockLogin)
+	mux.Get("/admin/mail-outbox", Repo.AdminMailOutbox)
+	mux.Get("/admin/mail-outbox/{id}/show", Repo.AdminShowMailMessage)
+	mux.Post("/admin/resend-mail/{id}/do", Repo.AdminResendMail)
+
 	return mux
 }
 
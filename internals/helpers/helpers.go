@@ -2,13 +2,21 @@
 package helpers
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/byt3er/bookings/internals/config"
+	"github.com/byt3er/bookings/internals/models"
 )
 
+type contextKey string
+
+// userContextKey is where the logged in user is kept in the request context
+const userContextKey contextKey = "user"
+
 var app *config.AppConfig
 
 func NewHelpers(a *config.AppConfig) {
@@ -39,3 +47,24 @@ func IsAuthenticate(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// ClientIP returns the IP address a request came from
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// WithUser returns a copy of ctx holding the logged in user
+func WithUser(ctx context.Context, u models.User) context.Context {
+	return context.WithValue(ctx, userContextKey, u)
+}
+
+// CurrentUser returns the logged in user put in the request context by WithUser,
+// or a user without any role when nobody is logged in
+func CurrentUser(r *http.Request) models.User {
+	u, _ := r.Context().Value(userContextKey).(models.User)
+	return u
+}
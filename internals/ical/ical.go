@@ -0,0 +1,140 @@
+// Package ical writes iCalendar (RFC 5545) files with a single event, to attach to
+// emails so the event lands in the receiver's calendar (RFC 6047)
+package ical
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Methods of a calendar, in the iTIP sense (RFC 5546)
+const (
+	MethodRequest = "REQUEST" // adds or updates the event
+	MethodCancel  = "CANCEL"  // removes the event
+)
+
+// ContentType is the MIME type of a calendar, without its method parameter
+const ContentType = "text/calendar"
+
+const prodID = "-//Fort Smythe//Bookings//EN"
+
+// dateTimeUTC is the form of a date with a time in UTC
+const dateTimeUTC = "20060102T150405Z"
+
+// Event is something happening from Start to End
+type Event struct {
+	// UID is the same for every version of the event, so that a
+	// calendar replaces or removes the one it already has
+	UID string
+	// Sequence counts the changes to the event; a calendar ignores
+	// a version that isn't newer than the one it has
+	Sequence    int
+	Summary     string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+	Organizer   Person
+	Attendee    Person
+	Stamp       time.Time // when the calendar was made
+}
+
+// Person is the organizer or attendee of an event
+type Person struct {
+	Name  string
+	Email string
+}
+
+// Calendar returns the calendar with e for method, which is one of the Method constants
+func Calendar(method string, e Event) []byte {
+	status := "CONFIRMED"
+	if method == MethodCancel {
+		status = "CANCELLED"
+	}
+
+	var b strings.Builder
+	line := func(s string) {
+		b.WriteString(fold(s))
+		b.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("PRODID:" + prodID)
+	line("VERSION:2.0")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:" + method)
+	line("BEGIN:VEVENT")
+	line("UID:" + text(e.UID))
+	line(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+	line("DTSTAMP:" + e.Stamp.UTC().Format(dateTimeUTC))
+	line("DTSTART:" + e.Start.UTC().Format(dateTimeUTC))
+	line("DTEND:" + e.End.UTC().Format(dateTimeUTC))
+	line("SUMMARY:" + text(e.Summary))
+	if e.Description != "" {
+		line("DESCRIPTION:" + text(e.Description))
+	}
+	if e.Location != "" {
+		line("LOCATION:" + text(e.Location))
+	}
+	line("STATUS:" + status)
+	line("TRANSP:OPAQUE")
+	if e.Organizer.Email != "" {
+		line("ORGANIZER" + name(e.Organizer) + ":mailto:" + e.Organizer.Email)
+	}
+	if e.Attendee.Email != "" {
+		line("ATTENDEE" + name(e.Attendee) + ";ROLE=REQ-PARTICIPANT;PARTSTAT=ACCEPTED:mailto:" + e.Attendee.Email)
+	}
+	line("END:VEVENT")
+	line("END:VCALENDAR")
+
+	return []byte(b.String())
+}
+
+// text escapes s for a TEXT value
+func text(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", "",
+	).Replace(s)
+}
+
+// name returns the CN parameter for p, empty when p has no name. A parameter
+// value can't hold double quotes, so they are left out
+func name(p Person) string {
+	n := strings.Map(func(r rune) rune {
+		if r == '"' || r < ' ' {
+			return -1
+		}
+		return r
+	}, p.Name)
+	if n == "" {
+		return ""
+	}
+	return `;CN="` + n + `"`
+}
+
+// fold breaks s into lines of at most 75 octets, each after the first
+// starting with a space, without splitting a character
+func fold(s string) string {
+	const limit = 75
+
+	var b strings.Builder
+	width := 0
+	for _, r := range s {
+		size := utf8.RuneLen(r)
+		if width+size > limit {
+			b.WriteString("\r\n ")
+			// the space counts towards the next line
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	return b.String()
+}
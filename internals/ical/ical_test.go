@@ -0,0 +1,84 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func testEvent() Event {
+	return Event{
+		UID:         "reservation-ABCD2345@here.ca",
+		Summary:     "Stay at Fort Smythe, General's Quarters",
+		Description: "Booking code: ABCD2345\nCheck-in from 3:00 PM; check-out by 11:00 AM",
+		Location:    "1 Main Street, Fort Smythe",
+		Start:       time.Date(2050, 1, 1, 15, 0, 0, 0, time.FixedZone("EST", -5*60*60)),
+		End:         time.Date(2050, 1, 3, 11, 0, 0, 0, time.FixedZone("EST", -5*60*60)),
+		Organizer:   Person{Name: "Fort Smythe", Email: "bookings@here.ca"},
+		Attendee:    Person{Name: `John "Jack" Smith`, Email: "john@here.ca"},
+		Stamp:       time.Date(2049, 12, 1, 9, 30, 0, 0, time.UTC),
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	// long lines are folded, so unfold them to find what is in them
+	cal := strings.ReplaceAll(string(Calendar(MethodRequest, testEvent())), "\r\n ", "")
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"\r\nVERSION:2.0\r\n",
+		"\r\nMETHOD:REQUEST\r\n",
+		"\r\nUID:reservation-ABCD2345@here.ca\r\n",
+		"\r\nSEQUENCE:0\r\n",
+		"\r\nDTSTAMP:20491201T093000Z\r\n",
+		"\r\nDTSTART:20500101T200000Z\r\n",
+		"\r\nDTEND:20500103T160000Z\r\n",
+		"\r\nDESCRIPTION:Booking code: ABCD2345\\nCheck-in from 3:00 PM\\; check-out by 11:00 AM\r\n",
+		"\r\nLOCATION:1 Main Street\\, Fort Smythe\r\n",
+		"\r\nSTATUS:CONFIRMED\r\n",
+		"\r\nORGANIZER;CN=\"Fort Smythe\":mailto:bookings@here.ca\r\n",
+		"\r\nATTENDEE;CN=\"John Jack Smith\";ROLE=REQ-PARTICIPANT;PARTSTAT=ACCEPTED:mailto:john@here.ca\r\n",
+		"\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(cal, want) {
+			t.Errorf("expected %q in\n%s", want, cal)
+		}
+	}
+
+	cancel := testEvent()
+	cancel.Sequence = 1
+	cal = string(Calendar(MethodCancel, cancel))
+	for _, want := range []string{"\r\nMETHOD:CANCEL\r\n", "\r\nSEQUENCE:1\r\n", "\r\nSTATUS:CANCELLED\r\n"} {
+		if !strings.Contains(cal, want) {
+			t.Errorf("expected %q in\n%s", want, cal)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"short", "SUMMARY:Stay"},
+		{"exactly the limit", "SUMMARY:" + strings.Repeat("a", 67)},
+		{"long", "DESCRIPTION:" + strings.Repeat("a", 200)},
+		{"multibyte", "SUMMARY:" + strings.Repeat("é", 100)},
+	}
+
+	for _, e := range tests {
+		folded := fold(e.line)
+		for _, l := range strings.Split(folded, "\r\n") {
+			if len(l) > 75 {
+				t.Errorf("%s: line of %d octets", e.name, len(l))
+			}
+			if !utf8.ValidString(l) {
+				t.Errorf("%s: character split in %q", e.name, l)
+			}
+		}
+		if strings.ReplaceAll(folded, "\r\n ", "") != e.line {
+			t.Errorf("%s: expected the line back after unfolding", e.name)
+		}
+	}
+}
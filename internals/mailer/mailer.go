@@ -0,0 +1,52 @@
+// Package mailer delivers the emails the application sends to guests and staff
+package mailer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/byt3er/bookings/internals/models"
+)
+
+// Encryption modes of the connection to the mail server
+const (
+	EncryptionNone     = "none"     // plain text, for a local server like MailHog
+	EncryptionSSLTLS   = "ssltls"   // TLS from the start, usually on port 465
+	EncryptionSTARTTLS = "starttls" // upgraded to TLS after connecting, usually on port 587
+)
+
+// Config holds the settings of the mail server
+type Config struct {
+	Host           string
+	Port           int
+	Username       string // no authentication when empty
+	Password       string
+	Encryption     string // one of the Encryption constants
+	From           string // sender of messages that don't set one
+	ConnectTimeout time.Duration
+	SendTimeout    time.Duration
+}
+
+// Validate reports settings the mail server could never be reached with
+func (c Config) Validate() error {
+	if c.Host == "" {
+		return fmt.Errorf("mail host is required")
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("mail port %d is out of range", c.Port)
+	}
+	switch c.Encryption {
+	case EncryptionNone, EncryptionSSLTLS, EncryptionSTARTTLS:
+	default:
+		return fmt.Errorf("unknown mail encryption %q (%s, %s, %s)", c.Encryption, EncryptionNone, EncryptionSSLTLS, EncryptionSTARTTLS)
+	}
+	if c.From == "" {
+		return fmt.Errorf("mail from address is required")
+	}
+	return nil
+}
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(m models.MailData) error
+}
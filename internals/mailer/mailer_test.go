@@ -0,0 +1,103 @@
+package mailer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byt3er/bookings/internals/models"
+)
+
+func testConfig() Config {
+	return Config{
+		Host:           "localhost",
+		Port:           1025,
+		Encryption:     EncryptionNone,
+		From:           "bookings@here.ca",
+		ConnectTimeout: 10 * time.Second,
+		SendTimeout:    10 * time.Second,
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := testConfig().Validate(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	tests := map[string]func(c *Config){
+		"no host":            func(c *Config) { c.Host = "" },
+		"port out of range":  func(c *Config) { c.Port = 70000 },
+		"unknown encryption": func(c *Config) { c.Encryption = "tls" },
+		"no from address":    func(c *Config) { c.From = "" },
+	}
+	for name, change := range tests {
+		c := testConfig()
+		change(&c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSMTP_Message(t *testing.T) {
+	s := NewSMTP(testConfig())
+
+	email, err := s.message(models.MailData{To: "guest@here.ca", Subject: "Hello", Content: "<p>Hi</p>", Text: "Hi"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	msg := email.GetMessage()
+	if !strings.Contains(msg, "From: <bookings@here.ca>") {
+		t.Error("expected the configured from address on a message without one")
+	}
+	if !strings.Contains(msg, "multipart/alternative") || !strings.Contains(msg, "<p>Hi</p>") {
+		t.Error("expected the HTML as an alternative to the text")
+	}
+
+	email, err = s.message(models.MailData{To: "guest@here.ca", From: "me@here.ca", Content: "<p>Hi</p>"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	msg = email.GetMessage()
+	if !strings.Contains(msg, "From: <me@here.ca>") || strings.Contains(msg, "multipart/alternative") {
+		t.Error("expected an HTML message from its own sender")
+	}
+
+	email, err = s.message(models.MailData{To: "guest@here.ca", Content: "<p>Hi</p>", Text: "Hi", Attachments: []models.Attachment{
+		{Name: "reservation.ics", ContentType: "text/calendar; method=REQUEST", Data: []byte("BEGIN:VCALENDAR")},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	msg = email.GetMessage()
+	if !strings.Contains(msg, "multipart/mixed") || !strings.Contains(msg, "text/calendar; method=REQUEST") ||
+		!strings.Contains(msg, "reservation.ics") {
+		t.Error("expected the calendar to be attached")
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	var r Recorder
+
+	if err := r.Send(models.MailData{To: "guest@here.ca"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(r.Messages()) != 1 || r.Messages()[0].To != "guest@here.ca" {
+		t.Errorf("expected the message to be recorded but got %v", r.Messages())
+	}
+
+	failure := errors.New("mail server down")
+	r.Fail(failure)
+	if err := r.Send(models.MailData{To: "other@here.ca"}); err != failure {
+		t.Errorf("expected the failure but got %v", err)
+	}
+	if len(r.Messages()) != 1 {
+		t.Error("expected a failed message not to be recorded")
+	}
+
+	r.Reset()
+	if len(r.Messages()) != 0 {
+		t.Error("expected no messages after a reset")
+	}
+}
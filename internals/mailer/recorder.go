@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"sync"
+
+	"github.com/byt3er/bookings/internals/models"
+)
+
+// Recorder is a Mailer for local development and tests. It keeps the messages
+// in memory instead of sending them
+type Recorder struct {
+	mu       sync.Mutex
+	messages []models.MailData
+	err      error
+}
+
+// Send records m, or returns the error set with Fail
+func (r *Recorder) Send(m models.MailData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+// Fail makes every Send return err, until it is called again with nil
+func (r *Recorder) Fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.err = err
+}
+
+// Messages returns the messages sent so far, oldest first
+func (r *Recorder) Messages() []models.MailData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]models.MailData(nil), r.messages...)
+}
+
+// Reset forgets the messages sent so far
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = nil
+}
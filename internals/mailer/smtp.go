@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"fmt"
+
+	"github.com/byt3er/bookings/internals/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// SMTP is a Mailer that sends every message over a new connection to a mail server
+type SMTP struct {
+	Config Config
+}
+
+// NewSMTP returns a Mailer for the mail server in c
+func NewSMTP(c Config) *SMTP {
+	return &SMTP{Config: c}
+}
+
+// Send connects to the mail server and sends m
+func (s *SMTP) Send(m models.MailData) error {
+	email, err := s.message(m)
+	if err != nil {
+		return err
+	}
+
+	server := mail.NewSMTPClient()
+	server.Host = s.Config.Host
+	server.Port = s.Config.Port
+	server.Username = s.Config.Username
+	server.Password = s.Config.Password
+	server.Encryption = encryption(s.Config.Encryption)
+	// only connect when there is a message to send
+	server.KeepAlive = false
+	server.ConnectTimeout = s.Config.ConnectTimeout
+	server.SendTimeout = s.Config.SendTimeout
+
+	client, err := server.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to mail server %s:%d: %w", s.Config.Host, s.Config.Port, err)
+	}
+
+	// the client closes the connection once the message is sent
+	return email.Send(client)
+}
+
+// message builds the email for m, with its plain text as an alternative
+// to the HTML when it has one, and its attachments
+func (s *SMTP) message(m models.MailData) (*mail.Email, error) {
+	from := m.From
+	if from == "" {
+		from = s.Config.From
+	}
+
+	email := mail.NewMSG()
+	email.SetFrom(from).AddTo(m.To).SetSubject(m.Subject)
+	if m.Text != "" {
+		email.SetBody(mail.TextPlain, m.Text)
+		email.AddAlternative(mail.TextHTML, m.Content)
+	} else {
+		email.SetBody(mail.TextHTML, m.Content)
+	}
+	for _, a := range m.Attachments {
+		email.Attach(&mail.File{Name: a.Name, MimeType: a.ContentType, Data: a.Data})
+	}
+	return email, email.Error
+}
+
+func encryption(mode string) mail.Encryption {
+	switch mode {
+	case EncryptionSSLTLS:
+		return mail.EncryptionSSLTLS
+	case EncryptionSTARTTLS:
+		return mail.EncryptionSTARTTLS
+	}
+	return mail.EncryptionNone
+}
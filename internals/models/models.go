@@ -1,25 +1,65 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
 // User is the user model
 type User struct {
+	ID                 int
+	FirstName          string
+	LastName           string
+	Email              string
+	Password           string
+	AccessLevel        int
+	DisabledAt         time.Time // zero while the user may log in
+	TwoFactorEnabledAt time.Time // zero while logging in only needs a password
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+// TwoFactorRequired reports whether the user must set up two-factor login
+// before using the admin tool, when it is required above accessLevel
+func (u User) TwoFactorRequired(accessLevel int) bool {
+	return u.AccessLevel > accessLevel && u.TwoFactorEnabledAt.IsZero()
+}
+
+// Room is the room model
+type Room struct {
 	ID          int
-	FirstName   string
-	LastName    string
-	Email       string
-	Password    string
-	AccessLevel int
+	RoomName    string
+	Slug        string // used in the url of the room page, /rooms/{slug}
+	Description string
+	Amenities   []string
+	Capacity    int  // the most guests, adults and children, the room sleeps
+	BaseRate    int  // nightly rate in cents
+	WeekendRate int  // nightly rate in cents for Friday and Saturday nights, 0 to use BaseRate
+	Active      bool // inactive rooms can't be booked
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	Photos      []RoomPhoto
 }
 
-// Room is the room model
-type Room struct {
+// SplitLines splits newline separated text, like the amenities of a room in a textarea
+// or a text column, into its non-empty lines
+func SplitLines(s string) []string {
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// RoomPhoto is the room photo model
+type RoomPhoto struct {
 	ID        int
-	RoomName  string
+	RoomID    int
+	FileName  string
+	Caption   string
+	SortOrder int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -34,18 +74,183 @@ type Restriction struct {
 
 // Reservation is the reservation model
 type Reservation struct {
+	ID              int
+	Code            string // booking code given to the guest
+	FirstName       string
+	LastName        string
+	Email           string
+	Phone           string
+	StartDate       time.Time
+	EndDate         time.Time
+	RoomID          int
+	Adults          int
+	Children        int
+	TotalPrice      int // in cents, after any discount
+	Discount        int // in cents
+	PromoCodeID     int
+	PromoCode       string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Status          ReservationStatus
+	CancelledAt     time.Time     // zero unless the reservation was cancelled
+	CancellationFee int           // in cents, kept when the reservation was cancelled
+	DeletedAt       time.Time     // zero unless the reservation is in the trash
+	DeletedBy       int           // user who moved the reservation to the trash
+	DeletedByName   string        // of DeletedBy
+	Room            Room          // inclue all of the room information
+	Nights          []NightlyRate // price breakdown, not stored in the database
+}
+
+// SeasonalRate replaces a room's rates from StartDate to EndDate, both inclusive
+type SeasonalRate struct {
+	ID          int
+	RoomID      int
+	Name        string
+	StartDate   time.Time
+	EndDate     time.Time
+	Rate        int // nightly rate in cents
+	WeekendRate int // 0 to use Rate
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// PromoCode is the promo code model
+type PromoCode struct {
+	ID             int
+	Code           string
+	Description    string
+	DiscountType   string // "percent" or "fixed"
+	Amount         int    // a percentage, or cents for a fixed discount
+	ValidFrom      time.Time
+	ValidTo        time.Time
+	MinNights      int
+	MaxRedemptions int   // 0 for no limit
+	Redemptions    int   // number of reservations that used the code
+	RoomIDs        []int // rooms the code applies to, empty for all rooms
+	Active         bool
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Payment is the payment model, one record per card transaction of a reservation
+type Payment struct {
+	ID            int
+	ReservationID int
+	Provider      string
+	TransactionID string
+	Amount        int // in cents
+	Refunded      int // in cents
+	Currency      string
+	Status        string
+	CardLast4     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// ReservationStatusChange is one entry in the status history of a reservation
+type ReservationStatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    ReservationStatus
+	ToStatus      ReservationStatus
+	UserID        int    // 0 when the guest made the change
+	UserName      string // of UserID
+	CreatedAt     time.Time
+}
+
+// PasswordReset is a request to reset the password of a user; only the
+// hash of the token that was mailed to them is kept
+type PasswordReset struct {
 	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
+	UserID    int
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    time.Time
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Processed int
-	Room      Room // inclue all of the room information
+}
+
+// Usable reports whether the reset can still be used at now
+func (p PasswordReset) Usable(now time.Time) bool {
+	return p.UsedAt.IsZero() && now.Before(p.ExpiresAt)
+}
+
+// TwoFactor is what is needed to check the second step of a user's login
+type TwoFactor struct {
+	UserID            int
+	Secret            string // base32, shared with the authenticator app
+	EnabledAt         time.Time
+	LastStep          int64 // of the last code used, so it can't be used again
+	RecoveryCodesLeft int
+}
+
+// UserSession is a login of a user, kept alongside their session
+// so that it can be listed and ended from another one
+type UserSession struct {
+	ID         int
+	UserID     int
+	Key        string // kept in the session, to find this record again
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// Scopes of a LoginThrottle
+const (
+	LoginScopeAccount = "account" // Key is the email address that was tried
+	LoginScopeIP      = "ip"      // Key is the address the attempts came from
+)
+
+// LoginThrottle counts the failed logins for an email address or an IP address,
+// and locks them out until LockedUntil when there were too many
+type LoginThrottle struct {
+	ID            int
+	Scope         string
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+	CreatedAt     time.Time
+}
+
+// Locked reports whether logins are locked out at now
+func (l LoginThrottle) Locked(now time.Time) bool {
+	return now.Before(l.LockedUntil)
+}
+
+// AuditEntry is a change made by a user in the admin tool
+type AuditEntry struct {
+	ID        int
+	UserID    int
+	UserName  string // of UserID
+	Action    string
+	Entity    string
+	EntityID  int
+	Changes   map[string]AuditChange // by field name
+	CreatedAt time.Time
+}
+
+// AuditChange is the value of a field before and after a change;
+// Before is nil for new fields and After is nil for removed ones
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditFilter narrows down the audit log; zero values match everything
+type AuditFilter struct {
+	UserID int
+	Entity string
+	From   time.Time
+	To     time.Time
+}
+
+// NightlyRate is the price of a single night of a stay
+type NightlyRate struct {
+	Date    time.Time
+	Rate    int // in cents
+	Weekend bool
+	Season  string // name of the seasonal rate used, if any
 }
 
 // RoomRestriction is the room restrictions model
@@ -65,9 +270,81 @@ type RoomRestriction struct {
 
 // MailData holds an email message
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string // HTML
+	Text        string // the same as Content, for mail programs that don't show HTML
+	Attachments []Attachment
+}
+
+// Attachment is a file sent with an email
+type Attachment struct {
+	Name        string
+	ContentType string // MIME type, with any parameters
+	Data        []byte
+}
+
+// Property is the place guests stay at, as told to them in emails
+type Property struct {
+	Name     string
+	Address  string
+	CheckIn  time.Duration  // after midnight on the day of arrival
+	CheckOut time.Duration  // after midnight on the day of departure
+	Location *time.Location // time zone of CheckIn and CheckOut
+}
+
+// CheckInTime returns when a guest arriving on date can check in
+func (p Property) CheckInTime(date time.Time) time.Time {
+	return p.at(date, p.CheckIn)
+}
+
+// CheckOutTime returns when a guest leaving on date must check out
+func (p Property) CheckOutTime(date time.Time) time.Time {
+	return p.at(date, p.CheckOut)
+}
+
+// at returns the time of day d on date's day, where the property is. It is the
+// time on the clock, so it stays the same on the days the clocks change
+func (p Property) at(date time.Time, d time.Duration) time.Time {
+	loc := p.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	y, m, day := date.Date()
+	return time.Date(y, m, day, 0, 0, int(d/time.Second), 0, loc)
+}
+
+// Statuses of an OutboxMessage
+const (
+	OutboxPending = "pending" // waiting to be sent, or to be sent again
+	OutboxSent    = "sent"
+	OutboxDead    = "dead" // given up on after too many failed sends
+)
+
+// OutboxStatuses lists every status of an OutboxMessage, in the order the outbox page offers them
+var OutboxStatuses = []string{OutboxPending, OutboxSent, OutboxDead}
+
+// ValidOutboxStatus reports whether status is one of OutboxStatuses
+func ValidOutboxStatus(status string) bool {
+	for _, s := range OutboxStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// OutboxMessage is an email in the outbox, kept in the database so it is sent
+// even when the mail server is down or the application restarts
+type OutboxMessage struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int // failed sends so far
+	NextAttemptAt time.Time
+	LastError     string    // of the last failed send
+	SentAt        time.Time // zero until it is sent
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitLines(t *testing.T) {
+	lines := SplitLines("Queen bed\r\n\n  Mountain view \nWi-Fi\n")
+	if got := strings.Join(lines, "|"); got != "Queen bed|Mountain view|Wi-Fi" {
+		t.Errorf("expected the non-empty lines trimmed, but got %q", got)
+	}
+	if lines := SplitLines(" \n"); lines != nil {
+		t.Errorf("expected no lines, but got %q", lines)
+	}
+}
+
+func TestProperty_CheckInTime(t *testing.T) {
+	loc, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	p := Property{CheckIn: 15 * time.Hour, CheckOut: 11*time.Hour + 30*time.Minute, Location: loc}
+
+	// reservation dates are days, kept as midnight UTC
+	in := p.CheckInTime(time.Date(2050, 3, 13, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2050, 3, 13, 15, 0, 0, 0, loc); !in.Equal(want) {
+		t.Errorf("expected %v but got %v", want, in)
+	}
+
+	// the clocks go forward that night, the time on them doesn't change
+	out := p.CheckOutTime(time.Date(2050, 3, 14, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2050, 3, 14, 11, 30, 0, 0, loc); !out.Equal(want) {
+		t.Errorf("expected %v but got %v", want, out)
+	}
+}
@@ -0,0 +1,83 @@
+package models
+
+// Role is what a member of staff may do in the admin tool. It is stored
+// as the access level of the user; each role can do everything the roles
+// before it can.
+type Role int
+
+// Roles, by access level
+const (
+	RoleViewer    Role = iota + 1 // can look at reservations, rooms and promo codes
+	RoleFrontDesk                 // can also change reservations and block rooms
+	RoleManager                   // can also manage rooms, rates, promo codes and the trash
+	RoleOwner                     // can also manage users
+)
+
+// Roles lists every role from the least to the most trusted
+var Roles = []Role{RoleViewer, RoleFrontDesk, RoleManager, RoleOwner}
+
+var roleLabels = map[Role]string{
+	RoleViewer:    "Viewer",
+	RoleFrontDesk: "Front Desk",
+	RoleManager:   "Manager",
+	RoleOwner:     "Owner",
+}
+
+// Permission is something a route of the admin tool lets a user do
+type Permission string
+
+// Permissions checked by the admin routes
+const (
+	PermViewAdmin        Permission = "view_admin"
+	PermEditReservations Permission = "edit_reservations"
+	PermManageTrash      Permission = "manage_trash"
+	PermManageRooms      Permission = "manage_rooms"
+	PermManagePromoCodes Permission = "manage_promo_codes"
+	PermViewAudit        Permission = "view_audit"
+	PermManageUsers      Permission = "manage_users"
+	PermManageMail       Permission = "manage_mail"
+)
+
+// permissionRoles holds the least trusted role that has each permission.
+// This is the only place permissions are granted
+var permissionRoles = map[Permission]Role{
+	PermViewAdmin:        RoleViewer,
+	PermEditReservations: RoleFrontDesk,
+	PermManageTrash:      RoleManager,
+	PermManageRooms:      RoleManager,
+	PermManagePromoCodes: RoleManager,
+	PermViewAudit:        RoleManager,
+	PermManageUsers:      RoleOwner,
+	// sent messages include password reset links
+	PermManageMail: RoleOwner,
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	_, ok := roleLabels[r]
+	return ok
+}
+
+// Label returns the role as shown to people
+func (r Role) Label() string {
+	if label, ok := roleLabels[r]; ok {
+		return label
+	}
+	return "No Access"
+}
+
+// Can reports whether role r has permission p
+func (r Role) Can(p Permission) bool {
+	least, ok := permissionRoles[p]
+	return ok && r.Valid() && r >= least
+}
+
+// Role returns the role of the user, from their access level
+func (u User) Role() Role {
+	return Role(u.AccessLevel)
+}
+
+// Can reports whether the user has permission p
+func (u User) Can(p Permission) bool {
+	return u.Role().Can(p)
+}
@@ -0,0 +1,43 @@
+package models
+
+import "testing"
+
+func TestRole_Can(t *testing.T) {
+	tests := []struct {
+		role     Role
+		perm     Permission
+		expected bool
+	}{
+		{RoleViewer, PermViewAdmin, true},
+		{RoleViewer, PermEditReservations, false},
+		{RoleFrontDesk, PermEditReservations, true},
+		{RoleFrontDesk, PermManageRooms, false},
+		{RoleManager, PermManageRooms, true},
+		{RoleManager, PermViewAudit, true},
+		{RoleManager, PermManageUsers, false},
+		{RoleOwner, PermManageUsers, true},
+		{RoleManager, PermManageMail, false},
+		{RoleOwner, PermManageMail, true},
+		{RoleOwner, Permission("unknown"), false},
+		{Role(0), PermViewAdmin, false},
+		{Role(9), PermViewAdmin, false},
+	}
+
+	for _, e := range tests {
+		if e.role.Can(e.perm) != e.expected {
+			t.Errorf("%s can %s: expected %v", e.role.Label(), e.perm, e.expected)
+		}
+	}
+}
+
+func TestUser_Can(t *testing.T) {
+	var nobody User
+	if nobody.Can(PermViewAdmin) {
+		t.Error("a user without an access level should not see the admin tool")
+	}
+
+	owner := User{AccessLevel: int(RoleOwner)}
+	if !owner.Can(PermManageUsers) {
+		t.Error("an owner should manage users")
+	}
+}
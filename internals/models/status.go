@@ -0,0 +1,70 @@
+package models
+
+// ReservationStatus is where a reservation is in its life cycle
+type ReservationStatus string
+
+// Reservation statuses
+const (
+	StatusPending    ReservationStatus = "pending"     // booked by the guest, not yet looked at by the staff
+	StatusConfirmed  ReservationStatus = "confirmed"   // accepted by the staff
+	StatusCheckedIn  ReservationStatus = "checked_in"  // the guest has arrived
+	StatusCheckedOut ReservationStatus = "checked_out" // the guest has left
+	StatusCancelled  ReservationStatus = "cancelled"   // cancelled by the guest or the staff
+	StatusNoShow     ReservationStatus = "no_show"     // the guest never arrived
+)
+
+// ReservationStatuses lists every status in life cycle order
+var ReservationStatuses = []ReservationStatus{
+	StatusPending,
+	StatusConfirmed,
+	StatusCheckedIn,
+	StatusCheckedOut,
+	StatusCancelled,
+	StatusNoShow,
+}
+
+// reservationTransitions holds the statuses a reservation may move on to from each status.
+// This is the only place the allowed transitions are defined
+var reservationTransitions = map[ReservationStatus][]ReservationStatus{
+	StatusPending:   {StatusConfirmed, StatusCancelled},
+	StatusConfirmed: {StatusCheckedIn, StatusNoShow, StatusCancelled},
+	StatusCheckedIn: {StatusCheckedOut},
+}
+
+var reservationStatusLabels = map[ReservationStatus]string{
+	StatusPending:    "Pending",
+	StatusConfirmed:  "Confirmed",
+	StatusCheckedIn:  "Checked In",
+	StatusCheckedOut: "Checked Out",
+	StatusCancelled:  "Cancelled",
+	StatusNoShow:     "No Show",
+}
+
+// Valid reports whether s is a known status
+func (s ReservationStatus) Valid() bool {
+	_, ok := reservationStatusLabels[s]
+	return ok
+}
+
+// Label returns the status as shown to people
+func (s ReservationStatus) Label() string {
+	if label, ok := reservationStatusLabels[s]; ok {
+		return label
+	}
+	return string(s)
+}
+
+// Transitions returns the statuses a reservation with status s may move on to
+func (s ReservationStatus) Transitions() []ReservationStatus {
+	return reservationTransitions[s]
+}
+
+// CanTransitionTo reports whether a reservation may move from status s to next
+func (s ReservationStatus) CanTransitionTo(next ReservationStatus) bool {
+	for _, t := range reservationTransitions[s] {
+		if t == next {
+			return true
+		}
+	}
+	return false
+}
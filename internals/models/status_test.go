@@ -0,0 +1,43 @@
+package models
+
+import "testing"
+
+func TestReservationStatus_CanTransitionTo(t *testing.T) {
+	allowed := map[ReservationStatus][]ReservationStatus{
+		StatusPending:   {StatusConfirmed, StatusCancelled},
+		StatusConfirmed: {StatusCheckedIn, StatusNoShow, StatusCancelled},
+		StatusCheckedIn: {StatusCheckedOut},
+	}
+
+	for _, from := range ReservationStatuses {
+		for _, to := range ReservationStatuses {
+			expected := false
+			for _, a := range allowed[from] {
+				if a == to {
+					expected = true
+				}
+			}
+			if from.CanTransitionTo(to) != expected {
+				t.Errorf("%s to %s: expected %v", from, to, expected)
+			}
+		}
+	}
+
+	if ReservationStatus("unknown").CanTransitionTo(StatusConfirmed) {
+		t.Error("unknown status should not transition")
+	}
+}
+
+func TestReservationStatus_Valid(t *testing.T) {
+	for _, s := range ReservationStatuses {
+		if !s.Valid() {
+			t.Errorf("expected %s to be valid", s)
+		}
+		if s.Label() == string(s) {
+			t.Errorf("expected a label for %s", s)
+		}
+	}
+	if ReservationStatus("processed").Valid() {
+		t.Error("expected processed to be invalid")
+	}
+}
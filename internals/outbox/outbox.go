@@ -0,0 +1,182 @@
+// Package outbox sends the emails waiting in the mail outbox, retrying failed ones
+// with a growing delay until there have been too many failures to keep trying
+package outbox
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/byt3er/bookings/internals/mailer"
+	"github.com/byt3er/bookings/internals/models"
+)
+
+// Policy retries a message Backoff after its first failed send and twice as long
+// after every failure after that, until it failed MaxAttempts times
+type Policy struct {
+	MaxAttempts int           // failed sends before a message is given up on
+	Backoff     time.Duration // wait after the first failure
+	MaxBackoff  time.Duration // longest wait between two sends
+}
+
+// RetryAfter returns how long to wait before sending a message again
+// after attempts failed sends
+func (p Policy) RetryAfter(attempts int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempts; i++ {
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Dead reports whether a message is given up on after attempts failed sends
+func (p Policy) Dead(attempts int) bool {
+	return attempts >= p.MaxAttempts
+}
+
+// Store is where the outbox is kept
+type Store interface {
+	// ClaimOutboxMessages returns up to limit messages that are due to be sent,
+	// and hides them from other workers until lease has passed
+	ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	MarkOutboxSent(ctx context.Context, id int) error
+	// MarkOutboxFailed counts a failed send, and either retries the message
+	// at retryAt or gives up on it when dead
+	MarkOutboxFailed(ctx context.Context, id int, lastError string, retryAt time.Time, dead bool) error
+}
+
+// Worker sends the messages in a Store, Workers of them at a time
+type Worker struct {
+	Store     Store
+	Mailer    mailer.Mailer
+	Policy    Policy
+	Workers   int             // messages sent at the same time
+	Interval  time.Duration   // how often to look for messages that are due
+	Lease     time.Duration   // how long a message may take to send before it is sent again
+	BatchSize int             // messages claimed at a time
+	Wake      <-chan struct{} // looks for messages straight away when signalled
+	ErrorLog  *log.Logger
+	InfoLog   *log.Logger
+	now       func() time.Time
+}
+
+// Run sends messages as they are due, until ctx is done. The messages that
+// were claimed by then are still sent before it returns
+func (w *Worker) Run(ctx context.Context) {
+	w.pool(func(jobs chan<- models.OutboxMessage) {
+		ticker := time.NewTicker(w.Interval)
+		defer ticker.Stop()
+
+		for {
+			if err := w.drain(context.Background(), jobs); err != nil {
+				w.ErrorLog.Println(err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-w.Wake:
+			}
+		}
+	})
+}
+
+// Flush sends the messages that are due until there are none left, or returns
+// the error of ctx when it is done first, e.g. when shutting down takes too long
+func (w *Worker) Flush(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		w.pool(func(jobs chan<- models.OutboxMessage) {
+			err = w.drain(ctx, jobs)
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pool starts Workers goroutines that send the messages feed puts on jobs,
+// and waits for them to finish once feed returns
+func (w *Worker) pool(feed func(jobs chan<- models.OutboxMessage)) {
+	jobs := make(chan models.OutboxMessage)
+	var wg sync.WaitGroup
+
+	workers := w.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range jobs {
+				if err := w.send(msg); err != nil {
+					w.ErrorLog.Println(err)
+				}
+			}
+		}()
+	}
+
+	feed(jobs)
+	close(jobs)
+	wg.Wait()
+}
+
+// drain claims the messages that are due, a batch at a time, and hands them to the
+// workers. It returns once a batch comes back short, so the rest can wait for the next look
+func (w *Worker) drain(ctx context.Context, jobs chan<- models.OutboxMessage) error {
+	for {
+		messages, err := w.Store.ClaimOutboxMessages(ctx, w.BatchSize, w.Lease)
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			jobs <- msg
+		}
+		if len(messages) < w.BatchSize {
+			return nil
+		}
+	}
+}
+
+// send sends msg and records how that went. This isn't cut short when shutting down,
+// a message that was sent but not marked as sent would be sent again
+func (w *Worker) send(msg models.OutboxMessage) error {
+	ctx := context.Background()
+
+	sendErr := w.Mailer.Send(msg.Mail)
+	if sendErr == nil {
+		w.InfoLog.Printf("Email %d sent to %s", msg.ID, msg.Mail.To)
+		return w.Store.MarkOutboxSent(ctx, msg.ID)
+	}
+
+	attempts := msg.Attempts + 1
+	dead := w.Policy.Dead(attempts)
+	if dead {
+		w.ErrorLog.Printf("Giving up on email %d to %s after %d attempts: %v", msg.ID, msg.Mail.To, attempts, sendErr)
+	} else {
+		w.ErrorLog.Printf("Email %d to %s failed, attempt %d: %v", msg.ID, msg.Mail.To, attempts, sendErr)
+	}
+	return w.Store.MarkOutboxFailed(ctx, msg.ID, sendErr.Error(), w.clock().Add(w.Policy.RetryAfter(attempts)), dead)
+}
+
+func (w *Worker) clock() time.Time {
+	if w.now != nil {
+		return w.now()
+	}
+	return time.Now()
+}
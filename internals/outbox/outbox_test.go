@@ -0,0 +1,222 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byt3er/bookings/internals/mailer"
+	"github.com/byt3er/bookings/internals/models"
+)
+
+func TestPolicy_RetryAfter(t *testing.T) {
+	p := Policy{MaxAttempts: 5, Backoff: time.Minute, MaxBackoff: 10 * time.Minute}
+
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, e := range tests {
+		if d := p.RetryAfter(e.attempts); d != e.expected {
+			t.Errorf("after %d attempts expected %s but got %s", e.attempts, e.expected, d)
+		}
+	}
+
+	if p.Dead(4) || !p.Dead(5) {
+		t.Error("expected a message to be given up on after 5 attempts")
+	}
+}
+
+type failure struct {
+	lastError string
+	retryAt   time.Time
+	dead      bool
+}
+
+// testStore is an outbox in memory
+type testStore struct {
+	mu     sync.Mutex
+	due    []models.OutboxMessage
+	sent   map[int]bool
+	failed map[int]failure
+}
+
+func newTestStore(due ...models.OutboxMessage) *testStore {
+	return &testStore{due: due, sent: make(map[int]bool), failed: make(map[int]failure)}
+}
+
+func (s *testStore) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if limit > len(s.due) {
+		limit = len(s.due)
+	}
+	claimed := s.due[:limit]
+	s.due = s.due[limit:]
+	return claimed, nil
+}
+
+func (s *testStore) MarkOutboxSent(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent[id] = true
+	return nil
+}
+
+func (s *testStore) MarkOutboxFailed(ctx context.Context, id int, lastError string, retryAt time.Time, dead bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failed[id] = failure{lastError, retryAt, dead}
+	return nil
+}
+
+func (s *testStore) add(msg models.OutboxMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.due = append(s.due, msg)
+}
+
+func (s *testStore) wasSent(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sent[id]
+}
+
+// testMailer fails to send to down@here.ca, and keeps track of how many
+// messages it was sending at the same time
+type testMailer struct {
+	mu      sync.Mutex
+	sending int
+	most    int
+}
+
+func (m *testMailer) Send(msg models.MailData) error {
+	m.mu.Lock()
+	m.sending++
+	if m.sending > m.most {
+		m.most = m.sending
+	}
+	m.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	m.mu.Lock()
+	m.sending--
+	m.mu.Unlock()
+
+	if msg.To == "down@here.ca" {
+		return errors.New("mail server down")
+	}
+	return nil
+}
+
+func newTestWorker(store Store, m mailer.Mailer) *Worker {
+	now := time.Date(2050, 6, 15, 12, 0, 0, 0, time.UTC)
+	return &Worker{
+		Store:     store,
+		Mailer:    m,
+		Policy:    Policy{MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: time.Hour},
+		Workers:   2,
+		Interval:  time.Hour,
+		Lease:     time.Minute,
+		BatchSize: 2,
+		ErrorLog:  log.New(io.Discard, "", 0),
+		InfoLog:   log.New(io.Discard, "", 0),
+		now:       func() time.Time { return now },
+	}
+}
+
+func TestWorker_Flush(t *testing.T) {
+	var due []models.OutboxMessage
+	for i := 1; i <= 5; i++ {
+		due = append(due, models.OutboxMessage{ID: i, Mail: models.MailData{To: "guest@here.ca"}})
+	}
+	due = append(due,
+		models.OutboxMessage{ID: 6, Mail: models.MailData{To: "down@here.ca"}, Attempts: 1},
+		models.OutboxMessage{ID: 7, Mail: models.MailData{To: "down@here.ca"}, Attempts: 2},
+	)
+	store := newTestStore(due...)
+	m := &testMailer{}
+	w := newTestWorker(store, m)
+
+	if err := w.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		if !store.wasSent(i) {
+			t.Errorf("expected message %d to be sent", i)
+		}
+	}
+	if m.most != 2 {
+		t.Errorf("expected 2 messages to be sent at the same time, but got %d", m.most)
+	}
+
+	f := store.failed[6]
+	if f.dead || f.lastError != "mail server down" || !f.retryAt.Equal(w.now().Add(2*time.Minute)) {
+		t.Errorf("expected message 6 to be retried in 2 minutes, but got %+v", f)
+	}
+	if f = store.failed[7]; !f.dead {
+		t.Errorf("expected message 7 to be given up on, but got %+v", f)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	store.add(models.OutboxMessage{ID: 8})
+	if err := w.Flush(ctx); err != context.Canceled {
+		t.Errorf("expected the flush to be cut short, but got %v", err)
+	}
+}
+
+func TestWorker_Run(t *testing.T) {
+	store := newTestStore(models.OutboxMessage{ID: 1, Mail: models.MailData{To: "guest@here.ca"}})
+	wake := make(chan struct{}, 1)
+	w := newTestWorker(store, &testMailer{})
+	w.Wake = wake
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	// queued while the worker is waiting for the next look at the outbox
+	store.add(models.OutboxMessage{ID: 2, Mail: models.MailData{To: "guest@here.ca"}})
+	wake <- struct{}{}
+
+	deadline := time.Now().Add(time.Second)
+	for !store.wasSent(2) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the worker to stop")
+	}
+
+	if !store.wasSent(1) || !store.wasSent(2) {
+		t.Error("expected both messages to be sent")
+	}
+}
@@ -0,0 +1,122 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Card numbers with special meaning to the fake gateway. Any other number is accepted
+const (
+	FakeCardDeclined = "4000000000000002"
+)
+
+type fakeTransaction struct {
+	amount   int
+	refunded int
+	status   string
+}
+
+// FakeGateway is a Gateway for local development and tests. It keeps transactions
+// in memory and never talks to a real provider
+type FakeGateway struct {
+	mu           sync.Mutex
+	next         int
+	transactions map[string]*fakeTransaction
+	now          func() time.Time
+}
+
+// NewFakeGateway returns a FakeGateway with no transactions
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		transactions: make(map[string]*fakeTransaction),
+		now:          time.Now,
+	}
+}
+
+// Name identifies the fake gateway in payment records
+func (g *FakeGateway) Name() string {
+	return "fake"
+}
+
+// Authorize accepts any card, except FakeCardDeclined and expired cards
+func (g *FakeGateway) Authorize(ctx context.Context, c Charge) (string, error) {
+	number := strings.ReplaceAll(c.Card.Number, " ", "")
+	if number == FakeCardDeclined {
+		return "", ErrDeclined
+	}
+
+	// a card is valid until the end of its expiry month
+	now := g.now()
+	expires := time.Date(c.Card.ExpYear, time.Month(c.Card.ExpMonth)+1, 1, 0, 0, 0, 0, now.Location())
+	if !now.Before(expires) {
+		return "", ErrCardExpired
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.next++
+	id := fmt.Sprintf("fake_%06d", g.next)
+	g.transactions[id] = &fakeTransaction{amount: c.Amount, status: StatusAuthorized}
+	return id, nil
+}
+
+// Capture takes an authorized amount
+func (g *FakeGateway) Capture(ctx context.Context, transactionID string) error {
+	return g.move(transactionID, StatusAuthorized, StatusCaptured)
+}
+
+// Void releases an authorized amount
+func (g *FakeGateway) Void(ctx context.Context, transactionID string) error {
+	return g.move(transactionID, StatusAuthorized, StatusVoided)
+}
+
+// Refund gives back some or all of a captured amount. A transaction is refunded
+// once all of it has been given back
+func (g *FakeGateway) Refund(ctx context.Context, transactionID string, amount int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	t, ok := g.transactions[transactionID]
+	if !ok {
+		return ErrUnknownTransaction
+	}
+	if t.status != StatusCaptured || amount <= 0 || t.refunded+amount > t.amount {
+		return ErrInvalidState
+	}
+
+	t.refunded += amount
+	if t.refunded == t.amount {
+		t.status = StatusRefunded
+	}
+	return nil
+}
+
+// Status returns the status of a transaction, for tests
+func (g *FakeGateway) Status(transactionID string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if t, ok := g.transactions[transactionID]; ok {
+		return t.status
+	}
+	return ""
+}
+
+func (g *FakeGateway) move(transactionID, from, to string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	t, ok := g.transactions[transactionID]
+	if !ok {
+		return ErrUnknownTransaction
+	}
+	if t.status != from {
+		return ErrInvalidState
+	}
+	t.status = to
+	return nil
+}
@@ -0,0 +1,94 @@
+package payments
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestGateway() *FakeGateway {
+	g := NewFakeGateway()
+	g.now = func() time.Time {
+		return time.Date(2050, 6, 15, 12, 0, 0, 0, time.UTC)
+	}
+	return g
+}
+
+func testCharge(number string, month, year int) Charge {
+	return Charge{
+		Amount:   20000,
+		Currency: Currency,
+		Card:     Card{Name: "John Smith", Number: number, ExpMonth: month, ExpYear: year, CVC: "123"},
+	}
+}
+
+func TestFakeGateway_Authorize(t *testing.T) {
+	g := newTestGateway()
+	ctx := context.Background()
+
+	id, err := g.Authorize(ctx, testCharge("4242 4242 4242 4242", 6, 2050))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if g.Status(id) != StatusAuthorized {
+		t.Errorf("expected status %s but got %s", StatusAuthorized, g.Status(id))
+	}
+
+	if _, err = g.Authorize(ctx, testCharge(FakeCardDeclined, 1, 2060)); err != ErrDeclined {
+		t.Errorf("expected ErrDeclined but got %v", err)
+	}
+
+	if _, err = g.Authorize(ctx, testCharge("4242424242424242", 5, 2050)); err != ErrCardExpired {
+		t.Errorf("expected ErrCardExpired but got %v", err)
+	}
+}
+
+func TestFakeGateway_CaptureAndRefund(t *testing.T) {
+	g := newTestGateway()
+	ctx := context.Background()
+
+	id, _ := g.Authorize(ctx, testCharge("4242424242424242", 1, 2060))
+
+	if err := g.Refund(ctx, id, 100); err != ErrInvalidState {
+		t.Errorf("refunding an authorization: expected ErrInvalidState but got %v", err)
+	}
+
+	if err := g.Capture(ctx, id); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := g.Void(ctx, id); err != ErrInvalidState {
+		t.Errorf("voiding a capture: expected ErrInvalidState but got %v", err)
+	}
+
+	if err := g.Refund(ctx, id, 5000); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if g.Status(id) != StatusCaptured {
+		t.Errorf("after a partial refund expected status %s but got %s", StatusCaptured, g.Status(id))
+	}
+	if err := g.Refund(ctx, id, 20000); err != ErrInvalidState {
+		t.Errorf("refunding too much: expected ErrInvalidState but got %v", err)
+	}
+	if err := g.Refund(ctx, id, 15000); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if g.Status(id) != StatusRefunded {
+		t.Errorf("expected status %s but got %s", StatusRefunded, g.Status(id))
+	}
+}
+
+func TestFakeGateway_Void(t *testing.T) {
+	g := newTestGateway()
+	ctx := context.Background()
+
+	id, _ := g.Authorize(ctx, testCharge("4242424242424242", 1, 2060))
+	if err := g.Void(ctx, id); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := g.Capture(ctx, id); err != ErrInvalidState {
+		t.Errorf("capturing a voided authorization: expected ErrInvalidState but got %v", err)
+	}
+	if err := g.Capture(ctx, "nope"); err != ErrUnknownTransaction {
+		t.Errorf("expected ErrUnknownTransaction but got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+// Currency is the currency all prices are charged in
+const Currency = "USD"
+
+// Payment statuses, as stored with the payment records of a reservation
+const (
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusRefunded   = "refunded"
+	StatusVoided     = "voided"
+)
+
+var (
+	// ErrDeclined is returned when the card issuer refuses a payment
+	ErrDeclined = errors.New("card declined")
+	// ErrCardExpired is returned for cards past their expiry date
+	ErrCardExpired = errors.New("card expired")
+	// ErrUnknownTransaction is returned for transaction ids the gateway doesn't know
+	ErrUnknownTransaction = errors.New("unknown transaction")
+	// ErrInvalidState is returned when a transaction can't move to the requested state,
+	// e.g. when capturing a voided authorization
+	ErrInvalidState = errors.New("transaction is not in a valid state for this operation")
+)
+
+// Card holds the card details a guest typed into the checkout form. They are only
+// passed on to the gateway and never stored
+type Card struct {
+	Name     string
+	Number   string
+	ExpMonth int
+	ExpYear  int // four digits
+	CVC      string
+}
+
+// Last4 returns the last four digits of the card number, which can be stored
+func (c Card) Last4() string {
+	if len(c.Number) < 4 {
+		return c.Number
+	}
+	return c.Number[len(c.Number)-4:]
+}
+
+// Charge describes an amount to authorize on a card
+type Charge struct {
+	Amount      int // in cents
+	Currency    string
+	Description string
+	Card        Card
+}
+
+// Gateway is a payment provider. Money is first authorized (held on the card),
+// then either captured (taken) or voided (released). Captured money can be refunded
+type Gateway interface {
+	// Name identifies the provider in payment records
+	Name() string
+	// Authorize holds the amount on the card and returns the transaction id
+	Authorize(ctx context.Context, c Charge) (string, error)
+	// Capture takes an authorized amount
+	Capture(ctx context.Context, transactionID string) error
+	// Void releases an authorized amount that hasn't been captured
+	Void(ctx context.Context, transactionID string) error
+	// Refund gives back some or all of a captured amount, in cents
+	Refund(ctx context.Context, transactionID string, amount int) error
+}
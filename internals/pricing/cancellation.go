@@ -0,0 +1,42 @@
+package pricing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/byt3er/bookings/internals/models"
+)
+
+// ErrCancellationClosed is returned when a reservation can no longer be cancelled by the guest
+var ErrCancellationClosed = errors.New("reservation can no longer be cancelled")
+
+// CancellationPolicy decides what a guest pays for cancelling a reservation
+type CancellationPolicy struct {
+	CutoffDays     int // cancelling at least this many days before arrival is free
+	LateFeePercent int // share of the total kept when cancelling after the cutoff
+	LateFeeMin     int // in cents, the least that is kept when cancelling after the cutoff
+}
+
+// CancellationFee returns the fee in cents for cancelling res on day now.
+// Guests can cancel up to the day before arrival, and the fee is never more than res.TotalPrice
+func (p CancellationPolicy) CancellationFee(res models.Reservation, now time.Time) (int, error) {
+	today := truncateToDay(now)
+	arrival := truncateToDay(res.StartDate)
+	if !today.Before(arrival) {
+		return 0, ErrCancellationClosed
+	}
+
+	daysBefore := int(arrival.Sub(today).Hours() / 24)
+	if daysBefore >= p.CutoffDays {
+		return 0, nil
+	}
+
+	fee := res.TotalPrice * p.LateFeePercent / 100
+	if fee < p.LateFeeMin {
+		fee = p.LateFeeMin
+	}
+	if fee > res.TotalPrice {
+		fee = res.TotalPrice
+	}
+	return fee, nil
+}
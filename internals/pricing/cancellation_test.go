@@ -0,0 +1,47 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+)
+
+var policy = CancellationPolicy{
+	CutoffDays:     7,
+	LateFeePercent: 50,
+	LateFeeMin:     2000,
+}
+
+var cancellationFeeTests = []struct {
+	name        string
+	policy      CancellationPolicy
+	total       int
+	today       string
+	expectedFee int
+	expectedErr error
+}{
+	{"well before arrival", policy, 30000, "2050-06-01", 0, nil},
+	{"on the cutoff day", policy, 30000, "2050-06-24", 0, nil},
+	{"after the cutoff", policy, 30000, "2050-06-25", 15000, nil},
+	{"day before arrival", policy, 30000, "2050-06-30", 15000, nil},
+	{"minimum fee", policy, 3000, "2050-06-30", 2000, nil},
+	{"minimum fee more than total", policy, 1000, "2050-06-30", 1000, nil},
+	{"no late fee", CancellationPolicy{CutoffDays: 7}, 30000, "2050-06-30", 0, nil},
+	{"day of arrival", policy, 30000, "2050-07-01", 0, ErrCancellationClosed},
+	{"after arrival", policy, 30000, "2050-07-02", 0, ErrCancellationClosed},
+}
+
+func TestCancellationFee(t *testing.T) {
+	for _, e := range cancellationFeeTests {
+		res := stay
+		res.TotalPrice = e.total
+
+		// the time of day doesn't matter
+		fee, err := e.policy.CancellationFee(res, date(e.today).Add(20*time.Hour))
+		if err != e.expectedErr {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedErr, err)
+		}
+		if fee != e.expectedFee {
+			t.Errorf("%s: expected fee %d but got %d", e.name, e.expectedFee, fee)
+		}
+	}
+}
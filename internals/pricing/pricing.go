@@ -0,0 +1,106 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/byt3er/bookings/internals/models"
+)
+
+// ErrInvalidDates is returned when the departure is not after the arrival
+var ErrInvalidDates = errors.New("departure must be after arrival")
+
+// IsWeekend reports whether the night starting on t is charged at the weekend rate.
+// Friday and Saturday nights are weekend nights
+func IsWeekend(t time.Time) bool {
+	return t.Weekday() == time.Friday || t.Weekday() == time.Saturday
+}
+
+// Quote works out the price of every night from start to end (the departure day
+// is not charged) and returns the per-night breakdown and the total, in cents.
+// A seasonal rate covering a night replaces the room's own rates for that night
+func Quote(room models.Room, seasons []models.SeasonalRate, start, end time.Time) ([]models.NightlyRate, int, error) {
+	start = truncateToDay(start)
+	end = truncateToDay(end)
+	if !end.After(start) {
+		return nil, 0, ErrInvalidDates
+	}
+
+	var nights []models.NightlyRate
+	total := 0
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := models.NightlyRate{
+			Date:    d,
+			Weekend: IsWeekend(d),
+		}
+
+		rate, weekendRate := room.BaseRate, room.WeekendRate
+		if s, ok := seasonFor(seasons, d); ok {
+			rate, weekendRate = s.Rate, s.WeekendRate
+			night.Season = s.Name
+		}
+
+		night.Rate = rate
+		if night.Weekend && weekendRate > 0 {
+			night.Rate = weekendRate
+		}
+
+		total += night.Rate
+		nights = append(nights, night)
+	}
+	return nights, total, nil
+}
+
+// seasonFor returns the first seasonal rate whose dates, both inclusive, cover d
+func seasonFor(seasons []models.SeasonalRate, d time.Time) (models.SeasonalRate, bool) {
+	for _, s := range seasons {
+		if !d.Before(truncateToDay(s.StartDate)) && !d.After(truncateToDay(s.EndDate)) {
+			return s, true
+		}
+	}
+	return models.SeasonalRate{}, false
+}
+
+func truncateToDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// FormatMoney formats an amount in cents, e.g. 12050 becomes "$120.50"
+func FormatMoney(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
+}
+
+// ParseMoney turns an amount typed in a form, e.g. "120", "120.5" or "$120.50",
+// into cents
+func ParseMoney(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "$")
+	if s == "" {
+		return 0, errors.New("empty amount")
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("too many decimals in %q", s)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+
+	dollars, err := strconv.Atoi(whole)
+	if err != nil || dollars < 0 {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	cents, err := strconv.Atoi(frac)
+	if err != nil || cents < 0 {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return dollars*100 + cents, nil
+}
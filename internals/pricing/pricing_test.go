@@ -0,0 +1,90 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/byt3er/bookings/internals/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+var room = models.Room{ID: 1, BaseRate: 10000, WeekendRate: 15000}
+
+var quoteTests = []struct {
+	name          string
+	room          models.Room
+	seasons       []models.SeasonalRate
+	start         string
+	end           string
+	expectedTotal int
+	expectedRates []int
+}{
+	// 2050-01-03 is a Monday
+	{"weekdays", room, nil, "2050-01-03", "2050-01-05", 20000, []int{10000, 10000}},
+	{"weekend", room, nil, "2050-01-06", "2050-01-10", 50000, []int{10000, 15000, 15000, 10000}},
+	{"no weekend rate", models.Room{BaseRate: 10000}, nil, "2050-01-07", "2050-01-09", 20000, []int{10000, 10000}},
+	{
+		"season", room,
+		[]models.SeasonalRate{{Name: "Winter", StartDate: date("2050-01-04"), EndDate: date("2050-01-07"), Rate: 8000, WeekendRate: 9000}},
+		"2050-01-03", "2050-01-09", 58000, []int{10000, 8000, 8000, 8000, 9000, 15000},
+	},
+	{
+		"season without weekend rate", room,
+		[]models.SeasonalRate{{Name: "Summer", StartDate: date("2050-01-01"), EndDate: date("2050-01-31"), Rate: 20000}},
+		"2050-01-07", "2050-01-08", 20000, []int{20000},
+	},
+}
+
+func TestQuote(t *testing.T) {
+	for _, e := range quoteTests {
+		nights, total, err := Quote(e.room, e.seasons, date(e.start), date(e.end))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+		if total != e.expectedTotal {
+			t.Errorf("%s: expected total %d but got %d", e.name, e.expectedTotal, total)
+		}
+		if len(nights) != len(e.expectedRates) {
+			t.Errorf("%s: expected %d nights but got %d", e.name, len(e.expectedRates), len(nights))
+			continue
+		}
+		for i, n := range nights {
+			if n.Rate != e.expectedRates[i] {
+				t.Errorf("%s: night %d expected rate %d but got %d", e.name, i, e.expectedRates[i], n.Rate)
+			}
+		}
+	}
+
+	_, _, err := Quote(room, nil, date("2050-01-05"), date("2050-01-05"))
+	if err != ErrInvalidDates {
+		t.Errorf("expected ErrInvalidDates for zero nights, got %v", err)
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	for cents, expected := range map[int]string{0: "$0.00", 5: "$0.05", 12050: "$120.50", -2500: "-$25.00"} {
+		if got := FormatMoney(cents); got != expected {
+			t.Errorf("FormatMoney(%d): expected %s but got %s", cents, expected, got)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	for s, expected := range map[string]int{"120": 12000, "120.5": 12050, "$120.50": 12050, " 0.05 ": 5} {
+		got, err := ParseMoney(s)
+		if err != nil || got != expected {
+			t.Errorf("ParseMoney(%q): expected %d but got %d (%v)", s, expected, got, err)
+		}
+	}
+
+	for _, s := range []string{"", "abc", "1.234", "-5", "1.-5"} {
+		if _, err := ParseMoney(s); err == nil {
+			t.Errorf("ParseMoney(%q): expected an error", s)
+		}
+	}
+}
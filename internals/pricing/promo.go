@@ -0,0 +1,76 @@
+package pricing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/byt3er/bookings/internals/models"
+)
+
+// Discount types of a promo code
+const (
+	DiscountPercent = "percent" // Amount is a percentage of the total
+	DiscountFixed   = "fixed"   // Amount is in cents
+)
+
+var (
+	// ErrPromoInactive is returned for promo codes that have been switched off
+	ErrPromoInactive = errors.New("promo code is not active")
+	// ErrPromoNotStarted is returned before the first day a promo code can be used
+	ErrPromoNotStarted = errors.New("promo code can't be used yet")
+	// ErrPromoExpired is returned after the last day a promo code can be used
+	ErrPromoExpired = errors.New("promo code has expired")
+	// ErrPromoMinStay is returned when the stay is too short for a promo code
+	ErrPromoMinStay = errors.New("stay is too short for promo code")
+	// ErrPromoRoom is returned when a promo code doesn't apply to the booked room
+	ErrPromoRoom = errors.New("promo code doesn't apply to this room")
+	// ErrPromoExhausted is returned when a promo code has been redeemed the maximum number of times
+	ErrPromoExhausted = errors.New("promo code has been used up")
+)
+
+// Discount checks that a promo code can be used for a reservation made on day now,
+// and returns the discount in cents, which is never more than res.TotalPrice
+func Discount(p models.PromoCode, res models.Reservation, now time.Time) (int, error) {
+	if !p.Active {
+		return 0, ErrPromoInactive
+	}
+
+	today := truncateToDay(now)
+	if today.Before(truncateToDay(p.ValidFrom)) {
+		return 0, ErrPromoNotStarted
+	}
+	if today.After(truncateToDay(p.ValidTo)) {
+		return 0, ErrPromoExpired
+	}
+
+	nights := int(truncateToDay(res.EndDate).Sub(truncateToDay(res.StartDate)).Hours() / 24)
+	if nights < p.MinNights {
+		return 0, ErrPromoMinStay
+	}
+
+	if len(p.RoomIDs) > 0 {
+		found := false
+		for _, id := range p.RoomIDs {
+			if id == res.RoomID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, ErrPromoRoom
+		}
+	}
+
+	if p.MaxRedemptions > 0 && p.Redemptions >= p.MaxRedemptions {
+		return 0, ErrPromoExhausted
+	}
+
+	discount := p.Amount
+	if p.DiscountType == DiscountPercent {
+		discount = res.TotalPrice * p.Amount / 100
+	}
+	if discount > res.TotalPrice {
+		discount = res.TotalPrice
+	}
+	return discount, nil
+}
@@ -0,0 +1,90 @@
+package pricing
+
+import (
+	"testing"
+
+	"github.com/byt3er/bookings/internals/models"
+)
+
+var promo = models.PromoCode{
+	Code:         "SUMMER",
+	DiscountType: DiscountPercent,
+	Amount:       10,
+	ValidFrom:    date("2050-06-01"),
+	ValidTo:      date("2050-08-31"),
+	MinNights:    2,
+	RoomIDs:      []int{1},
+	Active:       true,
+}
+
+var stay = models.Reservation{
+	RoomID:     1,
+	StartDate:  date("2050-07-01"),
+	EndDate:    date("2050-07-04"),
+	TotalPrice: 30000,
+}
+
+var discountTests = []struct {
+	name             string
+	promo            func(p models.PromoCode) models.PromoCode
+	res              func(r models.Reservation) models.Reservation
+	today            string
+	expectedDiscount int
+	expectedErr      error
+}{
+	{"percent", nil, nil, "2050-06-15", 3000, nil},
+	{"fixed", func(p models.PromoCode) models.PromoCode {
+		p.DiscountType, p.Amount = DiscountFixed, 5000
+		return p
+	}, nil, "2050-06-15", 5000, nil},
+	{"fixed more than total", func(p models.PromoCode) models.PromoCode {
+		p.DiscountType, p.Amount = DiscountFixed, 50000
+		return p
+	}, nil, "2050-06-15", 30000, nil},
+	{"any room", func(p models.PromoCode) models.PromoCode {
+		p.RoomIDs = nil
+		return p
+	}, func(r models.Reservation) models.Reservation {
+		r.RoomID = 2
+		return r
+	}, "2050-06-15", 3000, nil},
+	{"first and last day", nil, nil, "2050-08-31", 3000, nil},
+	{"inactive", func(p models.PromoCode) models.PromoCode {
+		p.Active = false
+		return p
+	}, nil, "2050-06-15", 0, ErrPromoInactive},
+	{"not started", nil, nil, "2050-05-31", 0, ErrPromoNotStarted},
+	{"expired", nil, nil, "2050-09-01", 0, ErrPromoExpired},
+	{"short stay", nil, func(r models.Reservation) models.Reservation {
+		r.EndDate = date("2050-07-02")
+		return r
+	}, "2050-06-15", 0, ErrPromoMinStay},
+	{"wrong room", nil, func(r models.Reservation) models.Reservation {
+		r.RoomID = 2
+		return r
+	}, "2050-06-15", 0, ErrPromoRoom},
+	{"used up", func(p models.PromoCode) models.PromoCode {
+		p.MaxRedemptions, p.Redemptions = 5, 5
+		return p
+	}, nil, "2050-06-15", 0, ErrPromoExhausted},
+}
+
+func TestDiscount(t *testing.T) {
+	for _, e := range discountTests {
+		p, res := promo, stay
+		if e.promo != nil {
+			p = e.promo(p)
+		}
+		if e.res != nil {
+			res = e.res(res)
+		}
+
+		discount, err := Discount(p, res, date(e.today))
+		if err != e.expectedErr {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedErr, err)
+		}
+		if discount != e.expectedDiscount {
+			t.Errorf("%s: expected discount %d but got %d", e.name, e.expectedDiscount, discount)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package render
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
@@ -12,16 +13,20 @@ import (
 	"time"
 
 	"github.com/byt3er/bookings/internals/config"
+	"github.com/byt3er/bookings/internals/helpers"
 	"github.com/byt3er/bookings/internals/models"
+	"github.com/byt3er/bookings/internals/pricing"
 	"github.com/justinas/nosurf"
 )
 
 var functions = template.FuncMap{
-	"humanDate":  HumanDate,
-	"formatDate": FormatDate,
-	"iterate":    Iterate,
-	"add":        Add,
-	"adds":       AddS,
+	"humanDate":   HumanDate,
+	"formatDate":  FormatDate,
+	"iterate":     Iterate,
+	"add":         Add,
+	"adds":        AddS,
+	"formatMoney": pricing.FormatMoney,
+	"toJSON":      ToJSON,
 }
 
 var app *config.AppConfig
@@ -63,6 +68,15 @@ func FormatDate(t time.Time, f string) string {
 	return t.Format(f)
 }
 
+// ToJSON returns v as JSON, so nil shows as null and strings are quoted
+func ToJSON(v interface{}) string {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(j)
+}
+
 // AddDefaultData adds data for all templates
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 	td.Flash = app.Session.PopString(r.Context(), "flash")
@@ -73,6 +87,7 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
+	td.User = helpers.CurrentUser(r)
 	return td
 }
 
@@ -1,12 +1,27 @@
 package dbrepo
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
+	"math/big"
+	"time"
 
 	"github.com/byt3er/bookings/internals/config"
 	"github.com/byt3er/bookings/internals/repository"
 )
 
+// defaultQueryTimeout is used when no timeout is set in the app config
+const defaultQueryTimeout = 3 * time.Second
+
+// passwordCost is the bcrypt cost of stored passwords, the same as the seeded users
+const passwordCost = 12
+
+// dummyPasswordHash is compared against when nobody has the email address given
+// to Authenticate, so that it takes as long as checking a real password
+const dummyPasswordHash = "$2a$12$3u4piK9hxmoNLfuetz.c6uOt3QX00jMp3MFDIMztT9LpqiX1EjmfW"
+
 type postgresDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
@@ -29,3 +44,57 @@ func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 		App: a,
 	}
 }
+
+// queryTimeout returns how long a single query may run before it is cancelled
+func (m *postgresDBRepo) queryTimeout() time.Duration {
+	if m.App != nil && m.App.DBTimeout > 0 {
+		return m.App.DBTimeout
+	}
+	return defaultQueryTimeout
+}
+
+// bookingCodeAlphabet leaves out characters that are easily mixed up, like 0 and O or 1 and I
+const bookingCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// bookingCodeLength is the number of characters in a booking code
+const bookingCodeLength = 8
+
+// newBookingCode returns a random booking code
+func newBookingCode() (string, error) {
+	max := big.NewInt(int64(len(bookingCodeAlphabet)))
+	code := make([]byte, bookingCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = bookingCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// uniqueBookingCode returns a booking code that no reservation has yet.
+// The unique index on reservations.code still catches two inserts racing for the same code
+func uniqueBookingCode(ctx context.Context, q queryRower) (string, error) {
+	for i := 0; i < 5; i++ {
+		code, err := newBookingCode()
+		if err != nil {
+			return "", err
+		}
+
+		var exists bool
+		err = q.QueryRowContext(ctx, `select exists(select 1 from reservations where code = $1)`, code).Scan(&exists)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return code, nil
+		}
+	}
+	return "", errors.New("no unused booking code found")
+}
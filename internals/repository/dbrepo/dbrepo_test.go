@@ -0,0 +1,28 @@
+package dbrepo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewBookingCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		code, err := newBookingCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != bookingCodeLength {
+			t.Errorf("expected %d characters but got %q", bookingCodeLength, code)
+		}
+		for _, c := range code {
+			if !strings.ContainsRune(bookingCodeAlphabet, c) {
+				t.Errorf("unexpected character %q in %q", c, code)
+			}
+		}
+		if seen[code] {
+			t.Errorf("got %q twice", code)
+		}
+		seen[code] = true
+	}
+}
@@ -4,34 +4,86 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/byt3er/bookings/internals/models"
+	"github.com/byt3er/bookings/internals/repository"
+	"github.com/byt3er/bookings/internals/throttle"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
-	return true
+// AllUsers returns every user, including the disabled ones
+func (m *postgresDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var users []models.User
+
+	query := `select id, first_name, last_name, email, access_level, disabled_at, totp_enabled_at,
+				created_at, updated_at
+			from users order by last_name, first_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		var disabledAt, twoFactorAt sql.NullTime
+		err = rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.AccessLevel,
+			&disabledAt,
+			&twoFactorAt,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+		)
+		if err != nil {
+			return users, err
+		}
+		u.DisabledAt = disabledAt.Time
+		u.TwoFactorEnabledAt = twoFactorAt.Time
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+	return users, nil
 }
 
 // 	InsertReservation inserts a reservation into the database
 
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
 	// m.DB
 	// m.App.Inproduction
 	// *****************************************
 	// now I have a  much safer and more robust means of
 	// talking to the database.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
+	code, err := uniqueBookingCode(ctx, m.DB)
+	if err != nil {
+		return 0, err
+	}
+
 	var newID int
 	stmt := `insert 
 				into 
 					reservations (
+						code,
 						first_name,
 						last_name,
 						email,
@@ -39,6 +91,9 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 						start_date,
 						end_date,
 						room_id,
+						adults,
+						children,
+						total_price,
 						created_at,
 						updated_at)
 					values( $1,
@@ -49,8 +104,13 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 							$6,
 							$7,
 							$8,
-							$9) returning id`
-	err := m.DB.QueryRowContext(ctx, stmt,
+							$9,
+							$10,
+							$11,
+							$12,
+							$13) returning id`
+	err = m.DB.QueryRowContext(ctx, stmt,
+		code,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -58,6 +118,9 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.Adults,
+		res.Children,
+		res.TotalPrice,
 		time.Now(),
 		time.Now()).Scan(&newID)
 
@@ -70,9 +133,9 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 }
 
 // InsertRoomRestriction inserts a room restriction into the database
-func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
+func (m *postgresDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
 	stmt := `insert into room_restrictions(start_date,end_date,room_id,
@@ -95,14 +158,183 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+// InsertReservationWithRestriction checks availability, then inserts a reservation
+// and its room restriction in a single transaction, returning the new ID and booking code.
+// It returns repository.ErrRoomNotAvailable if the dates were taken in the meantime
+func (m *postgresDBRepo) InsertReservationWithRestriction(ctx context.Context, res models.Reservation) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	// rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// lock the room row, so two guests booking the same room
+	// are handled one after the other
+	var active bool
+	var capacity int
+	err = tx.QueryRowContext(ctx, `select active, capacity from rooms where id = $1 for update`,
+		res.RoomID).Scan(&active, &capacity)
+	if err != nil {
+		return 0, "", err
+	}
+	if !active {
+		return 0, "", repository.ErrRoomNotAvailable
+	}
+	if res.Adults+res.Children > capacity {
+		return 0, "", repository.ErrTooManyGuests
+	}
+
+	numRows, err := countOverlappingRestrictions(ctx, tx, res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		return 0, "", err
+	}
+	if numRows > 0 {
+		return 0, "", repository.ErrRoomNotAvailable
+	}
+
+	// count the promo code, unless it was used up in the meantime
+	if res.PromoCodeID > 0 {
+		// locking the promo code makes reservations using it wait for each other
+		var maxRedemptions int
+		err = tx.QueryRowContext(ctx,
+			`select max_redemptions from promo_codes where id = $1 for update`,
+			res.PromoCodeID).Scan(&maxRedemptions)
+		if err != nil {
+			return 0, "", err
+		}
+		redemptions, err := countPromoRedemptions(ctx, tx, res.PromoCodeID)
+		if err != nil {
+			return 0, "", err
+		}
+		if maxRedemptions > 0 && redemptions >= maxRedemptions {
+			return 0, "", repository.ErrPromoCodeExhausted
+		}
+		_, err = tx.ExecContext(ctx,
+			`update promo_codes set redemptions = $1, updated_at = $2 where id = $3`,
+			redemptions+1, time.Now(), res.PromoCodeID)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	code, err := uniqueBookingCode(ctx, tx)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var newID int
+	stmt := `insert into reservations (code, first_name, last_name, email, phone, start_date,
+				end_date, room_id, adults, children, total_price, discount, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		code,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		res.Adults,
+		res.Children,
+		res.TotalPrice,
+		res.Discount,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if res.PromoCodeID > 0 {
+		stmt = `insert into promo_redemptions (promo_code_id, reservation_id, discount, created_at, updated_at)
+				values ($1, $2, $3, $4, $5)`
+		_, err = tx.ExecContext(ctx, stmt, res.PromoCodeID, newID, res.Discount, time.Now(), time.Now())
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	// restriction_id 1 is a reservation
+	stmt = `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+				created_at, updated_at, restriction_id)
+				values ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		time.Now(),
+		time.Now(),
+		1,
+	)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	return newID, code, nil
+}
+
+// countPromoRedemptions returns how many reservations use promo code promoCodeID,
+// leaving out the ones that gave it back when they were cancelled
+func countPromoRedemptions(ctx context.Context, q queryRower, promoCodeID int) (int, error) {
+	var numRows int
+	query := `
+		select
+			count(id)
+		from
+			promo_redemptions
+		where
+			promo_code_id = $1
+			and
+			released_at is null`
+	err := q.QueryRowContext(ctx, query, promoCodeID).Scan(&numRows)
+	return numRows, err
+}
+
+// countOverlappingRestrictions is the overlap check of SearchAvailabilityByDatesByRoomID,
+// for use inside a transaction
+func countOverlappingRestrictions(ctx context.Context, q queryRower, roomID int, start, end time.Time) (int, error) {
+	var numRows int
+	query := `
+		select
+			count(id)
+		from
+			room_restrictions
+		where
+			room_id = $1
+			and
+			$2 < end_date and $3 > start_date`
+	err := q.QueryRowContext(ctx, query, roomID, start, end).Scan(&numRows)
+	return numRows, err
+}
+
 // SearchAvailabilityByDatesByRoomID return true if availability exists for room id,
 // and return false if no availability exists
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
 	log.Println("*************")
 	log.Println(start, end, roomID)
 	log.Println("**************")
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
+
+	// a room that has been taken out of service is never available
+	var active bool
+	err := m.DB.QueryRowContext(ctx, `select active from rooms where id = $1`, roomID).Scan(&active)
+	if err != nil {
+		return false, err
+	}
+	if !active {
+		return false, nil
+	}
+
 	query := `
 		select 
 			count(id)
@@ -118,7 +350,7 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 	var numRows int
 
 	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
-	err := row.Scan(&numRows)
+	err = row.Scan(&numRows)
 
 	if err != nil {
 		return false, err
@@ -133,23 +365,23 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 }
 
 //SearchAvailabilityForAllRooms return slice of available rooms, if any
-// , for given date range
+// , for given date range and big enough for the number of guests
 // search for availability not for a given room, but all rooms
 // return whether there is availability and also returns the
 // actual rooms for which there is availablity, if any for a given date range
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int) ([]models.Room, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
 	var rooms []models.Room
 	query := `select 
-				r.id, r.room_name
+				r.id, r.room_name, r.slug, r.capacity, r.base_rate, r.weekend_rate
 			from
 				rooms r
-			where r.id not in
+			where r.active = true and r.capacity >= $3 and r.id not in
 			( select rr.room_id from room_restrictions rr where $1 < rr.end_date  and $2 > rr.start_date)`
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, guests)
 	if err != nil {
 		return rooms, err
 	}
@@ -159,6 +391,10 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 		err := rows.Scan(
 			&room.ID,
 			&room.RoomName,
+			&room.Slug,
+			&room.Capacity,
+			&room.BaseRate,
+			&room.WeekendRate,
 		)
 		rooms = append(rooms, room)
 		if err != nil {
@@ -173,38 +409,164 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 }
 
 // GetRoomByID gets a room by id
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
 	var room models.Room
+	var amenities string
 
-	query := `select id, room_name, created_at, updated_at from rooms where id=$1`
+	query := `select id, room_name, slug, description, amenities, capacity, base_rate, weekend_rate, active,
+				created_at, updated_at
+				from rooms where id=$1`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&amenities,
+		&room.Capacity,
+		&room.BaseRate,
+		&room.WeekendRate,
+		&room.Active,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+
+	if err != nil {
+		return room, err
+	}
+	room.Amenities = models.SplitLines(amenities)
+
+	room.Photos, err = m.GetPhotosForRoom(ctx, room.ID)
+	if err != nil {
+		return room, err
+	}
+	return room, nil
+}
+
+// GetRoomBySlug gets a room and its photos by slug
+func (m *postgresDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var room models.Room
+	var amenities string
+
+	query := `select id, room_name, slug, description, amenities, capacity, base_rate, weekend_rate, active,
+				created_at, updated_at
+				from rooms where slug = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, slug)
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&amenities,
+		&room.Capacity,
+		&room.BaseRate,
+		&room.WeekendRate,
+		&room.Active,
 		&room.CreatedAt,
 		&room.UpdatedAt,
 	)
+	if err != nil {
+		return room, err
+	}
+	room.Amenities = models.SplitLines(amenities)
 
+	room.Photos, err = m.GetPhotosForRoom(ctx, room.ID)
 	if err != nil {
 		return room, err
 	}
 	return room, nil
 }
 
+// GetPhotosForRoom returns the photos of a room in display order
+func (m *postgresDBRepo) GetPhotosForRoom(ctx context.Context, roomID int) ([]models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var photos []models.RoomPhoto
+
+	query := `select id, room_id, file_name, caption, sort_order, created_at, updated_at
+				from room_photos where room_id = $1 order by sort_order, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return photos, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.RoomPhoto
+		err = rows.Scan(
+			&p.ID,
+			&p.RoomID,
+			&p.FileName,
+			&p.Caption,
+			&p.SortOrder,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return photos, err
+		}
+		photos = append(photos, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return photos, err
+	}
+	return photos, nil
+}
+
+// replaceRoomPhotos replaces all photos of a room, in the transaction the room is saved in
+func replaceRoomPhotos(ctx context.Context, tx *sql.Tx, roomID int, photos []models.RoomPhoto) error {
+	_, err := tx.ExecContext(ctx, `delete from room_photos where room_id = $1`, roomID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into room_photos (room_id, file_name, caption, sort_order, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6)`
+	for i, p := range photos {
+		_, err = tx.ExecContext(ctx, stmt, roomID, p.FileName, p.Caption, i, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 //GetUserByID returns a user by id
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
-				from users where id = $1`
-	row := m.DB.QueryRowContext(ctx, query, id)
+	return m.getUser(ctx, "id = $1", id)
+}
+
+// GetUserByEmail returns the user with an email address, ignoring case
+func (m *postgresDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	return m.getUser(ctx, "lower(email) = lower($1)", email)
+}
+
+// getUser returns the user matching where, which has a single parameter arg
+func (m *postgresDBRepo) getUser(ctx context.Context, where string, arg interface{}) (models.User, error) {
+	query := `select id, first_name, last_name, email, password, access_level, disabled_at, totp_enabled_at,
+					created_at, updated_at
+				from users where ` + where
+	row := m.DB.QueryRowContext(ctx, query, arg)
 
 	var u models.User
+	var disabledAt, twoFactorAt sql.NullTime
 	err := row.Scan(
 		&u.ID,
 		&u.FirstName,
@@ -212,6 +574,8 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 		&u.Email,
 		&u.Password,
 		&u.AccessLevel,
+		&disabledAt,
+		&twoFactorAt,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
@@ -219,21 +583,63 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 	if err != nil {
 		return u, err
 	}
+	u.DisabledAt = disabledAt.Time
+	u.TwoFactorEnabledAt = twoFactorAt.Time
 	return u, nil
+}
+
+// InsertUser adds a user, storing a bcrypt hash of password
+func (m *postgresDBRepo) InsertUser(ctx context.Context, u models.User, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return 0, err
+	}
 
+	query := `insert into users (first_name, last_name, email, password, access_level, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var id int
+	err = m.DB.QueryRowContext(ctx, query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		string(hash),
+		u.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
 // UpdateUser update the user in the database
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if models.Role(u.AccessLevel) != models.RoleOwner {
+		if err = checkOtherOwners(ctx, tx, u.ID); err != nil {
+			return err
+		}
+	}
+
 	query := `
 		update users set first_name = $1, last_name = $2, email =$3, access_level = $4, updated_at = $5
 		where id = $6
 	`
 	// execute the query using our appropriate method from the database pool.
-	_, err := m.DB.ExecContext(ctx, query,
+	_, err = tx.ExecContext(ctx, query,
 		u.FirstName,
 		u.LastName,
 		u.Email,
@@ -244,336 +650,2244 @@ func (m *postgresDBRepo) UpdateUser(u models.User) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
-// Authenticate authenticate a user
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdatePassword replaces the password of a user with a bcrypt hash of password
+func (m *postgresDBRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	// create two variable to hold information from the database
-
-	// hold the ID of the authenticated user if this return as the way they should
-	var id int
-	// hold the hashed password
-	var hashedPassword string
-
-	// query to find if the user exsists
-	row := m.DB.QueryRowContext(ctx,
-		"select id, password from users where email =$1",
-		email,
-	)
-	err := row.Scan(&id, &hashedPassword)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
 	if err != nil {
-		return id, "", err
+		return err
 	}
 
-	// if the email is valid ,it exists in the database
-	// now compare their password with mypassword
+	query := `update users set password = $1, updated_at = $2 where id = $3`
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
-	if err == bcrypt.ErrMismatchedHashAndPassword { // password don't match
-		return 0, "", errors.New("incorrect password")
-	} else if err != nil { // server error
-		return 0, "", err
+	_, err = m.DB.ExecContext(ctx, query, string(hash), time.Now(), id)
+	if err != nil {
+		return err
 	}
-	return id, hashedPassword, nil
+	return nil
 }
 
-// AllReservation returns a slice of all reservations
-func (m *postgresDBRepo) AllReservation() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdateActiveForUser enables or disables a user; disabled users can't log in
+func (m *postgresDBRepo) UpdateActiveForUser(ctx context.Context, id int, active bool) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	var reservations []models.Reservation
-
-	query := `
-		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-			r.end_date, r.room_id, r.created_at, r.updated_at, 
-			rm.id, rm.room_name
-		from
-			reservations r left join rooms rm 
-		on (r.room_id = rm.id)
-		order by r.start_date asc
-	`
-	rows, err := m.DB.QueryContext(ctx, query)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return reservations, err
+		return err
 	}
-	defer rows.Close() // other wise gone have a memory leak
+	defer tx.Rollback()
 
-	for rows.Next() {
-		var i models.Reservation
-		err := rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomID,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Room.ID,
-			&i.Room.RoomName,
-		)
-		if err != nil {
-			return reservations, err
+	var disabledAt sql.NullTime
+	if !active {
+		if err = checkOtherOwners(ctx, tx, id); err != nil {
+			return err
 		}
-		reservations = append(reservations, i)
+		disabledAt = sql.NullTime{Time: time.Now(), Valid: true}
 	}
-	if err := rows.Err(); err != nil {
-		return reservations, err
+
+	query := `update users set disabled_at = $1, updated_at = $2 where id = $3`
+
+	_, err = tx.ExecContext(ctx, query, disabledAt, time.Now(), id)
+	if err != nil {
+		return err
 	}
-	return reservations, nil
+	return tx.Commit()
 }
 
-// NewReservation returns a slice of all reservations
-func (m *postgresDBRepo) AllNewReservation() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertPasswordReset stores a password reset request
+func (m *postgresDBRepo) InsertPasswordReset(ctx context.Context, p models.PasswordReset) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `insert into password_resets (user_id, token_hash, expires_at, created_at, updated_at)
+			values ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, query, p.UserID, p.TokenHash, p.ExpiresAt, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetPasswordReset returns the password reset request with a token hash
+func (m *postgresDBRepo) GetPasswordReset(ctx context.Context, tokenHash string) (models.PasswordReset, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select id, user_id, token_hash, expires_at, used_at, created_at
+			from password_resets where token_hash = $1`
+
+	var p models.PasswordReset
+	var usedAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, tokenHash).Scan(
+		&p.ID,
+		&p.UserID,
+		&p.TokenHash,
+		&p.ExpiresAt,
+		&usedAt,
+		&p.CreatedAt,
+	)
+	if err != nil {
+		return p, err
+	}
+	p.UsedAt = usedAt.Time
+	return p, nil
+}
+
+// ResetPassword sets a new password for the user of a usable reset token, and uses up
+// every reset token of that user. It returns the id of the user, or
+// repository.ErrInvalidResetToken when the token can't be used.
+func (m *postgresDBRepo) ResetPassword(ctx context.Context, tokenHash, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock the request, so a token can't be used twice at the same time
+	query := `
+		select p.id, p.user_id, p.token_hash, p.expires_at, p.used_at, p.created_at
+		from password_resets p join users u on (u.id = p.user_id)
+		where p.token_hash = $1 and u.disabled_at is null
+		for update of p`
+
+	var p models.PasswordReset
+	var usedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, query, tokenHash).Scan(
+		&p.ID,
+		&p.UserID,
+		&p.TokenHash,
+		&p.ExpiresAt,
+		&usedAt,
+		&p.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, repository.ErrInvalidResetToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	p.UsedAt = usedAt.Time
+	if !p.Usable(time.Now()) {
+		return 0, repository.ErrInvalidResetToken
+	}
+
+	_, err = tx.ExecContext(ctx, `update users set password = $1, updated_at = $2 where id = $3`,
+		string(hash), time.Now(), p.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update password_resets set used_at = $1, updated_at = $1
+			where user_id = $2 and used_at is null`, time.Now(), p.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	return p.UserID, tx.Commit()
+}
+
+// checkOtherOwners returns repository.ErrLastOwner when user id is the only active owner,
+// so they must stay one. The owners stay locked until tx ends, so two owners can't
+// demote each other at the same time.
+func checkOtherOwners(ctx context.Context, tx *sql.Tx, id int) error {
+	query := `select id from users where access_level = $1 and disabled_at is null for update`
+
+	rows, err := tx.QueryContext(ctx, query, int(models.RoleOwner))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	isOwner := false
+	others := 0
+	for rows.Next() {
+		var ownerID int
+		if err = rows.Scan(&ownerID); err != nil {
+			return err
+		}
+		if ownerID == id {
+			isOwner = true
+		} else {
+			others++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if isOwner && others == 0 {
+		return repository.ErrLastOwner
+	}
+	return nil
+}
+
+// Authenticate authenticate a user. An unknown or disabled email address and a wrong
+// password both return repository.ErrInvalidCredentials, after the same amount of work.
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	// create two variable to hold information from the database
+
+	// hold the ID of the authenticated user if this return as the way they should
+	var id int
+	// hold the hashed password
+	var hashedPassword string
+
+	// query to find if the user exsists
+	row := m.DB.QueryRowContext(ctx,
+		"select id, password from users where lower(email) = lower($1) and disabled_at is null",
+		email,
+	)
+	err := row.Scan(&id, &hashedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		// still check a password, so that unknown addresses don't answer faster
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(testPassword))
+		return 0, "", repository.ErrInvalidCredentials
+	}
+	if err != nil {
+		return id, "", err
+	}
+
+	// if the email is valid ,it exists in the database
+	// now compare their password with mypassword
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword { // password don't match
+		return 0, "", repository.ErrInvalidCredentials
+	} else if err != nil { // server error
+		return 0, "", err
+	}
+	return id, hashedPassword, nil
+}
+
+// GetTwoFactor returns the two-factor login secret of a user, with how many
+// recovery codes they have left. Secret is empty until it has been set up.
+func (m *postgresDBRepo) GetTwoFactor(ctx context.Context, userID int) (models.TwoFactor, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `
+		select u.id, coalesce(u.totp_secret, ''), u.totp_enabled_at, u.totp_last_step,
+			(select count(*) from recovery_codes c where c.user_id = u.id and c.used_at is null)
+		from users u where u.id = $1`
+
+	var f models.TwoFactor
+	var enabledAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&f.UserID,
+		&f.Secret,
+		&enabledAt,
+		&f.LastStep,
+		&f.RecoveryCodesLeft,
+	)
+	if err != nil {
+		return f, err
+	}
+	f.EnabledAt = enabledAt.Time
+	return f, nil
+}
+
+// EnableTwoFactor turns on two-factor login for a user with a secret that has been
+// checked with a code from step, and gives them new recovery codes
+func (m *postgresDBRepo) EnableTwoFactor(ctx context.Context, userID int, secret string, step int64, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update users set totp_secret = $1, totp_enabled_at = $2, totp_last_step = $3, updated_at = $2
+			where id = $4`
+
+	_, err = tx.ExecContext(ctx, query, secret, time.Now(), step, userID)
+	if err != nil {
+		return err
+	}
+
+	if err = replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTwoFactor turns off two-factor login for a user and forgets their recovery codes
+func (m *postgresDBRepo) DisableTwoFactor(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update users set totp_secret = null, totp_enabled_at = null, totp_last_step = 0, updated_at = $1
+			where id = $2`
+
+	_, err = tx.ExecContext(ctx, query, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	if err = replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTwoFactorCode records that a user logged in with the code of step. It returns
+// repository.ErrInvalidTwoFactorCode when that code, or a later one, was used already.
+func (m *postgresDBRepo) UseTwoFactorCode(ctx context.Context, userID int, step int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `update users set totp_last_step = $1 where id = $2 and totp_last_step < $1`
+
+	result, err := m.DB.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// UseRecoveryCode uses up one of a user's recovery codes. It returns
+// repository.ErrInvalidTwoFactorCode when the code is unknown or was used already.
+func (m *postgresDBRepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `update recovery_codes set used_at = $1, updated_at = $1
+			where user_id = $2 and code_hash = $3 and used_at is null`
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), userID, codeHash)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes gives a user new recovery codes, so the old ones stop working
+func (m *postgresDBRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceRecoveryCodes swaps the recovery codes of a user for codeHashes within tx
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, codeHashes []string) error {
+	_, err := tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into recovery_codes (user_id, code_hash, created_at, updated_at) values ($1, $2, $3, $4)`
+	for _, h := range codeHashes {
+		_, err = tx.ExecContext(ctx, stmt, userID, h, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertUserSession records that a user logged in
+func (m *postgresDBRepo) InsertUserSession(ctx context.Context, s models.UserSession) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `insert into user_sessions (user_id, session_key, ip, user_agent, last_seen_at, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $5, $5)`
+
+	_, err := m.DB.ExecContext(ctx, query, s.UserID, s.Key, s.IP, s.UserAgent, time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// sessionTouchInterval is how long the last use of a session is good enough for,
+// so that not every request writes to the database
+const sessionTouchInterval = time.Minute
+
+// TouchUserSession records that a session was used just now, unless that was recorded
+// less than sessionTouchInterval ago. It returns sql.ErrNoRows when the session has
+// been ended, or belongs to someone else.
+func (m *postgresDBRepo) TouchUserSession(ctx context.Context, key string, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var lastSeen time.Time
+	err := m.DB.QueryRowContext(ctx,
+		`select last_seen_at from user_sessions where session_key = $1 and user_id = $2`,
+		key, userID).Scan(&lastSeen)
+	if err != nil {
+		return err
+	}
+	if time.Since(lastSeen) < sessionTouchInterval {
+		return nil
+	}
+
+	query := `update user_sessions set last_seen_at = $1 where session_key = $2 and user_id = $3`
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), key, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// ended in the meantime
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AllUserSessions returns the sessions of a user, the last used first
+func (m *postgresDBRepo) AllUserSessions(ctx context.Context, userID int) ([]models.UserSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var sessions []models.UserSession
+
+	query := `select id, user_id, session_key, ip, user_agent, created_at, last_seen_at
+			from user_sessions where user_id = $1
+			order by last_seen_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return sessions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.UserSession
+		err = rows.Scan(
+			&s.ID,
+			&s.UserID,
+			&s.Key,
+			&s.IP,
+			&s.UserAgent,
+			&s.CreatedAt,
+			&s.LastSeenAt,
+		)
+		if err != nil {
+			return sessions, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return sessions, err
+	}
+	return sessions, nil
+}
+
+// DeleteUserSession ends one session of a user. It returns sql.ErrNoRows
+// when the user has no such session.
+func (m *postgresDBRepo) DeleteUserSession(ctx context.Context, userID, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from user_sessions where id = $1 and user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserSessionByKey forgets a session when its user logs out
+func (m *postgresDBRepo) DeleteUserSessionByKey(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from user_sessions where session_key = $1`, key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteUserSessions ends every session of a user except the one with exceptKey,
+// which may be empty, and returns how many were ended
+func (m *postgresDBRepo) DeleteUserSessions(ctx context.Context, userID int, exceptKey string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`delete from user_sessions where user_id = $1 and session_key <> $2`,
+		userID, exceptKey,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// DeleteStaleUserSessions forgets the sessions that haven't been used since before,
+// whose session data has expired, and returns how many there were
+func (m *postgresDBRepo) DeleteStaleUserSessions(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from user_sessions where last_seen_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// GetLoginThrottles returns the failed logins counted for an IP address and an email address
+func (m *postgresDBRepo) GetLoginThrottles(ctx context.Context, ip, email string) ([]models.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select id, scope, key, failures, last_failure_at, locked_until, created_at
+			from login_throttles
+			where (scope = $1 and key = $2) or (scope = $3 and key = lower($4))`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.LoginScopeIP, ip, models.LoginScopeAccount, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLoginThrottles(rows)
+}
+
+// RecordLoginFailure counts a failed login for the key of a scope, and locks it out
+// for as long as p says. Failures are forgotten after p.Window without another one.
+func (m *postgresDBRepo) RecordLoginFailure(ctx context.Context, scope, key string, p throttle.Policy) (models.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	if scope == models.LoginScopeAccount {
+		key = strings.ToLower(key)
+	}
+	now := time.Now()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.LoginThrottle{}, err
+	}
+	defer tx.Rollback()
+
+	// the upsert locks the row, so failures happening at the same time are all counted.
+	// The window starts again when a lockout ends, so the next lockout gets longer.
+	query := `
+		insert into login_throttles (scope, key, failures, last_failure_at, created_at, updated_at)
+		values ($1, $2, 1, $3, $3, $3)
+		on conflict (scope, key) do update set
+			failures = case
+				when greatest(login_throttles.last_failure_at, login_throttles.locked_until) < $4 then 1
+				else login_throttles.failures + 1
+			end,
+			last_failure_at = $3,
+			updated_at = $3
+		returning id, scope, key, failures, last_failure_at, created_at`
+
+	var l models.LoginThrottle
+	err = tx.QueryRowContext(ctx, query, scope, key, now, now.Add(-p.Window)).Scan(
+		&l.ID,
+		&l.Scope,
+		&l.Key,
+		&l.Failures,
+		&l.LastFailureAt,
+		&l.CreatedAt,
+	)
+	if err != nil {
+		return l, err
+	}
+
+	if lockout := p.LockoutFor(l.Failures); lockout > 0 {
+		l.LockedUntil = now.Add(lockout)
+		_, err = tx.ExecContext(ctx,
+			`update login_throttles set locked_until = $1 where id = $2`,
+			l.LockedUntil, l.ID,
+		)
+		if err != nil {
+			return l, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return l, err
+	}
+	return l, nil
+}
+
+// ClearLoginFailures forgets the failed logins for the key of a scope
+func (m *postgresDBRepo) ClearLoginFailures(ctx context.Context, scope, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	if scope == models.LoginScopeAccount {
+		key = strings.ToLower(key)
+	}
+
+	_, err := m.DB.ExecContext(ctx, `delete from login_throttles where scope = $1 and key = $2`, scope, key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AllLoginLockouts returns the email and IP addresses that are locked out now,
+// the ones locked out the longest first
+func (m *postgresDBRepo) AllLoginLockouts(ctx context.Context) ([]models.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select id, scope, key, failures, last_failure_at, locked_until, created_at
+			from login_throttles
+			where locked_until > $1
+			order by locked_until desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLoginThrottles(rows)
+}
+
+// UnlockLogin forgets the failed logins of a lockout, so that logins can be tried
+// again straight away, and returns what was forgotten
+func (m *postgresDBRepo) UnlockLogin(ctx context.Context, id int) (models.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `delete from login_throttles where id = $1
+			returning id, scope, key, failures, last_failure_at, locked_until, created_at`
+
+	var l models.LoginThrottle
+	var lockedUntil sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&l.ID,
+		&l.Scope,
+		&l.Key,
+		&l.Failures,
+		&l.LastFailureAt,
+		&lockedUntil,
+		&l.CreatedAt,
+	)
+	if err != nil {
+		return l, err
+	}
+	l.LockedUntil = lockedUntil.Time
+	return l, nil
+}
+
+// scanLoginThrottles reads the rows of a login_throttles query
+func scanLoginThrottles(rows *sql.Rows) ([]models.LoginThrottle, error) {
+	var throttles []models.LoginThrottle
+	for rows.Next() {
+		var l models.LoginThrottle
+		var lockedUntil sql.NullTime
+		err := rows.Scan(
+			&l.ID,
+			&l.Scope,
+			&l.Key,
+			&l.Failures,
+			&l.LastFailureAt,
+			&lockedUntil,
+			&l.CreatedAt,
+		)
+		if err != nil {
+			return throttles, err
+		}
+		l.LockedUntil = lockedUntil.Time
+		throttles = append(throttles, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return throttles, err
+	}
+	return throttles, nil
+}
+
+// InsertOutboxMessage puts msg in the outbox, to be sent straight away
+func (m *postgresDBRepo) InsertOutboxMessage(ctx context.Context, msg models.MailData) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	attachments := msg.Attachments
+	if attachments == nil {
+		attachments = []models.Attachment{}
+	}
+	attached, err := json.Marshal(attachments)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `insert into mail_outbox (to_address, from_address, subject, content, text_content,
+			attachments, status, attempts, next_attempt_at, last_error, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, 0, $8, '', $8, $8) returning id`
+
+	var id int
+	err = m.DB.QueryRowContext(ctx, query,
+		msg.To,
+		msg.From,
+		msg.Subject,
+		msg.Content,
+		msg.Text,
+		string(attached),
+		models.OutboxPending,
+		time.Now(),
+	).Scan(&id)
+	return id, err
+}
+
+// ClaimOutboxMessages returns up to limit messages that are due to be sent, oldest first,
+// and moves them lease into the future so no other worker sends them in the meantime.
+// A message is sent again once the lease has passed if it is never marked sent or failed
+func (m *postgresDBRepo) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	now := time.Now()
+	query := `update mail_outbox set next_attempt_at = $1
+			where id in (
+				select id from mail_outbox
+				where status = $2 and next_attempt_at <= $3
+				order by next_attempt_at, id
+				limit $4
+				for update skip locked
+			)
+			returning ` + outboxColumns
+
+	rows, err := m.DB.QueryContext(ctx, query, now.Add(lease), models.OutboxPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOutboxMessages(rows)
+}
+
+// MarkOutboxSent records that a message was sent
+func (m *postgresDBRepo) MarkOutboxSent(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	now := time.Now()
+	query := `update mail_outbox set status = $1, sent_at = $2, last_error = '', updated_at = $2
+			where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, models.OutboxSent, now, id)
+	return err
+}
+
+// MarkOutboxFailed counts a failed send of a message, and either sends it again
+// at retryAt or gives up on it when dead
+func (m *postgresDBRepo) MarkOutboxFailed(ctx context.Context, id int, lastError string, retryAt time.Time, dead bool) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	status := models.OutboxPending
+	if dead {
+		status = models.OutboxDead
+	}
+
+	query := `update mail_outbox set status = $1, attempts = attempts + 1, next_attempt_at = $2,
+			last_error = $3, updated_at = $4
+			where id = $5`
+
+	_, err := m.DB.ExecContext(ctx, query, status, retryAt, lastError, time.Now(), id)
+	return err
+}
+
+// AllOutboxMessages returns the newest messages in the outbox, or only those
+// with status when status isn't empty
+func (m *postgresDBRepo) AllOutboxMessages(ctx context.Context, status string) ([]models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select ` + outboxColumns + `
+			from mail_outbox
+			where $1 = '' or status = $1
+			order by created_at desc, id desc
+			limit 200`
+
+	rows, err := m.DB.QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOutboxMessages(rows)
+}
+
+// GetOutboxMessage returns a message in the outbox by id
+func (m *postgresDBRepo) GetOutboxMessage(ctx context.Context, id int) (models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select ` + outboxColumns + ` from mail_outbox where id = $1`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return models.OutboxMessage{}, err
+	}
+	defer rows.Close()
+
+	messages, err := scanOutboxMessages(rows)
+	if err != nil {
+		return models.OutboxMessage{}, err
+	}
+	if len(messages) == 0 {
+		return models.OutboxMessage{}, sql.ErrNoRows
+	}
+	return messages[0], nil
+}
+
+// ResendOutboxMessage sends a message that was sent or given up on again, straight away
+// and with all of its attempts. Returns sql.ErrNoRows when the message is already waiting to be sent
+func (m *postgresDBRepo) ResendOutboxMessage(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	now := time.Now()
+	query := `update mail_outbox set status = $1, attempts = 0, next_attempt_at = $2, sent_at = null,
+			updated_at = $2
+			where id = $3 and status <> $1`
+
+	result, err := m.DB.ExecContext(ctx, query, models.OutboxPending, now, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// outboxColumns are the columns scanOutboxMessages reads
+const outboxColumns = `id, to_address, from_address, subject, content, text_content, attachments,
+			status, attempts, next_attempt_at, last_error, sent_at, created_at, updated_at`
+
+// scanOutboxMessages reads the rows of a mail_outbox query
+func scanOutboxMessages(rows *sql.Rows) ([]models.OutboxMessage, error) {
+	var messages []models.OutboxMessage
+	for rows.Next() {
+		var o models.OutboxMessage
+		var sentAt sql.NullTime
+		var attachments string
+		err := rows.Scan(
+			&o.ID,
+			&o.Mail.To,
+			&o.Mail.From,
+			&o.Mail.Subject,
+			&o.Mail.Content,
+			&o.Mail.Text,
+			&attachments,
+			&o.Status,
+			&o.Attempts,
+			&o.NextAttemptAt,
+			&o.LastError,
+			&sentAt,
+			&o.CreatedAt,
+			&o.UpdatedAt,
+		)
+		if err != nil {
+			return messages, err
+		}
+		if err = json.Unmarshal([]byte(attachments), &o.Mail.Attachments); err != nil {
+			return messages, err
+		}
+		o.SentAt = sentAt.Time
+		messages = append(messages, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return messages, err
+	}
+	return messages, nil
+}
+
+// AllReservation returns a slice of all reservations, or only those with status
+// when status isn't empty
+func (m *postgresDBRepo) AllReservation(ctx context.Context, status models.ReservationStatus) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		select r.id, r.code, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+			r.end_date, r.room_id, r.created_at, r.updated_at, r.status,
+			rm.id, rm.room_name
+		from
+			reservations r left join rooms rm 
+		on (r.room_id = rm.id)
+		where r.deleted_at is null and ($1 = '' or r.status = $1)
+		order by r.start_date asc
+	`
+	rows, err := m.DB.QueryContext(ctx, query, string(status))
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close() // other wise gone have a memory leak
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.Code,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Status,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+	if err := rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// AllNewReservation returns a slice of the pending reservations
+func (m *postgresDBRepo) AllNewReservation(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		select r.id, r.code, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+			r.end_date, r.room_id, r.created_at, r.updated_at, r.status,
+			rm.id, rm.room_name
+		from
+			reservations r left join rooms rm 
+		on (r.room_id = rm.id)
+		where r.status = 'pending' and r.deleted_at is null
+		order by r.start_date asc
+	`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close() // other wise gone have a memory leak
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.Code,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Status,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+	if err := rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// GetReservationByID returns one reservation by ID
+func (m *postgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	return m.getReservation(ctx, "r.id = $1", id)
+}
+
+// GetReservationByCode returns one reservation by its booking code,
+// unless it is in the trash
+func (m *postgresDBRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	return m.getReservation(ctx, "r.code = $1 and r.deleted_at is null", code)
+}
+
+// getReservation returns the reservation matching where, with its room and promo code
+func (m *postgresDBRepo) getReservation(ctx context.Context, where string, arg interface{}) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `
+		select r.id, r.code, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+			r.end_date, r.room_id, r.adults, r.children, r.total_price, r.discount,
+			coalesce(pc.id, 0), coalesce(pc.code, ''), r.created_at, r.updated_at, r.status,
+			r.cancelled_at, r.cancellation_fee, r.deleted_at, rm.id, rm.room_name, rm.capacity
+		from
+			reservations r left join rooms rm 
+		on (r.room_id = rm.id)
+			left join promo_redemptions pr on (pr.reservation_id = r.id)
+			left join promo_codes pc on (pc.id = pr.promo_code_id)
+		where ` + where
+	row := m.DB.QueryRowContext(ctx, query, arg)
+
+	var r models.Reservation
+	var cancelledAt, deletedAt sql.NullTime
+	err := row.Scan(
+		&r.ID,
+		&r.Code,
+		&r.FirstName,
+		&r.LastName,
+		&r.Email,
+		&r.Phone,
+		&r.StartDate,
+		&r.EndDate,
+		&r.RoomID,
+		&r.Adults,
+		&r.Children,
+		&r.TotalPrice,
+		&r.Discount,
+		&r.PromoCodeID,
+		&r.PromoCode,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+		&r.Status,
+		&cancelledAt,
+		&r.CancellationFee,
+		&deletedAt,
+		&r.Room.ID,
+		&r.Room.RoomName,
+		&r.Room.Capacity,
+	)
+
+	if err != nil {
+		return r, err
+	}
+	r.CancelledAt = cancelledAt.Time
+	r.DeletedAt = deletedAt.Time
+	return r, nil
+}
+
+// UpdateReservation update a reservation in the database
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, res models.Reservation) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `
+		update reservations set first_name = $1, last_name = $2, email =$3, phone = $4, updated_at = $5
+		where id = $6
+	`
+	// execute the query using our appropriate method from the database pool.
+	_, err := m.DB.ExecContext(ctx, query,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		time.Now(),
+		res.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteReservation moves a reservation to the trash on behalf of user userID.
+// Its room restriction is removed, so the dates can be booked again
+func (m *postgresDBRepo) DeleteReservation(ctx context.Context, id, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var user sql.NullInt64
+	if userID > 0 {
+		user = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	_, err = tx.ExecContext(ctx, `
+		update reservations set deleted_at = $1, deleted_by = $2
+		where id = $3 and deleted_at is null`,
+		time.Now(), user, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RestoreReservation takes a reservation out of the trash. Unless it was cancelled, the room
+// is blocked for its dates again; repository.ErrRoomNotAvailable is returned if they have
+// been booked in the meantime
+func (m *postgresDBRepo) RestoreReservation(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	var start, end time.Time
+	var status string
+	var deletedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		select room_id, start_date, end_date, status, deleted_at
+		from reservations where id = $1 for update`, id).Scan(&roomID, &start, &end, &status, &deletedAt)
+	if err != nil {
+		return err
+	}
+	if !deletedAt.Valid {
+		return nil
+	}
+
+	if models.ReservationStatus(status) != models.StatusCancelled {
+		// lock the room like InsertReservationWithRestriction does
+		_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, roomID)
+		if err != nil {
+			return err
+		}
+
+		numRows, err := countOverlappingRestrictions(ctx, tx, roomID, start, end)
+		if err != nil {
+			return err
+		}
+		if numRows > 0 {
+			return repository.ErrRoomNotAvailable
+		}
+
+		// restriction_id 1 is a reservation
+		_, err = tx.ExecContext(ctx, `
+			insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+				created_at, updated_at, restriction_id)
+			values ($1, $2, $3, $4, $5, $6, $7)`,
+			start, end, roomID, id, time.Now(), time.Now(), 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set deleted_at = null, deleted_by = null where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeReservation deletes a reservation in the trash for good. Reservations with
+// payments are kept, and repository.ErrReservationHasPayments is returned instead
+func (m *postgresDBRepo) PurgeReservation(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the reservation, so no payment is added while we check
+	var resID int
+	err = tx.QueryRowContext(ctx, `select id from reservations where id = $1 for update`, id).Scan(&resID)
+	if err != nil {
+		return err
+	}
+
+	var paid bool
+	err = tx.QueryRowContext(ctx, `select exists(select 1 from payments where reservation_id = $1)`, id).Scan(&paid)
+	if err != nil {
+		return err
+	}
+	if paid {
+		return repository.ErrReservationHasPayments
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from reservations where id = $1 and deleted_at is not null`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeDeletedReservations deletes the reservations moved to the trash before before
+// for good, and returns how many there were. Reservations with payments are kept
+func (m *postgresDBRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `delete from reservations r where r.deleted_at < $1
+			and not exists (select 1 from payments p where p.reservation_id = r.id)`
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// AllDeletedReservations returns the reservations in the trash, most recently deleted first
+func (m *postgresDBRepo) AllDeletedReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
 	var reservations []models.Reservation
 
-	query := `
-		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-			r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-			rm.id, rm.room_name
-		from
-			reservations r left join rooms rm 
-		on (r.room_id = rm.id)
-		where r.processed = 0
-		order by r.start_date asc
-	`
-	rows, err := m.DB.QueryContext(ctx, query)
+	query := `
+		select r.id, r.code, r.first_name, r.last_name, r.start_date, r.end_date, r.status,
+			r.deleted_at, coalesce(r.deleted_by, 0), coalesce(u.first_name || ' ' || u.last_name, ''),
+			rm.id, rm.room_name
+		from
+			reservations r left join rooms rm on (r.room_id = rm.id)
+			left join users u on (u.id = r.deleted_by)
+		where r.deleted_at is not null
+		order by r.deleted_at desc
+	`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.Code,
+			&i.FirstName,
+			&i.LastName,
+			&i.StartDate,
+			&i.EndDate,
+			&i.Status,
+			&i.DeletedAt,
+			&i.DeletedBy,
+			&i.DeletedByName,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+	if err := rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// CancelReservation marks a reservation as cancelled with the fee kept from the guest,
+// and removes its room restriction so the dates can be booked again. A promo code it
+// used counts one redemption less
+func (m *postgresDBRepo) CancelReservation(ctx context.Context, id, fee int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the guest cancels, not a user
+	err = transitionReservation(ctx, tx, id, models.StatusCancelled, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set cancellation_fee = $1 where id = $2`, fee, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TransitionReservation moves a reservation to a new status on behalf of user userID.
+// It returns repository.ErrInvalidTransition if the current status doesn't allow it
+func (m *postgresDBRepo) TransitionReservation(ctx context.Context, id int, to models.ReservationStatus, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = transitionReservation(ctx, tx, id, to, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// transitionReservation checks and makes a status change inside tx and records it
+// in the status history. A cancelled reservation gives up its room restriction and
+// its use of a promo code. userID is 0 for changes made by the guest
+func transitionReservation(ctx context.Context, tx *sql.Tx, id int, to models.ReservationStatus, userID int) error {
+	var from string
+	err := tx.QueryRowContext(ctx, `select status from reservations where id = $1 for update`, id).Scan(&from)
+	if err != nil {
+		return err
+	}
+	if from == string(to) && to == models.StatusCancelled {
+		return repository.ErrReservationCancelled
+	}
+	if !models.ReservationStatus(from).CanTransitionTo(to) {
+		return repository.ErrInvalidTransition
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `update reservations set status = $1, updated_at = $2 where id = $3`,
+		string(to), now, id)
+	if err != nil {
+		return err
+	}
+
+	if to == models.StatusCancelled {
+		_, err = tx.ExecContext(ctx, `update reservations set cancelled_at = $1 where id = $2`, now, id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		// the promo code can be used by someone else instead; the redemption is kept
+		// as released, so the reservation still shows the code it was booked with
+		var promoCodeID int
+		err = tx.QueryRowContext(ctx, `
+			update promo_redemptions set released_at = $1, updated_at = $1
+			where reservation_id = $2 and released_at is null
+			returning promo_code_id`, now, id).Scan(&promoCodeID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// no promo code was used
+		case err != nil:
+			return err
+		default:
+			redemptions, err := countPromoRedemptions(ctx, tx, promoCodeID)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx,
+				`update promo_codes set redemptions = $1, updated_at = $2 where id = $3`,
+				redemptions, now, promoCodeID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var user sql.NullInt64
+	if userID > 0 {
+		user = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	stmt := `insert into reservation_status_history (reservation_id, from_status, to_status, user_id,
+				created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.ExecContext(ctx, stmt, id, from, string(to), user, now, now)
+	return err
+}
+
+// GetStatusHistory returns the status changes of a reservation, oldest first
+func (m *postgresDBRepo) GetStatusHistory(ctx context.Context, reservationID int) ([]models.ReservationStatusChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var changes []models.ReservationStatusChange
+
+	query := `
+		select h.id, h.reservation_id, h.from_status, h.to_status, coalesce(h.user_id, 0),
+			coalesce(u.first_name || ' ' || u.last_name, ''), h.created_at
+		from
+			reservation_status_history h left join users u on (u.id = h.user_id)
+		where h.reservation_id = $1
+		order by h.created_at, h.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.ReservationStatusChange
+		var from, to string
+		err = rows.Scan(
+			&c.ID,
+			&c.ReservationID,
+			&from,
+			&to,
+			&c.UserID,
+			&c.UserName,
+			&c.CreatedAt,
+		)
+		if err != nil {
+			return changes, err
+		}
+		c.FromStatus = models.ReservationStatus(from)
+		c.ToStatus = models.ReservationStatus(to)
+		changes = append(changes, c)
+	}
+	if err = rows.Err(); err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := `select id, room_name, slug, description, amenities, capacity, base_rate, weekend_rate, active,
+				created_at, updated_at
+				from rooms order by room_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rm models.Room
+		var amenities string
+		err = rows.Scan(
+			&rm.ID,
+			&rm.RoomName,
+			&rm.Slug,
+			&rm.Description,
+			&amenities,
+			&rm.Capacity,
+			&rm.BaseRate,
+			&rm.WeekendRate,
+			&rm.Active,
+			&rm.CreatedAt,
+			&rm.UpdatedAt,
+		)
+		if err != nil {
+			return rooms, err
+		}
+		rm.Amenities = models.SplitLines(amenities)
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+	return rooms, nil
+}
+
+// InsertRoom inserts a new room with its photos and returns its id
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, r models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+	stmt := `insert into rooms (room_name, slug, description, amenities, capacity, base_rate, weekend_rate,
+				active, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		r.RoomName,
+		r.Slug,
+		r.Description,
+		strings.Join(r.Amenities, "\n"),
+		r.Capacity,
+		r.BaseRate,
+		r.WeekendRate,
+		r.Active,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = replaceRoomPhotos(ctx, tx, newID, r.Photos); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateRoom updates a room in the database and replaces its photos
+func (m *postgresDBRepo) UpdateRoom(ctx context.Context, r models.Room) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update rooms set room_name = $1, slug = $2, description = $3, amenities = $4, capacity = $5,
+				base_rate = $6, weekend_rate = $7, updated_at = $8 where id = $9`
+
+	_, err = tx.ExecContext(ctx, query,
+		r.RoomName,
+		r.Slug,
+		r.Description,
+		strings.Join(r.Amenities, "\n"),
+		r.Capacity,
+		r.BaseRate,
+		r.WeekendRate,
+		time.Now(),
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err = replaceRoomPhotos(ctx, tx, r.ID, r.Photos); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateActiveForRoom activates or deactivates a room by id
+func (m *postgresDBRepo) UpdateActiveForRoom(ctx context.Context, id int, active bool) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `update rooms set active = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, active, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteRoom deletes a room by id. Rooms with any reservations, past, cancelled or
+// in the trash, can't be deleted, and repository.ErrRoomHasReservations is returned instead
+func (m *postgresDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the room, so no one can book it while we check
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, id).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	var reserved bool
+	query := `select exists(select 1 from reservations where room_id = $1)`
+	err = tx.QueryRowContext(ctx, query, id).Scan(&reserved)
+	if err != nil {
+		return err
+	}
+	if reserved {
+		return repository.ErrRoomHasReservations
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from rooms where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CountFutureReservationsForRoom returns the number of reservations for a room
+// that haven't ended yet
+func (m *postgresDBRepo) CountFutureReservationsForRoom(ctx context.Context, roomID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var numRows int
+	query := `select count(id) from reservations where room_id = $1 and end_date >= current_date`
+	err := m.DB.QueryRowContext(ctx, query, roomID).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	return numRows, nil
+}
+
+// GetRestrictionsForRoomByDate returns restrictions for a room by date range
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	// inside the query "coalesce(reservation_id, 0)"
+	// if reservation id has a value that value is not nul, use it
+	// otherwise use zero
+	query := `
+		select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
+		from room_restrictions where $1 < end_date and $2 >= start_date
+		and room_id = $3
+	`
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.ReservationID, // go also have null int type(note used here)
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+	return restrictions, nil
+}
+
+// InsertBlockForRoom inserts a room restriction
+func (m *postgresDBRepo) InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
+				created_at, updated_at) values($1,$2,$3,$4,$5,$6)`
+	// _, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+	_, err := m.DB.ExecContext(ctx, query, startDate, startDate, id, 2, time.Now(), time.Now())
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// DeleteBlocksByID deletes a room restriction
+func (m *postgresDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `delete from room_restrictions where id = $1`
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// GetSeasonalRatesForRoom returns the seasonal rates of a room that overlap
+// the nights from start to end
+func (m *postgresDBRepo) GetSeasonalRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select id, room_id, name, start_date, end_date, rate, weekend_rate, created_at, updated_at
+				from seasonal_rates
+				where room_id = $1 and start_date < $3 and end_date >= $2
+				order by start_date`
+
+	return m.querySeasonalRates(ctx, query, roomID, start, end)
+}
+
+// AllSeasonalRatesForRoom returns all seasonal rates of a room
+func (m *postgresDBRepo) AllSeasonalRatesForRoom(ctx context.Context, roomID int) ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select id, room_id, name, start_date, end_date, rate, weekend_rate, created_at, updated_at
+				from seasonal_rates
+				where room_id = $1
+				order by start_date`
+
+	return m.querySeasonalRates(ctx, query, roomID)
+}
+
+func (m *postgresDBRepo) querySeasonalRates(ctx context.Context, query string, args ...interface{}) ([]models.SeasonalRate, error) {
+	var seasons []models.SeasonalRate
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return reservations, err
+		return seasons, err
 	}
-	defer rows.Close() // other wise gone have a memory leak
+	defer rows.Close()
 
 	for rows.Next() {
-		var i models.Reservation
-		err := rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomID,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Processed,
-			&i.Room.ID,
-			&i.Room.RoomName,
+		var s models.SeasonalRate
+		err = rows.Scan(
+			&s.ID,
+			&s.RoomID,
+			&s.Name,
+			&s.StartDate,
+			&s.EndDate,
+			&s.Rate,
+			&s.WeekendRate,
+			&s.CreatedAt,
+			&s.UpdatedAt,
 		)
 		if err != nil {
-			return reservations, err
+			return seasons, err
 		}
-		reservations = append(reservations, i)
+		seasons = append(seasons, s)
 	}
-	if err := rows.Err(); err != nil {
-		return reservations, err
+
+	if err = rows.Err(); err != nil {
+		return seasons, err
 	}
-	return reservations, nil
+	return seasons, nil
 }
 
-// GetReservationByID returns on reservation by ID
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertSeasonalRate inserts a seasonal rate and returns its id
+func (m *postgresDBRepo) InsertSeasonalRate(ctx context.Context, s models.SeasonalRate) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	query := `
-		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-			r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-			rm.id, rm.room_name
-		from
-			reservations r left join rooms rm 
-		on (r.room_id = rm.id)
-		where r.ID = $1
-	`
-	row := m.DB.QueryRowContext(ctx, query, id)
+	var newID int
+	stmt := `insert into seasonal_rates (room_id, name, start_date, end_date, rate, weekend_rate,
+				created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		s.RoomID,
+		s.Name,
+		s.StartDate,
+		s.EndDate,
+		s.Rate,
+		s.WeekendRate,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
 
-	var r models.Reservation
+// DeleteSeasonalRate deletes a seasonal rate by id
+func (m *postgresDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from seasonal_rates where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// promoCodeColumns are the columns read by scanPromoCode
+const promoCodeColumns = `id, code, description, discount_type, amount, valid_from, valid_to,
+				min_nights, max_redemptions, redemptions, active, created_at, updated_at`
+
+// scanPromoCode scans a row selected with promoCodeColumns
+func scanPromoCode(row interface{ Scan(...interface{}) error }) (models.PromoCode, error) {
+	var p models.PromoCode
 	err := row.Scan(
-		&r.ID,
-		&r.FirstName,
-		&r.LastName,
-		&r.Email,
-		&r.Phone,
-		&r.StartDate,
-		&r.EndDate,
-		&r.RoomID,
-		&r.CreatedAt,
-		&r.UpdatedAt,
-		&r.Processed,
-		&r.Room.ID,
-		&r.Room.RoomName,
+		&p.ID,
+		&p.Code,
+		&p.Description,
+		&p.DiscountType,
+		&p.Amount,
+		&p.ValidFrom,
+		&p.ValidTo,
+		&p.MinNights,
+		&p.MaxRedemptions,
+		&p.Redemptions,
+		&p.Active,
+		&p.CreatedAt,
+		&p.UpdatedAt,
 	)
+	return p, err
+}
+
+// AllPromoCodes returns all promo codes, newest first
+func (m *postgresDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var codes []models.PromoCode
 
+	rows, err := m.DB.QueryContext(ctx, `select `+promoCodeColumns+` from promo_codes order by valid_from desc, code`)
 	if err != nil {
-		return r, err
+		return codes, err
 	}
-	return r, nil
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPromoCode(rows)
+		if err != nil {
+			return codes, err
+		}
+		codes = append(codes, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return codes, err
+	}
+	return codes, nil
 }
 
-// UpdateReservation update a reservation in the database
-func (m *postgresDBRepo) UpdateReservation(res models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetPromoCodeByID returns a promo code and the rooms it applies to by id
+func (m *postgresDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	query := `
-		update reservations set first_name = $1, last_name = $2, email =$3, phone = $4, updated_at = $5
-		where id = $6
-	`
-	// execute the query using our appropriate method from the database pool.
-	_, err := m.DB.ExecContext(ctx, query,
-		res.FirstName,
-		res.LastName,
-		res.Email,
-		res.Phone,
+	p, err := scanPromoCode(m.DB.QueryRowContext(ctx, `select `+promoCodeColumns+` from promo_codes where id = $1`, id))
+	if err != nil {
+		return p, err
+	}
+
+	p.RoomIDs, err = m.roomIDsForPromoCode(ctx, p.ID)
+	return p, err
+}
+
+// GetPromoCodeByCode returns a promo code and the rooms it applies to by code,
+// ignoring case
+func (m *postgresDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	p, err := scanPromoCode(m.DB.QueryRowContext(ctx,
+		`select `+promoCodeColumns+` from promo_codes where upper(code) = upper($1)`, code))
+	if err != nil {
+		return p, err
+	}
+
+	p.RoomIDs, err = m.roomIDsForPromoCode(ctx, p.ID)
+	return p, err
+}
+
+func (m *postgresDBRepo) roomIDsForPromoCode(ctx context.Context, id int) ([]int, error) {
+	var ids []int
+
+	rows, err := m.DB.QueryContext(ctx, `select room_id from promo_code_rooms where promo_code_id = $1 order by room_id`, id)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomID int
+		if err = rows.Scan(&roomID); err != nil {
+			return ids, err
+		}
+		ids = append(ids, roomID)
+	}
+	return ids, rows.Err()
+}
+
+// InsertPromoCode inserts a promo code with the rooms it applies to and returns its id
+func (m *postgresDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+	stmt := `insert into promo_codes (code, description, discount_type, amount, valid_from, valid_to,
+				min_nights, max_redemptions, active, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		p.Code,
+		p.Description,
+		p.DiscountType,
+		p.Amount,
+		p.ValidFrom,
+		p.ValidTo,
+		p.MinNights,
+		p.MaxRedemptions,
+		p.Active,
 		time.Now(),
-		res.ID,
-	)
+		time.Now(),
+	).Scan(&newID)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+
+	if err = insertPromoCodeRooms(ctx, tx, newID, p.RoomIDs); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return newID, nil
 }
 
-// DeleteReservation delete one reservation by id
-func (m *postgresDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdatePromoCode updates a promo code and replaces the rooms it applies to.
+// The number of redemptions is never changed here
+func (m *postgresDBRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	query := `delete from reservations where id = $1`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update promo_codes set code = $1, description = $2, discount_type = $3, amount = $4,
+				valid_from = $5, valid_to = $6, min_nights = $7, max_redemptions = $8, active = $9,
+				updated_at = $10
+				where id = $11`
+	_, err = tx.ExecContext(ctx, stmt,
+		p.Code,
+		p.Description,
+		p.DiscountType,
+		p.Amount,
+		p.ValidFrom,
+		p.ValidTo,
+		p.MinNights,
+		p.MaxRedemptions,
+		p.Active,
+		time.Now(),
+		p.ID,
+	)
+	if err != nil {
+		return err
+	}
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	_, err = tx.ExecContext(ctx, `delete from promo_code_rooms where promo_code_id = $1`, p.ID)
 	if err != nil {
 		return err
 	}
+	if err = insertPromoCodeRooms(ctx, tx, p.ID, p.RoomIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertPromoCodeRooms(ctx context.Context, tx *sql.Tx, promoCodeID int, roomIDs []int) error {
+	stmt := `insert into promo_code_rooms (promo_code_id, room_id, created_at, updated_at)
+				values ($1, $2, $3, $4)`
+	for _, roomID := range roomIDs {
+		_, err := tx.ExecContext(ctx, stmt, promoCodeID, roomID, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// UpdateProcessedForReservation updates processed for a reservation by id
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// DeletePromoCode deletes a promo code by id
+func (m *postgresDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	query := `update reservations set processed = $1 where id = $2`
-
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	_, err := m.DB.ExecContext(ctx, `delete from promo_codes where id = $1`, id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertPayment records a card transaction of a reservation
+func (m *postgresDBRepo) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	var rooms []models.Room
+	var newID int
+	stmt := `insert into payments (reservation_id, provider, transaction_id, amount, currency, status,
+				card_last4, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.ReservationID,
+		p.Provider,
+		p.TransactionID,
+		p.Amount,
+		p.Currency,
+		p.Status,
+		p.CardLast4,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// GetPaymentsForReservation returns the payment records of a reservation, oldest first
+func (m *postgresDBRepo) GetPaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
 
-	query := `select id, room_name, created_at, updated_at from rooms order by room_name`
+	var payments []models.Payment
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	query := `select id, reservation_id, provider, transaction_id, amount, refunded, currency, status, card_last4,
+				created_at, updated_at
+				from payments where reservation_id = $1 order by created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
 	if err != nil {
-		return rooms, err
+		return payments, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var rm models.Room
+		var p models.Payment
 		err = rows.Scan(
-			&rm.ID,
-			&rm.RoomName,
-			&rm.CreatedAt,
-			&rm.UpdatedAt,
+			&p.ID,
+			&p.ReservationID,
+			&p.Provider,
+			&p.TransactionID,
+			&p.Amount,
+			&p.Refunded,
+			&p.Currency,
+			&p.Status,
+			&p.CardLast4,
+			&p.CreatedAt,
+			&p.UpdatedAt,
 		)
 		if err != nil {
-			return rooms, err
+			return payments, err
 		}
-		rooms = append(rooms, rm)
+		payments = append(payments, p)
 	}
 
 	if err = rows.Err(); err != nil {
-		return rooms, err
+		return payments, err
 	}
-	return rooms, nil
+	return payments, nil
 }
 
-// GetRestrictionsForRoomByDate returns restrictions for a room by date range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdatePayment saves the status and refunded amount of a payment
+func (m *postgresDBRepo) UpdatePayment(ctx context.Context, p models.Payment) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	var restrictions []models.RoomRestriction
+	query := `update payments set status = $1, refunded = $2, updated_at = $3 where id = $4`
+
+	_, err := m.DB.ExecContext(ctx, query, p.Status, p.Refunded, time.Now(), p.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// InsertAuditEntry records a change made in the admin tool
+func (m *postgresDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return err
+	}
+
+	var userID sql.NullInt64
+	if e.UserID > 0 {
+		userID = sql.NullInt64{Int64: int64(e.UserID), Valid: true}
+	}
+
+	query := `insert into audit_log (user_id, action, entity, entity_id, changes, created_at)
+			values ($1, $2, $3, $4, $5, $6)`
+
+	_, err = m.DB.ExecContext(ctx, query, userID, e.Action, e.Entity, e.EntityID, string(changes), time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AllAuditEntries returns the newest entries of the audit log that match f
+func (m *postgresDBRepo) AllAuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var entries []models.AuditEntry
+
+	// the date filter covers whole days
+	var from, to interface{}
+	if !f.From.IsZero() {
+		from = f.From
+	}
+	if !f.To.IsZero() {
+		to = f.To.AddDate(0, 0, 1)
+	}
 
-	// inside the query "coalesce(reservation_id, 0)"
-	// if reservation id has a value that value is not nul, use it
-	// otherwise use zero
 	query := `
-		select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
-		from room_restrictions where $1 < end_date and $2 >= start_date
-		and room_id = $3
-	`
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+		select a.id, coalesce(a.user_id, 0), coalesce(u.first_name || ' ' || u.last_name, ''),
+			a.action, a.entity, a.entity_id, a.changes, a.created_at
+		from
+			audit_log a left join users u on (u.id = a.user_id)
+		where ($1 = 0 or a.user_id = $1)
+			and ($2 = '' or a.entity = $2)
+			and ($3::timestamp is null or a.created_at >= $3)
+			and ($4::timestamp is null or a.created_at < $4)
+		order by a.created_at desc, a.id desc
+		limit 500`
+
+	rows, err := m.DB.QueryContext(ctx, query, f.UserID, f.Entity, from, to)
 	if err != nil {
-		return restrictions, err
+		return entries, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var r models.RoomRestriction
-		err := rows.Scan(
-			&r.ID,
-			&r.ReservationID, // go also have null int type(note used here)
-			&r.RestrictionID,
-			&r.RoomID,
-			&r.StartDate,
-			&r.EndDate,
+		var e models.AuditEntry
+		var changes string
+		err = rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.UserName,
+			&e.Action,
+			&e.Entity,
+			&e.EntityID,
+			&changes,
+			&e.CreatedAt,
 		)
 		if err != nil {
-			return restrictions, err
+			return entries, err
 		}
-		restrictions = append(restrictions, r)
+		if err = json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
 	}
+
 	if err = rows.Err(); err != nil {
-		return restrictions, err
+		return entries, err
 	}
-	return restrictions, nil
+	return entries, nil
 }
 
-// InsertBlockForRoom inserts a room restriction
-func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// AllAuditUsers returns the users that appear in the audit log
+func (m *postgresDBRepo) AllAuditUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
-				created_at, updated_at) values($1,$2,$3,$4,$5,$6)`
-	// _, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate, id, 2, time.Now(), time.Now())
+	var users []models.User
+
+	query := `
+		select u.id, u.first_name, u.last_name
+		from users u
+		where exists (select 1 from audit_log a where a.user_id = u.id)
+		order by u.last_name, u.first_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
-		log.Println(err)
-		return err
+		return users, err
 	}
-	return nil
-}
+	defer rows.Close()
 
-// DeleteBlocksByID deletes a room restriction
-func (m *postgresDBRepo) DeleteBlockByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	for rows.Next() {
+		var u models.User
+		err = rows.Scan(&u.ID, &u.FirstName, &u.LastName)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
 
-	query := `delete from room_restrictions where id = $1`
-	_, err := m.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		log.Println(err)
-		return err
+	if err = rows.Err(); err != nil {
+		return users, err
 	}
-	return nil
+	return users, nil
 }
@@ -1,20 +1,32 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/byt3er/bookings/internals/models"
+	"github.com/byt3er/bookings/internals/repository"
+	"github.com/byt3er/bookings/internals/signer"
+	"github.com/byt3er/bookings/internals/throttle"
 )
 
-func (m *testDBRepo) AllUsers() bool {
-	return true
+// AllUsers returns every user, including the disabled ones
+func (m *testDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	for _, id := range []int{1, 2, 3} {
+		u, _ := m.GetUserByID(ctx, id)
+		users = append(users, u)
+	}
+	return users, nil
 }
 
 // 	InsertReservation inserts a reservation into the database
 
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *testDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
 	// m.DB
 	if res.RoomID == 1 {
 		return 1, nil
@@ -27,7 +39,7 @@ func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 }
 
 // InsertRoomRestriction inserts a room restriction into the database
-func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
+func (m *testDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
 	fmt.Println("roomID:", r.RoomID)
 	if r.ReservationID == 2 {
 		return errors.New("some error")
@@ -35,9 +47,32 @@ func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+// InsertReservationWithRestriction inserts a reservation and its room restriction
+func (m *testDBRepo) InsertReservationWithRestriction(ctx context.Context, res models.Reservation) (int, string, error) {
+	switch {
+	case res.PromoCodeID == 3:
+		// the last redemption was taken by someone else
+		return 0, "", repository.ErrPromoCodeExhausted
+	case res.Adults+res.Children > 4:
+		// the room is smaller than the guest thought
+		return 0, "", repository.ErrTooManyGuests
+	case res.RoomID == 1:
+		return 1, "GUEST234", nil
+	case res.RoomID == 2:
+		// room restriction insert fails
+		return 0, "", errors.New("some error")
+	case res.RoomID == 3:
+		// someone else booked the room first
+		return 0, "", repository.ErrRoomNotAvailable
+	case res.RoomID > 3:
+		return 0, "", errors.New("error: failed to enter new reservation")
+	}
+	return 0, "", nil
+}
+
 // SearchAvailabilityByDatesByRoomID return true if availability exists for room id,
 // and return false if no availability exists
-func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
 
 	return false, nil
 }
@@ -47,7 +82,7 @@ func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roo
 // search for availability not for a given room, but all rooms
 // return whether there is availability and also returns the
 // actual rooms for which there is availablity, if any for a given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int) ([]models.Room, error) {
 
 	var rooms []models.Room
 	today := time.Now()
@@ -55,92 +90,826 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 		return rooms, errors.New("DB error")
 	}
 
-	if start == end {
+	if start == end || guests > 4 {
 		return rooms, nil
 	}
 
 	rooms = append(rooms, models.Room{
 		ID:       1,
 		RoomName: "some-room",
+		Capacity: 4,
 	})
 
 	return rooms, nil
 }
 
 // GetRoomByID gets a room by id
-func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
+func (m *testDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
 	var room models.Room
 	if id > 2 {
 		return room, errors.New("some error")
 	}
+	room.ID = id
+	room.Capacity = 2
+	room.BaseRate = 10000
 	return room, nil
 }
 
+// GetRoomBySlug gets a room by slug
+func (m *testDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	var room models.Room
+	switch slug {
+	case "generals-quarters":
+		room = models.Room{
+			ID:          1,
+			RoomName:    "General's Quarters",
+			Slug:        slug,
+			Description: "some description",
+			Amenities:   []string{"Queen bed"},
+			Capacity:    2,
+			Active:      true,
+			Photos: []models.RoomPhoto{
+				{ID: 1, RoomID: 1, FileName: "/static/images/generals-quarters.png"},
+			},
+		}
+		return room, nil
+	case "majors-suite":
+		room = models.Room{ID: 2, RoomName: "Major's Suite", Slug: slug, Active: true}
+		return room, nil
+	case "inactive":
+		room = models.Room{ID: 2, RoomName: "Inactive Room", Slug: slug}
+		return room, nil
+	case "db-error":
+		return room, errors.New("some error")
+	}
+	return room, sql.ErrNoRows
+}
+
+// GetPhotosForRoom returns the photos of a room
+func (m *testDBRepo) GetPhotosForRoom(ctx context.Context, roomID int) ([]models.RoomPhoto, error) {
+	var photos []models.RoomPhoto
+	return photos, nil
+}
+
 //GetUserByID returns a user by id
-func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
-	var u models.User
-	return u, nil
+func (m *testDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	switch id {
+	case 1:
+		// the only owner
+		return models.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "me@here.ca", AccessLevel: int(models.RoleOwner)}, nil
+	case 2:
+		return models.User{ID: 2, FirstName: "Jane", LastName: "Desk", Email: "jane@here.ca", AccessLevel: int(models.RoleFrontDesk)}, nil
+	case 3:
+		return models.User{ID: 3, FirstName: "Old", LastName: "Timer", Email: "old@here.ca", AccessLevel: int(models.RoleViewer),
+			DisabledAt: time.Date(2049, 1, 1, 0, 0, 0, 0, time.UTC)}, nil
+	case 5:
+		// logs in with a code from testTOTPSecret as well as a password
+		return models.User{ID: 5, FirstName: "Tess", LastName: "Factor", Email: "two@here.ca", AccessLevel: int(models.RoleManager),
+			TwoFactorEnabledAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}, nil
+	}
+	if id > 100 {
+		return models.User{}, errors.New("some error")
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+// GetUserByEmail returns the user with an email address, ignoring case
+func (m *testDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	switch strings.ToLower(email) {
+	case "me@here.ca":
+		return m.GetUserByID(ctx, 1)
+	case "jane@here.ca":
+		return m.GetUserByID(ctx, 2)
+	case "old@here.ca":
+		return m.GetUserByID(ctx, 3)
+	case "two@here.ca":
+		return m.GetUserByID(ctx, 5)
+	case "dberror@here.ca":
+		return models.User{}, errors.New("some error")
+	}
+	return models.User{}, sql.ErrNoRows
+}
 
+// InsertUser adds a user, storing a bcrypt hash of password
+func (m *testDBRepo) InsertUser(ctx context.Context, u models.User, password string) (int, error) {
+	if u.LastName == "Fail" {
+		return 0, errors.New("some error")
+	}
+	return 4, nil
 }
 
 // UpdateUser update the user in the database
-func (m *testDBRepo) UpdateUser(u models.User) error {
+func (m *testDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	if u.ID == 1 && models.Role(u.AccessLevel) != models.RoleOwner {
+		return repository.ErrLastOwner
+	}
+	return nil
+}
+
+// UpdatePassword replaces the password of a user with a bcrypt hash of password
+func (m *testDBRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	if id > 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
 
+// UpdateActiveForUser enables or disables a user; disabled users can't log in
+func (m *testDBRepo) UpdateActiveForUser(ctx context.Context, id int, active bool) error {
+	if id == 1 && !active {
+		return repository.ErrLastOwner
+	}
+	if id > 100 {
+		return errors.New("some error")
+	}
 	return nil
 }
 
 // Authenticate authenticate a user
-func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	if email == "me@here.ca" {
+func (m *testDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	switch email {
+	case "me@here.ca":
 		return 1, "", nil
+	case "two@here.ca":
+		return 5, "", nil
+	case "dberror@here.ca":
+		return 0, "", errors.New("some error")
+	}
+	return 0, "", repository.ErrInvalidCredentials
+}
+
+// testTOTPSecret is the two-factor secret of user 5
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// GetTwoFactor returns the two-factor login secret of a user; only user 5 has one
+func (m *testDBRepo) GetTwoFactor(ctx context.Context, userID int) (models.TwoFactor, error) {
+	u, err := m.GetUserByID(ctx, userID)
+	if err != nil {
+		return models.TwoFactor{}, err
+	}
+	f := models.TwoFactor{UserID: u.ID, EnabledAt: u.TwoFactorEnabledAt}
+	if !f.EnabledAt.IsZero() {
+		f.Secret = testTOTPSecret
+		f.RecoveryCodesLeft = 9
+	}
+	return f, nil
+}
+
+// EnableTwoFactor turns on two-factor login; users above 100 fail
+func (m *testDBRepo) EnableTwoFactor(ctx context.Context, userID int, secret string, step int64, codeHashes []string) error {
+	if userID > 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DisableTwoFactor turns off two-factor login; users above 100 fail
+func (m *testDBRepo) DisableTwoFactor(ctx context.Context, userID int) error {
+	if userID > 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UseTwoFactorCode records that a user logged in with the code of step
+func (m *testDBRepo) UseTwoFactorCode(ctx context.Context, userID int, step int64) error {
+	return nil
+}
+
+// UseRecoveryCode uses up a recovery code; only ABCDE-FGHJK is known
+func (m *testDBRepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	if codeHash == signer.HashToken("ABCDE-FGHJK") {
+		return nil
+	}
+	return repository.ErrInvalidTwoFactorCode
+}
+
+// ReplaceRecoveryCodes gives a user new recovery codes; users above 100 fail
+func (m *testDBRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	if userID > 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// InsertUserSession records that a user logged in; users above 100 fail
+func (m *testDBRepo) InsertUserSession(ctx context.Context, s models.UserSession) error {
+	if s.UserID > 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// TouchUserSession records that a session was used; the key "ended-key" has been ended
+func (m *testDBRepo) TouchUserSession(ctx context.Context, key string, userID int) error {
+	if key == "ended-key" {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AllUserSessions returns the sessions of a user, with the keys "current-key" and "other-key"
+func (m *testDBRepo) AllUserSessions(ctx context.Context, userID int) ([]models.UserSession, error) {
+	if userID > 100 {
+		return nil, errors.New("some error")
+	}
+	return []models.UserSession{
+		{ID: 1, UserID: userID, Key: "current-key", IP: "10.0.0.1", UserAgent: "Firefox",
+			CreatedAt: time.Now().Add(-time.Hour), LastSeenAt: time.Now()},
+		{ID: 2, UserID: userID, Key: "other-key", IP: "10.0.0.2", UserAgent: "Safari",
+			CreatedAt: time.Now().Add(-2 * time.Hour), LastSeenAt: time.Now().Add(-time.Hour)},
+	}, nil
+}
+
+// DeleteUserSession ends one session of a user; sessions above 100 fail
+func (m *testDBRepo) DeleteUserSession(ctx context.Context, userID, id int) error {
+	switch {
+	case id == 1 || id == 2:
+		return nil
+	case id > 100:
+		return errors.New("some error")
+	}
+	return sql.ErrNoRows
+}
+
+// DeleteUserSessionByKey forgets a session when its user logs out
+func (m *testDBRepo) DeleteUserSessionByKey(ctx context.Context, key string) error {
+	return nil
+}
+
+// DeleteUserSessions ends every session of a user but one; users above 100 fail
+func (m *testDBRepo) DeleteUserSessions(ctx context.Context, userID int, exceptKey string) (int, error) {
+	if userID > 100 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// DeleteStaleUserSessions forgets the sessions that haven't been used since before
+func (m *testDBRepo) DeleteStaleUserSessions(ctx context.Context, before time.Time) (int, error) {
+	return 0, nil
+}
+
+// GetLoginThrottles returns the failed logins counted for an IP address and an email address.
+// The address 10.0.0.66 and locked@here.ca are locked out, and lockerror@here.ca fails.
+func (m *testDBRepo) GetLoginThrottles(ctx context.Context, ip, email string) ([]models.LoginThrottle, error) {
+	var throttles []models.LoginThrottle
+	if email == "lockerror@here.ca" {
+		return throttles, errors.New("some error")
+	}
+	locked := time.Now().Add(time.Minute)
+	if ip == "10.0.0.66" {
+		throttles = append(throttles, models.LoginThrottle{ID: 1, Scope: models.LoginScopeIP, Key: ip, Failures: 20, LockedUntil: locked})
+	}
+	if email == "locked@here.ca" {
+		throttles = append(throttles, models.LoginThrottle{ID: 2, Scope: models.LoginScopeAccount, Key: email, Failures: 5, LockedUntil: locked})
+	}
+	return throttles, nil
+}
+
+// RecordLoginFailure counts a failed login. The failure for lastchance@here.ca
+// locks it out, and recording one for failure@here.ca fails.
+func (m *testDBRepo) RecordLoginFailure(ctx context.Context, scope, key string, p throttle.Policy) (models.LoginThrottle, error) {
+	l := models.LoginThrottle{ID: 1, Scope: scope, Key: key, Failures: 1, LastFailureAt: time.Now()}
+	switch key {
+	case "failure@here.ca":
+		return l, errors.New("some error")
+	case "lastchance@here.ca":
+		l.Failures = p.MaxFailures
+		l.LockedUntil = time.Now().Add(p.LockoutFor(l.Failures))
+	}
+	return l, nil
+}
+
+// ClearLoginFailures forgets the failed logins for the key of a scope
+func (m *testDBRepo) ClearLoginFailures(ctx context.Context, scope, key string) error {
+	return nil
+}
+
+// AllLoginLockouts returns the email and IP addresses that are locked out now
+func (m *testDBRepo) AllLoginLockouts(ctx context.Context) ([]models.LoginThrottle, error) {
+	locked := time.Now().Add(time.Minute)
+	return []models.LoginThrottle{
+		{ID: 1, Scope: models.LoginScopeIP, Key: "10.0.0.66", Failures: 20, LockedUntil: locked},
+		{ID: 2, Scope: models.LoginScopeAccount, Key: "locked@here.ca", Failures: 5, LockedUntil: locked},
+	}, nil
+}
+
+// UnlockLogin forgets the failed logins of a lockout; ids above 100 fail
+func (m *testDBRepo) UnlockLogin(ctx context.Context, id int) (models.LoginThrottle, error) {
+	lockouts, _ := m.AllLoginLockouts(ctx)
+	for _, l := range lockouts {
+		if l.ID == id {
+			return l, nil
+		}
 	}
-	return 0, "", errors.New("some error")
+	if id > 100 {
+		return models.LoginThrottle{}, errors.New("some error")
+	}
+	return models.LoginThrottle{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) InsertOutboxMessage(ctx context.Context, msg models.MailData) (int, error) {
+	if msg.To == "outboxerror@here.ca" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) MarkOutboxSent(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *testDBRepo) MarkOutboxFailed(ctx context.Context, id int, lastError string, retryAt time.Time, dead bool) error {
+	return nil
+}
+
+func (m *testDBRepo) AllOutboxMessages(ctx context.Context, status string) ([]models.OutboxMessage, error) {
+	now := time.Now()
+	all := []models.OutboxMessage{
+		{ID: 1, Mail: models.MailData{To: "john@smith.ca", Subject: "Reservation Confirmation", Content: "<strong>Hello</strong>",
+			Attachments: []models.Attachment{{Name: "reservation.ics", ContentType: "text/calendar; charset=utf-8; method=REQUEST", Data: []byte("BEGIN:VCALENDAR")}}},
+			Status: models.OutboxPending, NextAttemptAt: now, CreatedAt: now},
+		{ID: 2, Mail: models.MailData{To: "me@here.com", Subject: "Reservation Notification", Content: "<strong>Hello</strong>", Text: "Hello\n"}, Status: models.OutboxSent, SentAt: now, CreatedAt: now},
+		{ID: 3, Mail: models.MailData{To: "jane@smith.ca", Subject: "Reservation Cancelled", Content: "<strong>Hello</strong>"}, Status: models.OutboxDead, Attempts: 8, LastError: "connection refused", CreatedAt: now},
+	}
+
+	var messages []models.OutboxMessage
+	for _, o := range all {
+		if status == "" || o.Status == status {
+			messages = append(messages, o)
+		}
+	}
+	return messages, nil
+}
+
+func (m *testDBRepo) GetOutboxMessage(ctx context.Context, id int) (models.OutboxMessage, error) {
+	messages, _ := m.AllOutboxMessages(ctx, "")
+	for _, o := range messages {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+	if id > 100 {
+		return models.OutboxMessage{}, errors.New("some error")
+	}
+	return models.OutboxMessage{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) ResendOutboxMessage(ctx context.Context, id int) error {
+	if id == 2 || id == 3 {
+		return nil
+	}
+	if id > 100 {
+		return errors.New("some error")
+	}
+	// message 1 is still waiting to be sent
+	return sql.ErrNoRows
 }
-func (m *testDBRepo) AllReservation() ([]models.Reservation, error) {
+
+func (m *testDBRepo) AllReservation(ctx context.Context, status models.ReservationStatus) ([]models.Reservation, error) {
 	var reservations []models.Reservation
+	if status == models.StatusNoShow {
+		return reservations, errors.New("some error")
+	}
 	return reservations, nil
 }
-func (m *testDBRepo) AllNewReservation() ([]models.Reservation, error) {
+func (m *testDBRepo) AllNewReservation(ctx context.Context) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 	return reservations, nil
 }
-func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+func (m *testDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
 	var r models.Reservation
+	switch id {
+	case 1, 7:
+		// a paid reservation far in the future
+		r = models.Reservation{
+			ID:         id,
+			Code:       "GUEST234",
+			FirstName:  "John",
+			LastName:   "Smith",
+			Email:      "john@smith.com",
+			StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:    time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			RoomID:     1,
+			TotalPrice: 20000,
+			Status:     models.StatusConfirmed,
+			Room:       models.Room{ID: 1, RoomName: "General's Quarters"},
+		}
+		if id == 7 {
+			r.Code = "RACE7777"
+		}
+	case 5:
+		// already cancelled
+		r = models.Reservation{
+			ID:          id,
+			Code:        "CANC5555",
+			StartDate:   time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:     time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			CancelledAt: time.Date(2049, 1, 1, 0, 0, 0, 0, time.UTC),
+			Status:      models.StatusCancelled,
+		}
+	case 6:
+		// the guest arrives today
+		now := time.Now()
+		r = models.Reservation{
+			ID:         id,
+			Code:       "TDAY6666",
+			Email:      "john@smith.com",
+			StartDate:  time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+			EndDate:    time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC),
+			TotalPrice: 10000,
+			Status:     models.StatusPending,
+		}
+	}
 	return r, nil
 }
 
-func (m *testDBRepo) UpdateReservation(r models.Reservation) error {
+// GetReservationByCode returns the reservation of GetReservationByID with the booking code
+func (m *testDBRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	switch code {
+	case "GUEST234":
+		return m.GetReservationByID(ctx, 1)
+	case "CANC5555":
+		return m.GetReservationByID(ctx, 5)
+	case "TDAY6666":
+		return m.GetReservationByID(ctx, 6)
+	case "RACE7777":
+		return m.GetReservationByID(ctx, 7)
+	case "DBERR999":
+		return models.Reservation{}, errors.New("some error")
+	}
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
+	return nil
+}
+func (m *testDBRepo) DeleteReservation(ctx context.Context, id, userID int) error {
+	if id > 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// RestoreReservation takes a reservation out of the trash
+func (m *testDBRepo) RestoreReservation(ctx context.Context, id int) error {
+	switch {
+	case id == 3:
+		// the dates were booked in the meantime
+		return repository.ErrRoomNotAvailable
+	case id > 100:
+		return errors.New("some error")
+	}
 	return nil
 }
-func (m *testDBRepo) DeleteReservation(id int) error {
 
+// PurgeReservation deletes a reservation in the trash for good; reservation 7 has payments
+func (m *testDBRepo) PurgeReservation(ctx context.Context, id int) error {
+	if id == 7 {
+		return repository.ErrReservationHasPayments
+	}
+	if id > 100 {
+		return errors.New("some error")
+	}
 	return nil
+}
+
+// PurgeDeletedReservations deletes the reservations moved to the trash before before
+func (m *testDBRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	return 0, nil
+}
 
+// AllDeletedReservations returns the reservations in the trash
+func (m *testDBRepo) AllDeletedReservations(ctx context.Context) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	reservations = append(reservations, models.Reservation{
+		ID:            8,
+		Code:          "TRSH8888",
+		LastName:      "Smith",
+		StartDate:     time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Status:        models.StatusConfirmed,
+		DeletedAt:     time.Date(2049, 1, 1, 0, 0, 0, 0, time.UTC),
+		DeletedBy:     1,
+		DeletedByName: "Admin User",
+		Room:          models.Room{ID: 1, RoomName: "General's Quarters"},
+	})
+	return reservations, nil
+}
+
+// CancelReservation marks a reservation as cancelled and frees its room
+func (m *testDBRepo) CancelReservation(ctx context.Context, id, fee int) error {
+	if id == 7 {
+		// someone else cancelled it first
+		return repository.ErrReservationCancelled
+	}
+	return nil
 }
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
 
+// TransitionReservation moves a reservation to a new status
+func (m *testDBRepo) TransitionReservation(ctx context.Context, id int, to models.ReservationStatus, userID int) error {
+	switch {
+	case id == 2:
+		return repository.ErrInvalidTransition
+	case id > 100:
+		return errors.New("some error")
+	}
 	return nil
 }
 
-func (m *testDBRepo) AllRooms() ([]models.Room, error) {
+// GetStatusHistory returns the status changes of a reservation
+func (m *testDBRepo) GetStatusHistory(ctx context.Context, reservationID int) ([]models.ReservationStatusChange, error) {
+	var changes []models.ReservationStatusChange
+	if reservationID > 100 {
+		return changes, errors.New("some error")
+	}
+	changes = append(changes, models.ReservationStatusChange{
+		ID:            1,
+		ReservationID: reservationID,
+		FromStatus:    models.StatusPending,
+		ToStatus:      models.StatusConfirmed,
+		UserID:        1,
+		UserName:      "Admin User",
+	})
+	return changes, nil
+}
+
+func (m *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
 	var rooms []models.Room
 
 	return rooms, nil
 }
-func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+
+// InsertRoom inserts a new room with its photos
+func (m *testDBRepo) InsertRoom(ctx context.Context, r models.Room) (int, error) {
+	if r.RoomName == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// UpdateRoom updates a room and its photos
+func (m *testDBRepo) UpdateRoom(ctx context.Context, r models.Room) error {
+	if r.ID > 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdateActiveForRoom activates or deactivates a room
+func (m *testDBRepo) UpdateActiveForRoom(ctx context.Context, id int, active bool) error {
+	if id > 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeleteRoom deletes a room; room 2 has reservations
+func (m *testDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	if id == 2 {
+		return repository.ErrRoomHasReservations
+	}
+	if id > 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// CountFutureReservationsForRoom returns the number of upcoming reservations
+func (m *testDBRepo) CountFutureReservationsForRoom(ctx context.Context, roomID int) (int, error) {
+	if roomID == 2 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m *testDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
 	var restrictions []models.RoomRestriction
 
 	return restrictions, nil
 }
 
 // InsertBlockForRoom inserts a room restriction
-func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+func (m *testDBRepo) InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error {
 
 	return nil
 }
 
 // DeleteBlocksByID deletes a room restriction
-func (m *testDBRepo) DeleteBlockByID(id int) error {
+func (m *testDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
 
 	return nil
 }
+
+// GetSeasonalRatesForRoom returns the seasonal rates of a room overlapping a stay
+func (m *testDBRepo) GetSeasonalRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	var seasons []models.SeasonalRate
+	if roomID == 2 {
+		return seasons, errors.New("some error")
+	}
+	return seasons, nil
+}
+
+// AllSeasonalRatesForRoom returns all seasonal rates of a room
+func (m *testDBRepo) AllSeasonalRatesForRoom(ctx context.Context, roomID int) ([]models.SeasonalRate, error) {
+	var seasons []models.SeasonalRate
+	if roomID > 2 {
+		return seasons, errors.New("some error")
+	}
+	return seasons, nil
+}
+
+// InsertSeasonalRate inserts a seasonal rate
+func (m *testDBRepo) InsertSeasonalRate(ctx context.Context, s models.SeasonalRate) (int, error) {
+	if s.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// DeleteSeasonalRate deletes a seasonal rate
+func (m *testDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	if id > 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// testPromoCode returns a promo code that can be used with any test reservation
+func testPromoCode(id int, code string) models.PromoCode {
+	return models.PromoCode{
+		ID:           id,
+		Code:         code,
+		DiscountType: "percent",
+		Amount:       10,
+		ValidFrom:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		ValidTo:      time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC),
+		MinNights:    1,
+		Active:       true,
+	}
+}
+
+// AllPromoCodes returns all promo codes
+func (m *testDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	return []models.PromoCode{testPromoCode(1, "SAVE10")}, nil
+}
+
+// GetPromoCodeByID returns a promo code by id
+func (m *testDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	switch id {
+	case 1:
+		return testPromoCode(1, "SAVE10"), nil
+	case 2:
+		// a promo code that has been redeemed
+		p := testPromoCode(2, "USED")
+		p.Redemptions = 3
+		return p, nil
+	}
+	return models.PromoCode{}, errors.New("some error")
+}
+
+// GetPromoCodeByCode returns a promo code by code
+func (m *testDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	switch strings.ToUpper(code) {
+	case "SAVE10":
+		return testPromoCode(1, "SAVE10"), nil
+	case "EXPIRED":
+		p := testPromoCode(2, "EXPIRED")
+		p.ValidTo = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+		return p, nil
+	case "RACE":
+		// valid when checked, but used up by the time the reservation is saved
+		return testPromoCode(3, "RACE"), nil
+	case "DBERROR":
+		return models.PromoCode{}, errors.New("some error")
+	}
+	return models.PromoCode{}, sql.ErrNoRows
+}
+
+// InsertPromoCode inserts a promo code
+func (m *testDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	if p.Code == "FAIL" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// UpdatePromoCode updates a promo code
+func (m *testDBRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) error {
+	if p.ID > 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeletePromoCode deletes a promo code
+func (m *testDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	if id > 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// InsertPayment records a card transaction
+func (m *testDBRepo) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	return 1, nil
+}
+
+// GetPaymentsForReservation returns the payment records of a reservation
+func (m *testDBRepo) GetPaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	var payments []models.Payment
+	if reservationID > 100 {
+		return payments, errors.New("some error")
+	}
+	payments = append(payments, models.Payment{
+		ID:            1,
+		ReservationID: reservationID,
+		Provider:      "fake",
+		TransactionID: "fake_000001",
+		Amount:        20000,
+		Currency:      "USD",
+		Status:        "captured",
+		CardLast4:     "4242",
+	})
+	return payments, nil
+}
+
+// UpdatePayment saves the status and refunded amount of a payment
+func (m *testDBRepo) UpdatePayment(ctx context.Context, p models.Payment) error {
+	return nil
+}
+
+// InsertAuditEntry records a change made in the admin tool
+func (m *testDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) error {
+	return nil
+}
+
+// AllAuditEntries returns the newest entries of the audit log that match f
+func (m *testDBRepo) AllAuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	if f.UserID > 100 {
+		return entries, errors.New("some error")
+	}
+	entries = append(entries, models.AuditEntry{
+		ID:       1,
+		UserID:   1,
+		UserName: "Admin User",
+		Action:   "update",
+		Entity:   "reservation",
+		EntityID: 1,
+		Changes: map[string]models.AuditChange{
+			"Phone": {Before: "555-0100", After: "555-0199"},
+		},
+		CreatedAt: time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC),
+	})
+	return entries, nil
+}
+
+// AllAuditUsers returns the users that appear in the audit log
+func (m *testDBRepo) AllAuditUsers(ctx context.Context) ([]models.User, error) {
+	return []models.User{{ID: 1, FirstName: "Admin", LastName: "User"}}, nil
+}
+
+// InsertPasswordReset stores a password reset request
+func (m *testDBRepo) InsertPasswordReset(ctx context.Context, p models.PasswordReset) error {
+	if p.UserID > 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// GetPasswordReset returns the password reset request with a token hash.
+// The tokens are "valid-token", "used-token", "expired-token" and "dberror-token"
+func (m *testDBRepo) GetPasswordReset(ctx context.Context, tokenHash string) (models.PasswordReset, error) {
+	p := models.PasswordReset{ID: 1, UserID: 2, TokenHash: tokenHash, ExpiresAt: time.Now().Add(time.Hour)}
+	switch tokenHash {
+	case signer.HashToken("valid-token"):
+		return p, nil
+	case signer.HashToken("used-token"):
+		p.UsedAt = time.Now().Add(-time.Minute)
+		return p, nil
+	case signer.HashToken("expired-token"):
+		p.ExpiresAt = time.Now().Add(-time.Minute)
+		return p, nil
+	case signer.HashToken("dberror-token"):
+		return models.PasswordReset{}, errors.New("some error")
+	}
+	return models.PasswordReset{}, sql.ErrNoRows
+}
+
+// ResetPassword sets a new password for the user of a usable reset token
+func (m *testDBRepo) ResetPassword(ctx context.Context, tokenHash, password string) (int, error) {
+	p, err := m.GetPasswordReset(ctx, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !p.Usable(time.Now())) {
+		return 0, repository.ErrInvalidResetToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	return p.UserID, nil
+}
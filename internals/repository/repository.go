@@ -1,47 +1,220 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/byt3er/bookings/internals/models"
+	"github.com/byt3er/bookings/internals/throttle"
 )
 
+// ErrRoomNotAvailable is returned when the room was booked by someone else
+// before the reservation could be saved
+var ErrRoomNotAvailable = errors.New("room is no longer available for the selected dates")
+
+// ErrRoomHasReservations is returned when deleting a room that has ever been
+// reserved, as deleting it would lose the history of its reservations
+var ErrRoomHasReservations = errors.New("room has reservations")
+
+// ErrTooManyGuests is returned when a reservation has more guests
+// than the room can sleep
+var ErrTooManyGuests = errors.New("too many guests for this room")
+
+// ErrPromoCodeExhausted is returned when a promo code reached its maximum
+// number of redemptions before the reservation could be saved
+var ErrPromoCodeExhausted = errors.New("promo code has been used up")
+
+// ErrReservationHasPayments is returned when purging a reservation that has
+// payments, which are kept as the record of the money taken and refunded
+var ErrReservationHasPayments = errors.New("reservation has payments")
+
+// ErrInvalidTransition is returned when a reservation can't move
+// from its current status to the requested one
+var ErrInvalidTransition = errors.New("reservation status change is not allowed")
+
+// ErrReservationCancelled is returned when cancelling a reservation
+// that has already been cancelled
+var ErrReservationCancelled = errors.New("reservation has already been cancelled")
+
+// ErrLastOwner is returned when a change would leave no active user
+// with the owner role, so nobody could manage users any more
+var ErrLastOwner = errors.New("there must be at least one active owner")
+
+// ErrInvalidResetToken is returned when a password reset token is unknown,
+// has been used or has expired
+var ErrInvalidResetToken = errors.New("password reset token is invalid or has expired")
+
+// ErrInvalidCredentials is returned by Authenticate for an unknown or disabled
+// email address as well as a wrong password, so the two can't be told apart
+var ErrInvalidCredentials = errors.New("invalid login credentials")
+
+// ErrInvalidTwoFactorCode is returned when a recovery code is unknown or has been used,
+// or when a code from an authenticator app has been used before
+var ErrInvalidTwoFactorCode = errors.New("two-factor code is invalid or has been used")
+
 type DatabaseRepo interface {
-	AllUsers() bool
+	AllUsers(ctx context.Context) ([]models.User, error)
+
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error
+
+	InsertReservationWithRestriction(ctx context.Context, res models.Reservation) (int, string, error)
+
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomId int) (bool, error)
+
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int) ([]models.Room, error)
+
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+
+	GetPhotosForRoom(ctx context.Context, roomID int) ([]models.RoomPhoto, error)
+
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+
+	InsertUser(ctx context.Context, u models.User, password string) (int, error)
+
+	UpdateUser(ctx context.Context, u models.User) error
+
+	UpdatePassword(ctx context.Context, id int, password string) error
+
+	UpdateActiveForUser(ctx context.Context, id int, active bool) error
+
+	InsertPasswordReset(ctx context.Context, p models.PasswordReset) error
+
+	GetPasswordReset(ctx context.Context, tokenHash string) (models.PasswordReset, error)
+
+	ResetPassword(ctx context.Context, tokenHash, password string) (int, error)
+
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+
+	GetTwoFactor(ctx context.Context, userID int) (models.TwoFactor, error)
+
+	EnableTwoFactor(ctx context.Context, userID int, secret string, step int64, codeHashes []string) error
+
+	DisableTwoFactor(ctx context.Context, userID int) error
+
+	UseTwoFactorCode(ctx context.Context, userID int, step int64) error
+
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) error
+
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+
+	InsertUserSession(ctx context.Context, s models.UserSession) error
+
+	TouchUserSession(ctx context.Context, key string, userID int) error
+
+	AllUserSessions(ctx context.Context, userID int) ([]models.UserSession, error)
+
+	DeleteUserSession(ctx context.Context, userID, id int) error
+
+	DeleteUserSessionByKey(ctx context.Context, key string) error
+
+	DeleteUserSessions(ctx context.Context, userID int, exceptKey string) (int, error)
+
+	DeleteStaleUserSessions(ctx context.Context, before time.Time) (int, error)
+
+	GetLoginThrottles(ctx context.Context, ip, email string) ([]models.LoginThrottle, error)
+
+	RecordLoginFailure(ctx context.Context, scope, key string, p throttle.Policy) (models.LoginThrottle, error)
+
+	ClearLoginFailures(ctx context.Context, scope, key string) error
+
+	AllLoginLockouts(ctx context.Context) ([]models.LoginThrottle, error)
+
+	UnlockLogin(ctx context.Context, id int) (models.LoginThrottle, error)
+
+	InsertOutboxMessage(ctx context.Context, msg models.MailData) (int, error)
+
+	ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error)
+
+	MarkOutboxSent(ctx context.Context, id int) error
+
+	MarkOutboxFailed(ctx context.Context, id int, lastError string, retryAt time.Time, dead bool) error
+
+	AllOutboxMessages(ctx context.Context, status string) ([]models.OutboxMessage, error)
+
+	GetOutboxMessage(ctx context.Context, id int) (models.OutboxMessage, error)
+
+	ResendOutboxMessage(ctx context.Context, id int) error
+
+	AllReservation(ctx context.Context, status models.ReservationStatus) ([]models.Reservation, error)
+
+	AllNewReservation(ctx context.Context) ([]models.Reservation, error)
+
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+
+	GetReservationByCode(ctx context.Context, code string) (models.Reservation, error)
+
+	UpdateReservation(ctx context.Context, r models.Reservation) error
+
+	DeleteReservation(ctx context.Context, id, userID int) error
+
+	RestoreReservation(ctx context.Context, id int) error
+
+	PurgeReservation(ctx context.Context, id int) error
+
+	PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error)
+
+	AllDeletedReservations(ctx context.Context) ([]models.Reservation, error)
+
+	CancelReservation(ctx context.Context, id, fee int) error
+
+	TransitionReservation(ctx context.Context, id int, to models.ReservationStatus, userID int) error
+
+	GetStatusHistory(ctx context.Context, reservationID int) ([]models.ReservationStatusChange, error)
+
+	AllRooms(ctx context.Context) ([]models.Room, error)
+
+	InsertRoom(ctx context.Context, r models.Room) (int, error)
+
+	UpdateRoom(ctx context.Context, r models.Room) error
+
+	UpdateActiveForRoom(ctx context.Context, id int, active bool) error
+
+	DeleteRoom(ctx context.Context, id int) error
+
+	CountFutureReservationsForRoom(ctx context.Context, roomID int) (int, error)
+
+	GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
 
-	InsertReservation(res models.Reservation) (int, error)
+	InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error
 
-	InsertRoomRestriction(r models.RoomRestriction) error
+	DeleteBlockByID(ctx context.Context, id int) error
 
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomId int) (bool, error)
+	GetSeasonalRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error)
 
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
+	AllSeasonalRatesForRoom(ctx context.Context, roomID int) ([]models.SeasonalRate, error)
 
-	GetRoomByID(id int) (models.Room, error)
+	InsertSeasonalRate(ctx context.Context, s models.SeasonalRate) (int, error)
 
-	GetUserByID(id int) (models.User, error)
+	DeleteSeasonalRate(ctx context.Context, id int) error
 
-	UpdateUser(u models.User) error
+	AllPromoCodes(ctx context.Context) ([]models.PromoCode, error)
 
-	Authenticate(email, testPassword string) (int, string, error)
+	GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error)
 
-	AllReservation() ([]models.Reservation, error)
+	GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error)
 
-	AllNewReservation() ([]models.Reservation, error)
+	InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error)
 
-	GetReservationByID(id int) (models.Reservation, error)
+	UpdatePromoCode(ctx context.Context, p models.PromoCode) error
 
-	UpdateReservation(r models.Reservation) error
+	DeletePromoCode(ctx context.Context, id int) error
 
-	DeleteReservation(id int) error
+	InsertPayment(ctx context.Context, p models.Payment) (int, error)
 
-	UpdateProcessedForReservation(id, processed int) error
+	GetPaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error)
 
-	AllRooms() ([]models.Room, error)
+	UpdatePayment(ctx context.Context, p models.Payment) error
 
-	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertAuditEntry(ctx context.Context, e models.AuditEntry) error
 
-	InsertBlockForRoom(id int, startDate time.Time) error
+	AllAuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error)
 
-	DeleteBlockByID(id int) error
+	AllAuditUsers(ctx context.Context) ([]models.User, error)
 }
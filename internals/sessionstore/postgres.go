@@ -0,0 +1,98 @@
+// Package sessionstore keeps sessions in the database, so that logins and
+// reservations in progress survive a restart of the application
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// PostgresStore is an scs.Store that keeps sessions in the sessions table
+type PostgresStore struct {
+	DB      *sql.DB
+	Timeout time.Duration // how long a single query may take
+}
+
+var _ scs.CtxStore = (*PostgresStore)(nil)
+
+// New returns a store using the connection pool db
+func New(db *sql.DB, timeout time.Duration) *PostgresStore {
+	return &PostgresStore{DB: db, Timeout: timeout}
+}
+
+// Find returns the data of an unexpired session, and whether there was one
+func (p *PostgresStore) Find(token string) ([]byte, bool, error) {
+	return p.FindCtx(context.Background(), token)
+}
+
+// FindCtx is Find with a context
+func (p *PostgresStore) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	var b []byte
+	err := p.DB.QueryRowContext(ctx,
+		`select data from sessions where token = $1 and expiry > $2`,
+		token, time.Now(),
+	).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit stores the data of a session until expiry, replacing what was there
+func (p *PostgresStore) Commit(token string, b []byte, expiry time.Time) error {
+	return p.CommitCtx(context.Background(), token, b, expiry)
+}
+
+// CommitCtx is Commit with a context
+func (p *PostgresStore) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	query := `insert into sessions (token, data, expiry) values ($1, $2, $3)
+			on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`
+
+	_, err := p.DB.ExecContext(ctx, query, token, b, expiry)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Delete removes a session; deleting one that doesn't exist is not an error
+func (p *PostgresStore) Delete(token string) error {
+	return p.DeleteCtx(context.Background(), token)
+}
+
+// DeleteCtx is Delete with a context
+func (p *PostgresStore) DeleteCtx(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	_, err := p.DB.ExecContext(ctx, `delete from sessions where token = $1`, token)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteExpired removes the sessions that have expired, and returns how many there were
+func (p *PostgresStore) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	result, err := p.DB.ExecContext(ctx, `delete from sessions where expiry < $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,188 @@
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSession struct {
+	data   []byte
+	expiry time.Time
+}
+
+// testDB is a sessions table in memory, answering the queries of PostgresStore
+type testDB struct {
+	mu       sync.Mutex
+	sessions map[string]testSession
+}
+
+func (db *testDB) Connect(ctx context.Context) (driver.Conn, error) { return &testConn{db: db}, nil }
+func (db *testDB) Driver() driver.Driver                            { return nil }
+
+type testConn struct {
+	db *testDB
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+func (c *testConn) Close() error { return nil }
+func (c *testConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c *testConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	switch strings.Join(strings.Fields(query), " ") {
+	case "select data from sessions where token = $1 and expiry > $2":
+		rows := &testRows{}
+		if s, ok := c.db.sessions[args[0].Value.(string)]; ok && s.expiry.After(args[1].Value.(time.Time)) {
+			rows.data = append(rows.data, s.data)
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (c *testConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	switch strings.Join(strings.Fields(query), " ") {
+	case "insert into sessions (token, data, expiry) values ($1, $2, $3) on conflict (token) do update set data = excluded.data, expiry = excluded.expiry":
+		c.db.sessions[args[0].Value.(string)] = testSession{data: args[1].Value.([]byte), expiry: args[2].Value.(time.Time)}
+		return driver.RowsAffected(1), nil
+	case "delete from sessions where token = $1":
+		token := args[0].Value.(string)
+		if _, ok := c.db.sessions[token]; !ok {
+			return driver.RowsAffected(0), nil
+		}
+		delete(c.db.sessions, token)
+		return driver.RowsAffected(1), nil
+	case "delete from sessions where expiry < $1":
+		var n int64
+		for token, s := range c.db.sessions {
+			if s.expiry.Before(args[0].Value.(time.Time)) {
+				delete(c.db.sessions, token)
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+type testRows struct {
+	data [][]byte
+}
+
+func (r *testRows) Columns() []string { return []string{"data"} }
+func (r *testRows) Close() error      { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	dest[0], r.data = r.data[0], r.data[1:]
+	return nil
+}
+
+func newTestStore(t *testing.T) (*PostgresStore, *testDB) {
+	db := &testDB{sessions: make(map[string]testSession)}
+	conn := sql.OpenDB(db)
+	t.Cleanup(func() { conn.Close() })
+	return New(conn, time.Second), db
+}
+
+func TestPostgresStore_CommitAndFind(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	if err := store.Commit("token", []byte("first"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// committing again replaces the data
+	if err := store.Commit("token", []byte("second"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	b, found, err := store.Find("token")
+	if err != nil || !found || string(b) != "second" {
+		t.Errorf("expected the second data, but got %q, %v, %v", b, found, err)
+	}
+
+	b, found, err = store.Find("unknown")
+	if err != nil || found || b != nil {
+		t.Errorf("expected no session for an unknown token, but got %q, %v, %v", b, found, err)
+	}
+}
+
+func TestPostgresStore_Expiry(t *testing.T) {
+	store, db := newTestStore(t)
+
+	if err := store.Commit("expired", []byte("old"), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := store.Commit("live", []byte("new"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// an expired session isn't found, even before it is deleted
+	if _, found, err := store.Find("expired"); err != nil || found {
+		t.Errorf("expected the expired session not to be found, but got %v, %v", found, err)
+	}
+
+	n, err := store.DeleteExpired(context.Background())
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 expired session deleted, but got %d, %v", n, err)
+	}
+	if _, ok := db.sessions["expired"]; ok {
+		t.Error("expected the expired session to be gone")
+	}
+	if _, found, _ := store.Find("live"); !found {
+		t.Error("expected the live session to be kept")
+	}
+
+	if n, err := store.DeleteExpired(context.Background()); err != nil || n != 0 {
+		t.Errorf("expected nothing left to delete, but got %d, %v", n, err)
+	}
+}
+
+func TestPostgresStore_Delete(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	if err := store.Commit("token", []byte("data"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := store.Delete("token"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, found, err := store.Find("token"); err != nil || found {
+		t.Errorf("expected the deleted session not to be found, but got %v, %v", found, err)
+	}
+
+	// deleting a session that doesn't exist is not an error
+	if err := store.Delete("token"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// TestPostgresStore_FindCtx tests that the context of the request reaches the query
+func TestPostgresStore_FindCtx(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := store.FindCtx(ctx, "token"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled context, but got %v", err)
+	}
+}
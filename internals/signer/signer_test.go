@@ -0,0 +1,68 @@
+package signer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	s := New([]byte("secret"))
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	token := s.Sign("cancel:1", now.Add(time.Hour))
+
+	if err := s.Verify("cancel:1", token, now); err != nil {
+		t.Errorf("expected valid token, but got %v", err)
+	}
+
+	if err := s.Verify("cancel:1", token, now.Add(time.Hour)); err != ErrExpiredToken {
+		t.Errorf("expected expired token, but got %v", err)
+	}
+
+	if err := s.Verify("cancel:2", token, now); err != ErrInvalidToken {
+		t.Errorf("expected invalid token for another value, but got %v", err)
+	}
+
+	if err := New([]byte("other")).Verify("cancel:1", token, now); err != ErrInvalidToken {
+		t.Errorf("expected invalid token for another key, but got %v", err)
+	}
+
+	// pushing the expiry back breaks the signature
+	_, mac, _ := strings.Cut(token, ".")
+	if err := s.Verify("cancel:1", "9999999999."+mac, now); err != ErrInvalidToken {
+		t.Errorf("expected invalid token for a changed expiry, but got %v", err)
+	}
+
+	for _, bad := range []string{"", "nodot", "abc.def", token + "x"} {
+		if err := s.Verify("cancel:1", bad, now); err != ErrInvalidToken {
+			t.Errorf("expected invalid token for %q, but got %v", bad, err)
+		}
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	a, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewToken()
+
+	if a == b {
+		t.Error("expected two tokens to differ")
+	}
+	if len(a) != 43 || strings.ContainsAny(a, "+/=") {
+		t.Errorf("expected a URL-safe token of 43 characters, but got %q", a)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	if HashToken("abc") != HashToken("abc") {
+		t.Error("expected the same hash for the same token")
+	}
+	if HashToken("abc") == HashToken("abd") {
+		t.Error("expected different hashes for different tokens")
+	}
+	if len(HashToken("abc")) != 64 {
+		t.Errorf("expected a hex sha256 hash, but got %q", HashToken("abc"))
+	}
+}
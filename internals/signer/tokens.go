@@ -0,0 +1,28 @@
+package signer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// tokenBytes is how much randomness goes into a token made by NewToken
+const tokenBytes = 32
+
+// NewToken returns a random, URL-safe token for a single use link, e.g. to reset
+// a password. Only HashToken of it should be stored, so a leaked database can't
+// be used to follow the links.
+func NewToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hash of token that is stored and looked up instead of the token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,34 @@
+// Package throttle decides how long logins are locked out after repeated failures,
+// so that passwords can't be guessed by trying them one after another
+package throttle
+
+import "time"
+
+// Policy locks out logins once there have been MaxFailures failures in a row,
+// for Lockout at first and twice as long for every failure after that
+type Policy struct {
+	MaxFailures int           // failures allowed before the first lockout
+	Window      time.Duration // failures are forgotten after this long without another one
+	Lockout     time.Duration // length of the first lockout
+	MaxLockout  time.Duration // longest a lockout can get
+}
+
+// LockoutFor returns how long logins are locked out after failures failures in a row,
+// or zero when they are not locked out yet
+func (p Policy) LockoutFor(failures int) time.Duration {
+	if p.MaxFailures <= 0 || failures < p.MaxFailures {
+		return 0
+	}
+
+	d := p.Lockout
+	for i := p.MaxFailures; i < failures; i++ {
+		if p.MaxLockout > 0 && d >= p.MaxLockout {
+			break
+		}
+		d *= 2
+	}
+	if p.MaxLockout > 0 && d > p.MaxLockout {
+		d = p.MaxLockout
+	}
+	return d
+}
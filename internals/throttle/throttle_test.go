@@ -0,0 +1,37 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+var lockoutTests = []struct {
+	name     string
+	failures int
+	expected time.Duration
+}{
+	{"no-failures", 0, 0},
+	{"below-limit", 4, 0},
+	{"at-limit", 5, time.Minute},
+	{"one-over", 6, 2 * time.Minute},
+	{"three-over", 8, 8 * time.Minute},
+	{"capped", 12, time.Hour},
+	{"far-over", 1000, time.Hour},
+}
+
+func TestPolicy_LockoutFor(t *testing.T) {
+	p := Policy{MaxFailures: 5, Window: 15 * time.Minute, Lockout: time.Minute, MaxLockout: time.Hour}
+
+	for _, e := range lockoutTests {
+		if got := p.LockoutFor(e.failures); got != e.expected {
+			t.Errorf("%s: expected %s, but got %s", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestPolicy_LockoutForDisabled(t *testing.T) {
+	var p Policy
+	if got := p.LockoutFor(100); got != 0 {
+		t.Errorf("expected no lockout without a limit, but got %s", got)
+	}
+}
@@ -0,0 +1,45 @@
+package totp
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// RecoveryCodes is how many recovery codes a user gets at a time
+const RecoveryCodes = 10
+
+// recoveryAlphabet leaves out letters and digits that are easy to mix up
+const recoveryAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// NewRecoveryCodes returns n random codes written like ABCDE-FGHJK,
+// each of which can be used once instead of a code from the app
+func NewRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	max := big.NewInt(int64(len(recoveryAlphabet)))
+	for i := range codes {
+		var b strings.Builder
+		for j := 0; j < 10; j++ {
+			if j == 5 {
+				b.WriteByte('-')
+			}
+			k, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteByte(recoveryAlphabet[k.Int64()])
+		}
+		codes[i] = b.String()
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode puts a recovery code the way a user typed it
+// into the form it was given out in
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
@@ -0,0 +1,130 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the test vectors in RFC 6238
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// the RFC gives 8 digit codes; these are their last 6 digits
+var codeTests = []struct {
+	unix     int64
+	expected string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCode(t *testing.T) {
+	for _, e := range codeTests {
+		got, err := Code(rfcSecret, Step(time.Unix(e.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != e.expected {
+			t.Errorf("at %d: expected %s, but got %s", e.unix, e.expected, got)
+		}
+	}
+}
+
+func TestCodeBadSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("expected an error for a secret that isn't base32")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, Step(now))
+
+	step, ok := Verify(rfcSecret, code, now)
+	if !ok || step != Step(now) {
+		t.Errorf("expected the current code to verify at step %d, but got %d %v", Step(now), step, ok)
+	}
+
+	// a clock that is one period off is allowed for
+	if _, ok := Verify(rfcSecret, code, now.Add(Period)); !ok {
+		t.Error("expected the code from the last period to verify")
+	}
+	if _, ok := Verify(rfcSecret, code, now.Add(3*Period)); ok {
+		t.Error("expected an old code not to verify")
+	}
+
+	if _, ok := Verify(rfcSecret, "123", now); ok {
+		t.Error("expected a short code not to verify")
+	}
+	if _, ok := Verify(rfcSecret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("expected spaces in a code to be ignored")
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	s, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 32 {
+		t.Errorf("expected 32 characters, but got %d", len(s))
+	}
+	if _, err := Code(s, 1); err != nil {
+		t.Errorf("expected a usable secret, but got %s", err)
+	}
+
+	other, _ := NewSecret()
+	if s == other {
+		t.Error("expected secrets to differ")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Fort Smythe", "me@here.ca", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Fort%20Smythe:me@here.ca?") {
+		t.Errorf("unexpected label in %s", uri)
+	}
+	for _, want := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=Fort+Smythe", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("expected %s in %s", want, uri)
+		}
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(RecoveryCodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodes {
+		t.Fatalf("expected %d codes, but got %d", RecoveryCodes, len(codes))
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' {
+			t.Errorf("unexpected code %s", c)
+		}
+		if strings.ContainsAny(c, "01IOL") {
+			t.Errorf("code %s has characters that are easy to mix up", c)
+		}
+		if seen[c] {
+			t.Errorf("code %s given out twice", c)
+		}
+		seen[c] = true
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, typed := range []string{"ABCDE-FGHJK", "abcde-fghjk", "ABCDEFGHJK", " abcde fghjk "} {
+		if got := NormalizeRecoveryCode(typed); got != "ABCDE-FGHJK" {
+			t.Errorf("%q: expected ABCDE-FGHJK, but got %s", typed, got)
+		}
+	}
+}
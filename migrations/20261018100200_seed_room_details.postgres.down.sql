@@ -0,0 +1,3 @@
+delete from room_photos;
+DROP INDEX IF EXISTS public.rooms_slug_idx;
+update rooms set slug = '', description = '', amenities = '';
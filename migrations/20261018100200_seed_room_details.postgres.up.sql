@@ -0,0 +1,21 @@
+UPDATE public.rooms SET
+	slug = 'generals-quarters',
+	description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.',
+	amenities = E'Queen bed\nOcean view\nPrivate bathroom\nBreakfast included'
+	WHERE room_name = 'Generals Quarters';
+
+UPDATE public.rooms SET
+	slug = 'majors-suite',
+	description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.',
+	amenities = E'King bed\nSitting room\nPrivate bathroom\nBreakfast included'
+	WHERE room_name = 'Majors suit';
+
+-- any other room gets a slug based on its id, so the unique index can be created
+UPDATE public.rooms SET slug = 'room-' || id WHERE slug = '';
+
+CREATE UNIQUE INDEX rooms_slug_idx ON public.rooms USING btree (slug);
+
+INSERT INTO public.room_photos (room_id,file_name,caption,sort_order,created_at,updated_at)
+	SELECT id,'/static/images/generals-quarters.png','General''s Quarters',0,now(),now() FROM public.rooms WHERE slug = 'generals-quarters';
+INSERT INTO public.room_photos (room_id,file_name,caption,sort_order,created_at,updated_at)
+	SELECT id,'/static/images/marjors-suite.png','Major''s Suite',0,now(),now() FROM public.rooms WHERE slug = 'majors-suite';
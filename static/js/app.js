@@ -120,7 +120,7 @@ function Prompt() {
     }
 }
 
-function checkAvailabilityButton(room_id){
+function checkAvailabilityButton(room_id, csrf_token){
     document.getElementById("check-availability-button").addEventListener("click", function () {
         let html = `
         <form id="check-availability-form" action="" method="post" novalidate class="needs-validation">
@@ -160,7 +160,7 @@ function checkAvailabilityButton(room_id){
                 var data = new FormData();    
                 data.append("start", document.getElementById("start").value);
                 data.append("end", document.getElementById("end").value);
-                data.append("csrf_token", "jHKd9Gkzd268m1XvhEX8JeWLyOkM\/euPhgz\/gYUDsDs7eQ0uLNLNJVgTjWhP4fZ\u002b4vYizwMvyE07xu\/e59Z06Q==");
+                data.append("csrf_token", csrf_token);
                 data.append("room_id",room_id);
                 
                 